@@ -0,0 +1,147 @@
+package build
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// atomicFile is written next to its destination under a temporary name and only renamed
+// over the destination once everything is written, so nobody ever sees half a file
+type atomicFile struct {
+	*os.File
+	dest string
+	done bool
+}
+
+// tempName is a name next to dest for a file that is renamed over dest once it is done
+func tempName(dest string) string {
+	dir, base := filepath.Split(dest)
+	return filepath.Join(dir, "."+base+".rome-"+strconv.FormatUint(uint64(rand.Uint32()), 36))
+}
+
+// renameInto makes a file under a temporary name next to dest with create and renames it
+// over dest, a name that is already taken is tried again with another one
+func renameInto(dest string, create func(tmp string) error) error {
+	for {
+		tmp := tempName(dest)
+		err := create(tmp)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, dest); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		return nil
+	}
+}
+
+// linkAtomic hardlinks dest to src under a temporary name and renames it over dest
+func linkAtomic(src string, dest string) error {
+	return renameInto(dest, func(tmp string) error {
+		return os.Link(src, tmp)
+	})
+}
+
+// cloneAtomic clones src to dest under a temporary name and renames it over dest. The clone
+// gets mode when it is set, otherwise the permissions of dest when it already exists.
+func cloneAtomic(src string, dest string, mode os.FileMode) error {
+	if info, err := os.Stat(dest); mode == 0 && err == nil && info.Mode().IsRegular() {
+		mode = info.Mode().Perm()
+	}
+	return renameInto(dest, func(tmp string) error {
+		if err := cloneFile(src, tmp); err != nil {
+			return err
+		}
+		if mode != 0 {
+			if err := os.Chmod(tmp, mode); err != nil {
+				os.Remove(tmp)
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// createAtomic starts writing dest. The temporary file gets mode when it is set, otherwise the
+// same permissions os.Create would give it, or the permissions of dest when it already exists.
+func createAtomic(dest string, mode os.FileMode) (*atomicFile, error) {
+	for {
+		tmp := tempName(dest)
+		f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if mode != 0 {
+			if err := f.Chmod(mode); err != nil {
+				f.Close()
+				os.Remove(tmp)
+				return nil, err
+			}
+		} else if info, err := os.Stat(dest); err == nil && info.Mode().IsRegular() {
+			f.Chmod(info.Mode().Perm())
+		}
+		return &atomicFile{File: f, dest: dest}, nil
+	}
+}
+
+// Commit closes the file and moves it over the destination
+func (a *atomicFile) Commit() error {
+	a.done = true
+	if err := a.Close(); err != nil {
+		os.Remove(a.Name())
+		return err
+	}
+	if err := os.Rename(a.Name(), a.dest); err != nil {
+		os.Remove(a.Name())
+		return err
+	}
+	return nil
+}
+
+// Abort throws away what was written unless the file was committed, the destination is left
+// as it was
+func (a *atomicFile) Abort() {
+	if a.done {
+		return
+	}
+	a.done = true
+	a.Close()
+	os.Remove(a.Name())
+}
+
+// MakeDirs creates dir along with any missing parents. With a mode every folder it creates
+// gets exactly that mode, whatever the umask is, otherwise they get 0775 less the umask.
+func MakeDirs(dir string, mode os.FileMode) error {
+	if mode == 0 {
+		return os.MkdirAll(dir, 0775)
+	}
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := MakeDirs(parent, mode); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, mode); err != nil {
+		// another worker may have just created it
+		if info, statErr := os.Stat(dir); statErr == nil && info.IsDir() {
+			return nil
+		}
+		return err
+	}
+	return os.Chmod(dir, mode)
+}
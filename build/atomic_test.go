@@ -0,0 +1,124 @@
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// leftovers lists the temporary files of unfinished writes under dir
+func leftovers(t *testing.T, dir string) []string {
+	t.Helper()
+	var found []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.Contains(info.Name(), ".rome-") {
+			found = append(found, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+func TestAtomicFile(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "a.php")
+	if err := ioutil.WriteFile(dest, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := createAtomic(dest, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("half a fi")
+	if got := readBuilt(t, dir, "a.php"); got != "old\n" {
+		t.Errorf("the destination changed before the commit: %q", got)
+	}
+	f.Abort()
+	if got := readBuilt(t, dir, "a.php"); got != "old\n" {
+		t.Errorf("the destination changed after an abort: %q", got)
+	}
+	if found := leftovers(t, dir); len(found) != 0 {
+		t.Errorf("an abort left %v behind", found)
+	}
+
+	f, err = createAtomic(dest, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("new\n")
+	if err := f.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// the deferred Abort after a commit leaves the file alone
+	f.Abort()
+	if got := readBuilt(t, dir, "a.php"); got != "new\n" {
+		t.Errorf("the destination is %q after the commit", got)
+	}
+	if info, err := os.Stat(dest); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("the permissions of the destination were not kept: %v %v", info.Mode(), err)
+	}
+	if found := leftovers(t, dir); len(found) != 0 {
+		t.Errorf("a commit left %v behind", found)
+	}
+}
+
+func TestAtomicFileCommitFails(t *testing.T) {
+	dir := t.TempDir()
+	// a folder in the way makes the rename fail
+	dest := filepath.Join(dir, "a.php")
+	if err := os.MkdirAll(filepath.Join(dest, "in-the-way"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := createAtomic(dest, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("new\n")
+	if err := f.Commit(); err == nil {
+		t.Error("renaming over a folder did not fail")
+	}
+	if found := leftovers(t, dir); len(found) != 0 {
+		t.Errorf("a failed commit left %v behind", found)
+	}
+}
+
+func TestRunFailureKeepsDestination(t *testing.T) {
+	// the END comes after enough lines that part of the file is already written when it fails
+	var source strings.Builder
+	source.WriteString("<?php\n")
+	for i := 0; i < 1000; i++ {
+		source.WriteString("$a = '" + longLine[:100] + "';\n")
+	}
+	source.WriteString("// END SUGARCRM flav=ent ONLY\n")
+	src := writeTree(t, map[string]string{"broken.php": source.String(), "new.php": source.String()})
+
+	for _, threshold := range []int64{0, 1} {
+		opts := testOptions(t, src, "ent")
+		opts.StreamThreshold = threshold
+		if err := ioutil.WriteFile(filepath.Join(opts.Destination, "broken.php"), []byte("old\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		res := runBuild(t, opts)
+		if res.Failed != 2 {
+			t.Fatalf("stream threshold %d: %d files failed, want 2", threshold, res.Failed)
+		}
+		if got := readBuilt(t, opts.Destination, "broken.php"); got != "old\n" {
+			t.Errorf("stream threshold %d: the last build of broken.php was replaced with %d bytes", threshold, len(got))
+		}
+		if got := readBuilt(t, opts.Destination, "new.php"); got != "<missing>" {
+			t.Errorf("stream threshold %d: %d bytes of new.php were left behind", threshold, len(got))
+		}
+		if found := leftovers(t, opts.Destination); len(found) != 0 {
+			t.Errorf("stream threshold %d: a failed build left %v behind", threshold, found)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package build
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Tag is a single build tag found in a file
+type Tag struct {
+	Line      int
+	Kind      string
+	Condition string
+	Flavor    string
+}
+
+// ScanTags finds every build tag in r
+func ScanTags(r io.Reader) ([]Tag, error) {
+	var tags []Tag
+	scanner := newLineScanner(r)
+	var lineNum int
+	for scanner.Scan() {
+		lineNum++
+		matches := TagRegex.FindStringSubmatch(scanner.Text())
+		if matches == nil {
+			continue
+		}
+		tags = append(tags, Tag{
+			Line:      lineNum,
+			Kind:      matches[1],
+			Condition: strings.TrimSpace(matches[2]),
+			Flavor:    getTagFlavor(matches[2]),
+		})
+	}
+	return tags, scanner.Err()
+}
+
+// DeadTag is a tag that none of the shipping flavors would ever keep
+type DeadTag struct {
+	Path string
+	Tag
+}
+
+// AuditReport tallies the build tags used across a source tree
+type AuditReport struct {
+	Files int
+	// Conditions counts how many BEGIN and FILE tags use each condition
+	Conditions map[string]int
+	// Dead lists the BEGIN and FILE tags no shipping flavor can select
+	Dead []DeadTag
+}
+
+// Audit scans every processible file under root for build tags and flags the ones that
+// can never be selected by any of the shipping flavors
+func Audit(root string, shipping []string) (*AuditReport, error) {
+	report := &AuditReport{Conditions: make(map[string]int)}
+	err := filepath.Walk(root, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.IsDir() || !f.Mode().IsRegular() || !canProcessFile(path) {
+			return nil
+		}
+		fr, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fr.Close()
+		tags, err := ScanTags(fr)
+		if err != nil {
+			return err
+		}
+		report.Files++
+		for _, tag := range tags {
+			if tag.Kind != "BEGIN" && tag.Kind != "FILE" {
+				continue
+			}
+			report.Conditions[tag.Condition]++
+			if !selectable(tag.Condition, shipping) {
+				report.Dead = append(report.Dead, DeadTag{Path: path, Tag: tag})
+			}
+		}
+		return nil
+	})
+	sort.Slice(report.Dead, func(i, j int) bool {
+		if report.Dead[i].Path != report.Dead[j].Path {
+			return report.Dead[i].Path < report.Dead[j].Path
+		}
+		return report.Dead[i].Line < report.Dead[j].Line
+	})
+	return report, err
+}
+
+// selectable checks if building any of the flavors would keep a block with the tag condition
+func selectable(condition string, flavors []string) bool {
+	for _, flavor := range flavors {
+		if ok, err := tagAllows(condition, flavor); ok && err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package build
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// autotuneInterval is how often an autotuner looks at how the workers are doing
+const autotuneInterval = 500 * time.Millisecond
+
+// limiter is a pool of workers where only some of them work at once
+type limiter interface {
+	SetLimit(n int)
+	Limit() int
+}
+
+// autotuner ramps how many workers of a pool work at once up or down during a build. Every
+// interval it compares how many items were finished with the interval before: while that
+// grows it keeps going the same way and when it drops it turns around. When it stays about
+// the same but every item takes longer the workers are waiting on I/O, so it backs off.
+type autotuner struct {
+	name   string
+	pool   limiter
+	max    int
+	debugf func(string, ...interface{})
+
+	mu       sync.Mutex
+	finished int
+	busy     time.Duration
+
+	direction   int
+	lastRate    float64
+	lastLatency time.Duration
+}
+
+// autoWorkers is how many workers an autotuned pool starts with and the most it ramps up to,
+// scaled from the CPUs with room for the workers that are only waiting on I/O
+func autoWorkers(perCPU int, maxPerCPU int) (int, int) {
+	cpus := runtime.NumCPU()
+	return perCPU * cpus, maxPerCPU * cpus
+}
+
+func newAutotuner(name string, pool limiter, start int, max int, debugf func(string, ...interface{})) *autotuner {
+	t := &autotuner{name: name, pool: pool, max: max, debugf: debugf, direction: 1}
+	pool.SetLimit(start)
+	return t
+}
+
+// observe records an item a worker started at start and just finished, a nil autotuner
+// records nothing
+func (t *autotuner) observe(start time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.finished++
+	t.busy += time.Since(start)
+	t.mu.Unlock()
+}
+
+// run adjusts the pool every interval until ctx is done
+func (t *autotuner) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.adjust(interval)
+		}
+	}
+}
+
+func (t *autotuner) adjust(interval time.Duration) {
+	t.mu.Lock()
+	finished, busy := t.finished, t.busy
+	t.finished, t.busy = 0, 0
+	t.mu.Unlock()
+	// nothing finished, the workers are waiting on the walk or on large files
+	if finished == 0 {
+		return
+	}
+	rate := float64(finished) / interval.Seconds()
+	latency := busy / time.Duration(finished)
+
+	switch {
+	case t.lastRate == 0:
+	case rate < t.lastRate*0.9:
+		t.direction = -t.direction
+	case rate < t.lastRate*1.1 && latency > t.lastLatency*5/4:
+		t.direction = -1
+	case rate < t.lastRate*1.1:
+		// about as fast as before, more workers would not help
+		t.lastRate, t.lastLatency = rate, latency
+		return
+	}
+	t.lastRate, t.lastLatency = rate, latency
+
+	limit := t.pool.Limit()
+	next := limit + t.direction*(limit/4+1)
+	if next < 1 {
+		next = 1
+	}
+	if next > t.max {
+		next = t.max
+	}
+	if next != limit {
+		t.pool.SetLimit(next)
+		if t.debugf != nil {
+			t.debugf("autotune: %d %s workers, %.0f finished a second taking %s each", next, t.name, rate, latency)
+		}
+	}
+}
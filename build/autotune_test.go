@@ -0,0 +1,70 @@
+package build
+
+import (
+	"testing"
+	"time"
+)
+
+// fakePool is a limiter that only remembers its limit
+type fakePool struct {
+	limit int
+}
+
+func (p *fakePool) SetLimit(n int) { p.limit = n }
+func (p *fakePool) Limit() int     { return p.limit }
+
+func TestAutotunerAdjust(t *testing.T) {
+	pool := &fakePool{}
+	tuner := newAutotuner("file", pool, 8, 20, nil)
+	if pool.limit != 8 {
+		t.Fatalf("started with %d workers, want 8", pool.limit)
+	}
+
+	steps := []struct {
+		name     string
+		finished int
+		each     time.Duration
+		want     int
+	}{
+		{"first interval ramps up", 100, 10 * time.Millisecond, 11},
+		{"faster keeps ramping up", 150, 10 * time.Millisecond, 14},
+		{"nothing finished changes nothing", 0, 0, 14},
+		{"about as fast stays", 155, 10 * time.Millisecond, 14},
+		{"slower turns around", 100, 10 * time.Millisecond, 10},
+		{"faster keeps going down", 120, 10 * time.Millisecond, 7},
+		{"same speed taking longer backs off", 125, 20 * time.Millisecond, 5},
+		{"slower turns up again", 50, 20 * time.Millisecond, 7},
+		{"faster keeps going up", 100, 20 * time.Millisecond, 9},
+	}
+	for _, step := range steps {
+		tuner.finished = step.finished
+		tuner.busy = time.Duration(step.finished) * step.each
+		tuner.adjust(time.Second)
+		if pool.limit != step.want {
+			t.Fatalf("%s: %d workers, want %d", step.name, pool.limit, step.want)
+		}
+	}
+}
+
+func TestAutotunerBounds(t *testing.T) {
+	pool := &fakePool{}
+	tuner := newAutotuner("symlink", pool, 18, 20, nil)
+	tuner.finished, tuner.busy = 10, time.Second
+	tuner.adjust(time.Second)
+	if pool.limit != 20 {
+		t.Errorf("ramped up to %d workers, the most is 20", pool.limit)
+	}
+
+	pool = &fakePool{}
+	tuner = newAutotuner("symlink", pool, 1, 20, nil)
+	tuner.direction = -1
+	tuner.finished, tuner.busy = 10, time.Second
+	tuner.adjust(time.Second)
+	if pool.limit != 1 {
+		t.Errorf("backed off to %d workers, the least is 1", pool.limit)
+	}
+
+	// a nil autotuner is what a pool that is not autotuned has
+	var none *autotuner
+	none.observe(time.Now())
+}
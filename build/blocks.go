@@ -0,0 +1,101 @@
+package build
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tagBlock is a BEGIN block that is still open while going through the lines of a file
+type tagBlock struct {
+	line      int
+	condition string
+	// allowed is what the condition of the BEGIN came out as
+	allowed bool
+	// outer is whether the lines around the block are kept, nothing in the block is kept without them
+	outer bool
+	// elseLine is the line of the ELSE of the block, zero until there is one
+	elseLine int
+}
+
+// kept is whether the lines in the branch of the block the tags are in are kept
+func (b tagBlock) kept() bool {
+	return b.outer && b.allowed == (b.elseLine == 0)
+}
+
+// tagBlocks follows BEGIN, ELSE and END tags to work out which lines of a file are kept.
+// Blocks can be nested, an END closes the block opened last and an ELSE keeps the lines up
+// to the END only when the BEGIN did not.
+type tagBlocks struct {
+	open []tagBlock
+}
+
+// keep is whether the lines at this point in the file are kept
+func (t *tagBlocks) keep() bool {
+	return len(t.open) == 0 || t.open[len(t.open)-1].kept()
+}
+
+// droppedBy is the line of the BEGIN or ELSE tag that drops the lines at this point in the
+// file and which of the two it is, zero when they are kept
+func (t *tagBlocks) droppedBy() (int, string) {
+	for _, b := range t.open {
+		if !b.kept() {
+			if b.elseLine > 0 {
+				return b.elseLine, "ELSE"
+			}
+			return b.line, "BEGIN"
+		}
+	}
+	return 0, ""
+}
+
+func (t *tagBlocks) begin(line int, condition string, allowed bool) {
+	t.open = append(t.open, tagBlock{line: line, condition: condition, allowed: allowed, outer: t.keep()})
+}
+
+// elseBranch switches the block opened last over to its ELSE, the message says what is
+// wrong with the tag when it can not
+func (t *tagBlocks) elseBranch(line int, condition string) string {
+	if len(t.open) == 0 {
+		return "ELSE tag without a matching BEGIN"
+	}
+	b := &t.open[len(t.open)-1]
+	if b.elseLine > 0 {
+		return fmt.Sprintf("second ELSE in the block opened on line %d, the first one is on line %d", b.line, b.elseLine)
+	}
+	if !sameCondition(condition, b.condition) {
+		return fmt.Sprintf("ELSE %s does not match the BEGIN %s on line %d", strings.TrimSpace(condition), b.condition, b.line)
+	}
+	b.elseLine = line
+	return ""
+}
+
+// end closes the block opened last, the message says what is wrong with the tag when it can not
+func (t *tagBlocks) end(condition string) string {
+	if len(t.open) == 0 {
+		return "END tag without a matching BEGIN"
+	}
+	b := t.open[len(t.open)-1]
+	if !sameCondition(condition, b.condition) {
+		return fmt.Sprintf("END %s does not match the BEGIN %s on line %d", strings.TrimSpace(condition), b.condition, b.line)
+	}
+	t.open = t.open[:len(t.open)-1]
+	return ""
+}
+
+// unclosed is the line of the BEGIN opened last that is still open at the end of the file,
+// zero when every block was closed
+func (t *tagBlocks) unclosed() int {
+	if len(t.open) == 0 {
+		return 0
+	}
+	return t.open[len(t.open)-1].line
+}
+
+// sameCondition checks if the condition of an ELSE or END tag matches that of its BEGIN,
+// leaving it out always matches
+func sameCondition(tag string, begin string) bool {
+	normalize := func(c string) string {
+		return strings.ToLower(strings.Join(strings.Fields(c), ""))
+	}
+	return normalize(tag) == "" || normalize(tag) == normalize(begin)
+}
@@ -0,0 +1,19 @@
+package build
+
+import "bytes"
+
+// utf8BOM is the byte order mark some editors put at the start of UTF-8 files
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// cutBOM removes a leading byte order mark from content and reports if there was one
+func cutBOM(content string) (string, bool) {
+	if len(content) >= len(utf8BOM) && content[:len(utf8BOM)] == string(utf8BOM) {
+		return content[len(utf8BOM):], true
+	}
+	return content, false
+}
+
+// hasBOM checks if content starts with a byte order mark
+func hasBOM(content []byte) bool {
+	return bytes.HasPrefix(content, utf8BOM)
+}
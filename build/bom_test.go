@@ -0,0 +1,95 @@
+package build
+
+import (
+	"errors"
+	"testing"
+)
+
+const bomPrefix = "\ufeff"
+
+func TestCutBOM(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+		bom     bool
+	}{
+		{bomPrefix + "<?php\n", "<?php\n", true},
+		{"<?php\n", "<?php\n", false},
+		{"", "", false},
+		{"\xef\xbb", "\xef\xbb", false},
+		{"<?php\n" + bomPrefix, "<?php\n" + bomPrefix, false},
+	}
+	for _, tt := range tests {
+		got, cut := cutBOM(tt.content)
+		if got != tt.want || cut != tt.bom {
+			t.Errorf("cutBOM(%q) = %q, %v, want %q, %v", tt.content, got, cut, tt.want, tt.bom)
+		}
+	}
+}
+
+func TestBuildContentBOM(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		flavor string
+		want   string
+	}{
+		{"a tag on the first line", bomPrefix + "// BEGIN SUGARCRM flav=ent ONLY\nvar a = 1;\n// END SUGARCRM flav=ent ONLY\nvar b = 2;\n", "pro", bomPrefix + "var b = 2;\n"},
+		{"a kept tag on the first line", bomPrefix + "/* BEGIN SUGARCRM flav=pro ONLY */\nvar a = '@_SUGAR_FLAV';\n/* END SUGARCRM flav=pro ONLY */\n", "ent", bomPrefix + "var a = 'ent';\n"},
+		{"no tags", bomPrefix + "<?php\n$v = '@_SUGAR_VERSION';\n", "ent", bomPrefix + "<?php\n$v = '7.0';\n"},
+	}
+	for _, tt := range tests {
+		got, err := buildString(t, tt.source, tt.flavor, "7.0")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: built %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// a FILE tag behind a byte order mark still excludes the file
+	if _, err := buildString(t, bomPrefix+"// FILE SUGARCRM flav=ent ONLY\nvar a = 1;\n", "pro", "7.0"); !errors.Is(err, ErrFileExcluded) {
+		t.Errorf("got %v, want ErrFileExcluded", err)
+	}
+}
+
+func TestRunStripBOM(t *testing.T) {
+	binary := bomPrefix + "\x00\x01"
+	src := writeTree(t, map[string]string{
+		"tagged.js": bomPrefix + "// BEGIN SUGARCRM flav=ent ONLY\nvar a = 1;\n// END SUGARCRM flav=ent ONLY\n",
+		"plain.php": bomPrefix + "<?php\n",
+		"notes.txt": bomPrefix + "notes\n",
+		"no-bom.js": "var a = 1;\n",
+		"image.bin": binary,
+	})
+	for _, strip := range []bool{false, true} {
+		want := map[string]string{
+			"tagged.js": bomPrefix + "var a = 1;\n",
+			"plain.php": bomPrefix + "<?php\n",
+			"notes.txt": bomPrefix + "notes\n",
+			"no-bom.js": "var a = 1;\n",
+			// a binary file is never touched
+			"image.bin": binary,
+		}
+		if strip {
+			for _, rel := range []string{"tagged.js", "plain.php", "notes.txt"} {
+				want[rel] = want[rel][len(bomPrefix):]
+			}
+		}
+		for _, threshold := range []int64{0, 1} {
+			opts := testOptions(t, src, "ent")
+			opts.StripBOM = strip
+			opts.StreamThreshold = threshold
+			if res := runBuild(t, opts); res.Failed != 0 {
+				t.Fatalf("strip %v, stream threshold %d: %v", strip, threshold, res.Errors)
+			}
+			for rel, content := range want {
+				if got := readBuilt(t, opts.Destination, rel); got != content {
+					t.Errorf("strip %v, stream threshold %d: %s is %q, want %q", strip, threshold, rel, got, content)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package build
+
+import "strings"
+
+// the variables replaced with the BuildInfo, see SetBuildInfo
+const (
+	buildSHAVar  = "@_SUGAR_BUILD_SHA"
+	buildDateVar = "@_SUGAR_BUILD_DATE"
+	builderVar   = "@_SUGAR_BUILDER"
+)
+
+// BuildInfo says where a build comes from so the files built carry it
+type BuildInfo struct {
+	// SHA is the commit the sources are at
+	SHA string
+	// Date is when the build was made
+	Date string
+	// Builder is who or what made the build
+	Builder string
+}
+
+// buildInfo is what SetBuildInfo was last called with
+var buildInfo BuildInfo
+
+// SetBuildInfo sets what @_SUGAR_BUILD_SHA, @_SUGAR_BUILD_DATE and @_SUGAR_BUILDER are replaced
+// with by every build, BuildFile included. A variable without a value is left as it is.
+func SetBuildInfo(info BuildInfo) {
+	buildInfo = info
+}
+
+// vars pairs every build variable that has a value with it
+func (info BuildInfo) vars() [][2]string {
+	var vars [][2]string
+	for _, v := range [][2]string{{buildSHAVar, info.SHA}, {buildDateVar, info.Date}, {builderVar, info.Builder}} {
+		if v[1] != "" {
+			vars = append(vars, v)
+		}
+	}
+	return vars
+}
+
+// replace substitutes the build variables that have a value in content
+func (info BuildInfo) replace(content string) string {
+	for _, v := range info.vars() {
+		content = strings.Replace(content, v[0], v[1], -1)
+	}
+	return content
+}
@@ -0,0 +1,129 @@
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CacheFile is the name of the build cache written into a destination, see Options.Cache
+const CacheFile = ".rome-cache.json"
+
+// CacheEntry is what the cache knows about the source of a built file
+type CacheEntry struct {
+	SHA256  string    `json:"sha256"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Cache remembers the hash of every source a build built, so the next build with the same
+// flavor, version and settings can leave out the sources that did not change
+type Cache struct {
+	Flavor  string `json:"flavor"`
+	Version string `json:"version"`
+	// Settings fingerprints every other option that changes what ends up in the destination
+	Settings string                `json:"settings"`
+	Files    map[string]CacheEntry `json:"files"`
+}
+
+// NewCache returns an empty cache, passing it to Options.Cache builds everything and caches it
+func NewCache() *Cache {
+	return &Cache{Files: make(map[string]CacheEntry)}
+}
+
+// ReadCache reads the cache in dest, a destination without one returns nil without an error
+func ReadCache(dest string) (*Cache, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dest, CacheFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &Cache{}
+	if err := json.Unmarshal(content, c); err != nil {
+		return nil, err
+	}
+	if c.Files == nil {
+		c.Files = make(map[string]CacheEntry)
+	}
+	return c, nil
+}
+
+// WriteCache saves c into dest
+func WriteCache(dest string, c *Cache) error {
+	content, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dest, CacheFile), append(content, '\n'), 0644)
+}
+
+// cacheSettings fingerprints the options besides the flavor and version that change the
+// files a build writes, along with the Defines, the names of the Processors and the commit and
+// builder of the BuildInfo. The date is left out, or the cache would never be used, files the
+// cache leaves out keep theirs.
+func (opts Options) cacheSettings() string {
+	rules := make([]string, len(opts.Rename))
+	for i, rule := range opts.Rename {
+		rules[i] = rule.Rule
+	}
+	processors := make([]string, len(opts.Processors))
+	for i, p := range opts.Processors {
+		processors[i] = p.Name()
+	}
+	settings := fmt.Sprintf("%q %v %q %v %q %q %d %o %v %v %q %q %q %q",
+		opts.LineEndings, opts.StripBOM, opts.OnMissingVersion, opts.Flatten, rules,
+		opts.GzipExtensions, opts.GzipMinSize, opts.FileMode, opts.PreserveMode, opts.PreserveTimes, definesString(),
+		buildInfo.SHA, buildInfo.Builder, processors)
+	sum := sha256.Sum256([]byte(settings))
+	return hex.EncodeToString(sum[:])
+}
+
+// unchanged checks if the source at path, rel to its root, is the one the cache has for rel.
+// The size and modification time are trusted when they match, otherwise the content is hashed.
+func (c *Cache) unchanged(rel string, path string, f os.FileInfo) (CacheEntry, bool) {
+	entry, ok := c.Files[rel]
+	if !ok || entry.Size != f.Size() {
+		return entry, false
+	}
+	if entry.ModTime.Equal(f.ModTime()) {
+		return entry, true
+	}
+	sum, err := hashSource(path)
+	if err != nil || sum != entry.SHA256 {
+		return entry, false
+	}
+	// the content is the same, remember the new time so it is not hashed again
+	entry.ModTime = f.ModTime()
+	return entry, true
+}
+
+// hashSource returns the hex sha256 of the file at path
+func hashSource(path string) (string, error) {
+	fr, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fr.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, fr); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// entry returns what c has for rel, a nil cache has nothing
+func (c *Cache) entry(rel string) (CacheEntry, bool) {
+	if c == nil {
+		return CacheEntry{}, false
+	}
+	entry, ok := c.Files[rel]
+	return entry, ok
+}
@@ -0,0 +1,109 @@
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunCache(t *testing.T) {
+	src := writeTree(t, map[string]string{"a.php": "<?php $a = '@_SUGAR_VERSION';\n", "b.php": "<?php $b = 1;\n", "c.php": "<?php $c = 1;\n"})
+	opts := testOptions(t, src, "ent")
+	opts.Cache = NewCache()
+	res := runBuild(t, opts)
+	if res.Built != 3 || res.Cached != 0 || len(res.Cache.Files) != 3 {
+		t.Fatalf("first build: built %d, cached %d, %d in the cache", res.Built, res.Cached, len(res.Cache.Files))
+	}
+
+	opts.Cache = res.Cache
+	res = runBuild(t, opts)
+	if res.Built != 0 || res.Cached != 3 {
+		t.Fatalf("unchanged: built %d, cached %d", res.Built, res.Cached)
+	}
+
+	// a new time with the same content is hashed and kept, different content is built
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(src, "b.php"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "a.php"), []byte("<?php $a = 2;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(opts.Destination, "c.php")); err != nil {
+		t.Fatal(err)
+	}
+	opts.Cache = res.Cache
+	res = runBuild(t, opts)
+	if res.Built != 2 || res.Cached != 1 {
+		t.Fatalf("changed: built %d, cached %d", res.Built, res.Cached)
+	}
+	if got, want := readBuilt(t, opts.Destination, "a.php"), "<?php $a = 2;\n"; got != want {
+		t.Errorf("a.php is %q, want %q", got, want)
+	}
+	if got := res.Cache.Files["b.php"].ModTime; !got.Equal(later) {
+		t.Errorf("b.php is cached with %s, want the new time %s", got, later)
+	}
+	if got := readBuilt(t, opts.Destination, "c.php"); got == "<missing>" {
+		t.Error("c.php was not built again")
+	}
+
+	// a cache of a build made another way counts for nothing
+	cache := res.Cache
+	for name, change := range map[string]func(o *Options){
+		"flavor":       func(o *Options) { o.Flavor = "pro" },
+		"version":      func(o *Options) { o.Version = "7.1" },
+		"line endings": func(o *Options) { o.LineEndings = LineEndingsCRLF },
+	} {
+		changed := opts
+		change(&changed)
+		changed.Cache = cache
+		res = runBuild(t, changed)
+		if res.Built != 3 || res.Cached != 0 {
+			t.Errorf("%s: built %d, cached %d", name, res.Built, res.Cached)
+		}
+	}
+}
+
+func TestCacheSettings(t *testing.T) {
+	defer SetBuildInfo(BuildInfo{})
+	defer Define(nil)
+
+	base := Options{Flavor: "ent", Version: "7.0"}
+	fingerprint := base.cacheSettings()
+	if base.cacheSettings() != fingerprint {
+		t.Fatal("the same options fingerprint differently")
+	}
+	// the flavor and version are kept next to the fingerprint, not in it
+	if (Options{Flavor: "pro", Version: "8.0"}).cacheSettings() != fingerprint {
+		t.Error("the flavor and version change the fingerprint")
+	}
+	SetBuildInfo(BuildInfo{Date: "2017-01-02"})
+	if base.cacheSettings() != fingerprint {
+		t.Error("the build date changes the fingerprint")
+	}
+
+	changes := map[string]func(){
+		"line endings": func() { base.LineEndings = LineEndingsLF },
+		"strip bom":    func() { base.StripBOM = true },
+		"flatten":      func() { base.Flatten = true },
+		"file mode":    func() { base.FileMode = 0600 },
+		"processors":   func() { base.Processors = []Processor{Minifier()} },
+		"build sha":    func() { SetBuildInfo(BuildInfo{SHA: "abc123"}) },
+		"defines": func() {
+			if err := Define([]string{"feature=newdash"}); err != nil {
+				t.Fatal(err)
+			}
+		},
+	}
+	for name, change := range changes {
+		base = Options{Flavor: "ent", Version: "7.0"}
+		SetBuildInfo(BuildInfo{})
+		Define(nil)
+		change()
+		if base.cacheSettings() == fingerprint {
+			t.Errorf("%s does not change the fingerprint", name)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package build
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunCaseCollisions(t *testing.T) {
+	src := writeTree(t, map[string]string{
+		"Readme.php":         "<?php\n",
+		"README.php":         "<?php\n",
+		"modules/Foo/a.php":  "<?php\n",
+		"modules/foo/A.php":  "<?php\n",
+		"modules/Foo/b.php":  "<?php\n",
+		"modules/bar/b.php":  "<?php\n",
+		"modules/Bar2/b.php": "<?php\n",
+	})
+
+	opts := testOptions(t, src, "ent")
+	res := runBuild(t, opts)
+	if res.Failed != 0 {
+		t.Fatalf("a case collision failed without FailCaseClashes: %v", res.Errors)
+	}
+	// one for each pair, whichever of the two is built second
+	if len(res.Warnings) != 2 {
+		t.Errorf("%d warnings, want 2: %v", len(res.Warnings), res.Warnings)
+	}
+	for _, w := range res.Warnings {
+		if !strings.Contains(w.Msg, "only differs in case") {
+			t.Errorf("unexpected warning %s", w)
+		}
+	}
+	for _, rel := range []string{"Readme.php", "README.php", "modules/Foo/a.php", "modules/foo/A.php", "modules/bar/b.php"} {
+		if got := readBuilt(t, opts.Destination, rel); got != "<?php\n" {
+			t.Errorf("%s is %q after a warning", rel, got)
+		}
+	}
+
+	opts = testOptions(t, src, "ent")
+	opts.FailCaseClashes = true
+	res = runBuild(t, opts)
+	if res.Failed != 2 || len(res.Warnings) != 0 {
+		t.Fatalf("failed %d with warnings %v, want 2 failures", res.Failed, res.Warnings)
+	}
+	for _, failure := range res.Failures {
+		if !errors.Is(failure.Err, ErrCaseCollision) {
+			t.Errorf("%s failed with %v, want ErrCaseCollision", failure.Path, failure.Err)
+		}
+	}
+}
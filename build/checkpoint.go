@@ -0,0 +1,90 @@
+package build
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JournalFile is the checkpoint a build keeps in its destination until it is done, so a build
+// that dies part way through can be resumed from there
+const JournalFile = ".rome-journal"
+
+// checkpointFlushInterval is how often finished paths are flushed to the checkpoint file
+const checkpointFlushInterval = time.Second
+
+// Checkpoint appends the relative path of every file that finished building to a file, so a
+// build that dies part way through can be resumed with LoadCheckpoint. Writes are buffered
+// and flushed about once a second, which costs a little I/O in exchange for not having to
+// start over on flaky infrastructure.
+type Checkpoint struct {
+	mu        sync.Mutex
+	f         *os.File
+	w         *bufio.Writer
+	lastFlush time.Time
+}
+
+// OpenCheckpoint opens path for appending, creating it if it does not exist
+func OpenCheckpoint(path string) (*Checkpoint, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Checkpoint{f: f, w: bufio.NewWriter(f), lastFlush: time.Now()}, nil
+}
+
+// Add records a file result if it finished without an error, it is meant to be used as
+// an Options.OnResult handler
+func (c *Checkpoint) Add(r FileResult) {
+	if r.Err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.w.WriteString(r.Path + "\n")
+	if time.Since(c.lastFlush) >= checkpointFlushInterval {
+		c.w.Flush()
+		c.lastFlush = time.Now()
+	}
+}
+
+// Close flushes anything still buffered and closes the checkpoint file
+func (c *Checkpoint) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.w.Flush(); err != nil {
+		c.f.Close()
+		return err
+	}
+	return c.f.Close()
+}
+
+// LoadCheckpoint reads the relative paths written by a Checkpoint, a partly written last
+// line from a build that died mid write is ignored
+func LoadCheckpoint(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	done := make(map[string]bool)
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			// anything without a trailing newline was never completely written
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line = strings.TrimSuffix(line, "\n"); line != "" {
+			done[line] = true
+		}
+	}
+	return done, nil
+}
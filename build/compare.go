@@ -0,0 +1,92 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FlavorOutput is what building a file for one flavor gives
+type FlavorOutput struct {
+	// Excluded is set when a FILE tag leaves the file out of the flavor
+	Excluded bool
+	Bytes    int
+	Lines    int
+}
+
+// FlavorDiff is a file that builds differently for two flavors
+type FlavorDiff struct {
+	// Path is relative to the source folder
+	Path string
+	A    FlavorOutput
+	B    FlavorOutput
+	// Lines is the line diff from A to B, it is only filled in when asked for
+	Lines []DiffLine
+}
+
+// FlavorComparison is the outcome of CompareFlavors, Differ is sorted by path
+type FlavorComparison struct {
+	Files  int
+	Differ []FlavorDiff
+}
+
+// CompareFlavors builds every processible file under root in memory for flavors a and b and
+// lists the ones that come out differently. withLines also diffs the lines of each of them.
+func CompareFlavors(root string, a string, b string, version string, withLines bool) (*FlavorComparison, error) {
+	comparison := &FlavorComparison{}
+	err := filepath.Walk(root, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.IsDir() || !f.Mode().IsRegular() || !canProcessFile(path) {
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		comparison.Files++
+		outA, builtA, err := buildFlavorOutput(content, path, a, version)
+		if err != nil {
+			return err
+		}
+		outB, builtB, err := buildFlavorOutput(content, path, b, version)
+		if err != nil {
+			return err
+		}
+		if outA.Excluded == outB.Excluded && bytes.Equal(builtA, builtB) {
+			return nil
+		}
+		diff := FlavorDiff{Path: relativePath(root, path), A: outA, B: outB}
+		if withLines {
+			diff.Lines = DiffLines(splitLines(builtA), splitLines(builtB))
+		}
+		comparison.Differ = append(comparison.Differ, diff)
+		return nil
+	})
+	return comparison, err
+}
+
+// buildFlavorOutput builds content for a single flavor
+func buildFlavorOutput(content []byte, path string, flavor string, version string) (FlavorOutput, []byte, error) {
+	built, err := buildContent(context.Background(), string(content), path, flavor, version, nil, nil)
+	if errors.Is(err, ErrFileExcluded) {
+		return FlavorOutput{Excluded: true}, nil, nil
+	}
+	if err != nil {
+		return FlavorOutput{}, nil, err
+	}
+	return FlavorOutput{Bytes: len(built), Lines: len(splitLines(built))}, built, nil
+}
+
+// splitLines splits content into lines without their line endings
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
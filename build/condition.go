@@ -0,0 +1,115 @@
+package build
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// conditionRegex splits a tag condition like flav=ent, flav!=pro or flav in (ent, ult) into its parts
+var conditionRegex = regexp.MustCompile(`(?i)^\s*([a-z_]+)\s*(!=|=|not\s+in\b|in\b)\s*(.*?)\s*$`)
+
+// defineKeyRegex is what the key of a define has to look like to be used in a tag condition
+var defineKeyRegex = regexp.MustCompile(`^[a-z_]+$`)
+
+// Defines are the user defined tags, like feature=newdash, a tag condition on one of the keys is
+// checked against its value the same way flav is checked against the flavor, see Define
+var Defines = map[string]string{}
+
+// Define replaces Defines with the key=value pairs in defines. Keys and values are lower case
+// like the conditions they are checked against, flav can not be defined.
+func Define(defines []string) error {
+	parsed := make(map[string]string, len(defines))
+	for _, define := range defines {
+		parts := strings.SplitN(define, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("define %q must look like key=value", define)
+		}
+		key, value := strings.ToLower(strings.TrimSpace(parts[0])), strings.ToLower(strings.TrimSpace(parts[1]))
+		if !defineKeyRegex.MatchString(key) {
+			return fmt.Errorf("define %q needs a key made of letters and underscores", define)
+		}
+		if key == "flav" {
+			return fmt.Errorf("define %q can not set flav, use the flavor instead", define)
+		}
+		if value == "" || strings.ContainsAny(value, ",()") {
+			return fmt.Errorf("define %q needs a value without commas or parentheses", define)
+		}
+		if _, ok := parsed[key]; ok {
+			return fmt.Errorf("%s is defined more than once", key)
+		}
+		parsed[key] = value
+	}
+	Defines = parsed
+	return nil
+}
+
+// definesString lists Defines sorted by key, it is empty when nothing is defined
+func definesString() string {
+	pairs := make([]string, 0, len(Defines))
+	for key, value := range Defines {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// tagAllows evaluates the condition of a BEGIN or FILE tag for the flavor being built and the Defines
+func tagAllows(condition string, buildFlavor string) (bool, error) {
+	condition = strings.TrimSpace(condition)
+	if condition == "" {
+		return false, fmt.Errorf("tag is missing a flavor")
+	}
+
+	matches := conditionRegex.FindStringSubmatch(condition)
+	if matches == nil {
+		// a bare flavor name
+		return contains(Flavors[buildFlavor], strings.ToLower(condition)), nil
+	}
+	key := strings.ToLower(matches[1])
+	defined, isDefine := Defines[key]
+	if key != "flav" && !isDefine {
+		// anything that is not about the flavor or a define keeps the old behaviour of using the value as the flavor
+		return contains(Flavors[buildFlavor], getTagFlavor(condition)), nil
+	}
+
+	op := strings.Join(strings.Fields(strings.ToLower(matches[2])), " ")
+	values := conditionValues(matches[3])
+	what := "flavor"
+	if isDefine {
+		what = "value"
+	}
+	if len(values) == 0 {
+		return false, fmt.Errorf("%s %s has no %ss", key, op, what)
+	}
+	if (op == "=" || op == "!=") && len(values) > 1 {
+		return false, fmt.Errorf("%s %s only takes one %s, use in for a list", key, op, what)
+	}
+
+	matched := false
+	for _, value := range values {
+		if (isDefine && value == defined) || (!isDefine && contains(Flavors[buildFlavor], value)) {
+			matched = true
+			break
+		}
+	}
+	if op == "!=" || op == "not in" {
+		return !matched, nil
+	}
+	return matched, nil
+}
+
+// conditionValues splits a list like (ent, ult) or ent,ult into lower case flavors
+func conditionValues(list string) []string {
+	list = strings.TrimSpace(list)
+	list = strings.TrimPrefix(list, "(")
+	list = strings.TrimSuffix(list, ")")
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.ToLower(strings.TrimSpace(value)); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,70 @@
+package build
+
+import "testing"
+
+func TestTagAllows(t *testing.T) {
+	tests := []struct {
+		condition string
+		flavor    string
+		want      bool
+	}{
+		{"flav=ent", "ent", true},
+		{"flav=ent", "pro", false},
+		{"flav!=ent", "pro", true},
+		{"flav in ent,ult", "ent", true},
+		{"flav in ent,ult", "ult", true},
+		{"flav in ent,ult", "pro", false},
+		{"flav in (ent, ult)", "ult", true},
+		{"flav in ( ent ,ult )", "pro", false},
+		{"FLAV IN (ENT,ULT)", "ent", true},
+		{"flav In Ent", "ent", true},
+		{"flav not in ent,ult", "pro", true},
+		{"flav not in (ent, ult)", "ent", false},
+		{"flav  NOT   IN  (ent)", "ult", false},
+		{"flav in corp,ent", "ult", true},
+		{"flav not in corp", "pro", true},
+	}
+	for _, tt := range tests {
+		got, err := tagAllows(tt.condition, tt.flavor)
+		if err != nil {
+			t.Errorf("tagAllows(%q, %q): %v", tt.condition, tt.flavor, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("tagAllows(%q, %q) = %v, want %v", tt.condition, tt.flavor, got, tt.want)
+		}
+	}
+}
+
+func TestTagAllowsErrors(t *testing.T) {
+	for _, condition := range []string{"", "flav in", "flav in ()", "flav not in ,", "flav=ent,ult", "flav!=(pro,ent)"} {
+		if _, err := tagAllows(condition, "ent"); err == nil {
+			t.Errorf("tagAllows(%q) did not fail", condition)
+		}
+	}
+}
+
+func TestBuildContentFlavorLists(t *testing.T) {
+	source := "<?php\n" +
+		"// BEGIN SUGARCRM flav in (ent, ult) ONLY\n" +
+		"$in = 1;\n" +
+		"// END SUGARCRM flav in (ent, ult) ONLY\n" +
+		"// BEGIN SUGARCRM flav not in (ent, ult) ONLY\n" +
+		"$out = 1;\n" +
+		"// END SUGARCRM flav not in (ent, ult) ONLY\n"
+	tests := map[string]string{
+		"pro": "<?php\n$out = 1;\n",
+		"ent": "<?php\n$in = 1;\n",
+		"ult": "<?php\n$in = 1;\n",
+	}
+	for flavor, want := range tests {
+		got, err := buildString(t, source, flavor, "7.0")
+		if err != nil {
+			t.Errorf("%s: %v", flavor, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s: built %q, want %q", flavor, got, want)
+		}
+	}
+}
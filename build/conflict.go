@@ -0,0 +1,155 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ConflictPolicy decides what happens when a destination file already exists
+type ConflictPolicy string
+
+const (
+	// ConflictOverwrite always replaces the existing destination file
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictSkip leaves the existing destination file alone
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictNewer keeps whichever of the two files is newer
+	ConflictNewer ConflictPolicy = "newer"
+	// ConflictError fails the file
+	ConflictError ConflictPolicy = "error"
+)
+
+// ConflictPolicies lists every valid policy
+var ConflictPolicies = []ConflictPolicy{ConflictOverwrite, ConflictSkip, ConflictNewer, ConflictError}
+
+// ParseConflictPolicy validates the name of a conflict policy, an empty name means overwrite
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	if name == "" {
+		return ConflictOverwrite, nil
+	}
+	for _, p := range ConflictPolicies {
+		if string(p) == name {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown conflict policy %q, must be one of %v", name, ConflictPolicies)
+}
+
+// ConflictCounts tallies how every conflict during a build was resolved
+type ConflictCounts struct {
+	Overwritten int32
+	Skipped     int32
+	Errored     int32
+}
+
+// conflicts tracks which destinations have been claimed during a build so that two
+// workers never make a decision about the same destination at the same time
+type conflicts struct {
+	policy ConflictPolicy
+	// remote destinations are never looked at on the disk
+	remote bool
+
+	mu      sync.Mutex
+	claimed map[string]*claim
+	counts  ConflictCounts
+}
+
+type claim struct {
+	sync.Mutex
+	// written is set once a source of this run has been written to the destination, modTime
+	// is the modification time of that source
+	written bool
+	modTime time.Time
+}
+
+func newConflicts(policy ConflictPolicy) *conflicts {
+	if policy == "" {
+		policy = ConflictOverwrite
+	}
+	return &conflicts{policy: policy, claimed: make(map[string]*claim)}
+}
+
+// claim returns the claim on dest, creating it the first time
+func (c *conflicts) claim(dest string) *claim {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cl, ok := c.claimed[dest]
+	if !ok {
+		cl = &claim{}
+		c.claimed[dest] = cl
+	}
+	return cl
+}
+
+// acquire decides if src may be written to dest. When it may, the returned release
+// function must be called once the write is done, telling it if anything was written,
+// until then any other source for the same destination waits. A source that ends up
+// not being written, because a FILE tag excludes it or it failed, does not count as a
+// conflict for the sources after it.
+func (c *conflicts) acquire(dest string, modTime time.Time) (bool, func(written bool), error) {
+	cl := c.claim(dest)
+	cl.Lock()
+	existing, exists := cl.modTime, cl.written
+	if !exists && !c.remote {
+		// only look at the disk when nothing from this run has been written there yet
+		if info, err := os.Lstat(dest); err == nil {
+			exists = true
+			existing = info.ModTime()
+		}
+	}
+
+	release := func(written bool) {
+		if written {
+			cl.written = true
+			cl.modTime = modTime
+			if exists {
+				c.mu.Lock()
+				c.counts.Overwritten++
+				c.mu.Unlock()
+			}
+		}
+		cl.Unlock()
+	}
+	if !exists {
+		return true, release, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.policy {
+	case ConflictSkip:
+		c.counts.Skipped++
+	case ConflictNewer:
+		if !modTime.After(existing) {
+			c.counts.Skipped++
+			break
+		}
+		return true, release, nil
+	case ConflictError:
+		c.counts.Errored++
+		cl.Unlock()
+		return false, nil, fmt.Errorf("%s: %w", dest, ErrConflict)
+	default:
+		return true, release, nil
+	}
+	cl.Unlock()
+	return false, nil, nil
+}
+
+// kept records that dest already holds what the source modified at modTime builds to,
+// like a file the cache leaves alone, so later sources for it are conflicts
+func (c *conflicts) kept(dest string, modTime time.Time) {
+	cl := c.claim(dest)
+	cl.Lock()
+	cl.written = true
+	cl.modTime = modTime
+	cl.Unlock()
+}
+
+func (c *conflicts) Counts() ConflictCounts {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts
+}
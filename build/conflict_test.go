@@ -0,0 +1,109 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConflictsClaimOnlyWhatWasWritten(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "a.php")
+	older, newer := time.Now().Add(-time.Hour), time.Now()
+
+	c := newConflicts(ConflictSkip)
+	ok, release, err := c.acquire(dest, newer)
+	if !ok || err != nil {
+		t.Fatalf("first source: ok %v, err %v", ok, err)
+	}
+	release(false)
+	ok, release, err = c.acquire(dest, older)
+	if !ok || err != nil {
+		t.Fatalf("source after one that wrote nothing: ok %v, err %v", ok, err)
+	}
+	release(true)
+	if ok, _, _ = c.acquire(dest, newer); ok {
+		t.Fatal("source after one that was written is not skipped")
+	}
+	if got := c.Counts(); got != (ConflictCounts{Skipped: 1}) {
+		t.Errorf("counts %+v", got)
+	}
+
+	c = newConflicts(ConflictNewer)
+	_, release, _ = c.acquire(dest, newer)
+	release(false)
+	ok, release, _ = c.acquire(dest, older)
+	if !ok {
+		t.Fatal("an older source is compared to one that wrote nothing")
+	}
+	release(true)
+	if ok, _, _ = c.acquire(dest, older); ok {
+		t.Error("a source as old as the one written is not skipped")
+	}
+	ok, release, _ = c.acquire(dest, newer)
+	if !ok {
+		t.Fatal("a newer source is skipped")
+	}
+	release(true)
+	if got := c.Counts(); got != (ConflictCounts{Overwritten: 1, Skipped: 1}) {
+		t.Errorf("counts %+v", got)
+	}
+}
+
+// TestRunConflictExcludedSource builds two sources onto the same destination where the
+// newer one is left out by a FILE tag, whichever order they are built in the other one
+// has to end up in the destination
+func TestRunConflictExcludedSource(t *testing.T) {
+	excluded := writeTree(t, map[string]string{"a.php": "<?php\n// FILE SUGARCRM flav=ent ONLY\n$a = 'ent';\n"})
+	kept := writeTree(t, map[string]string{"a.php": "<?php\n$a = 'pro';\n"})
+	now := time.Now()
+	if err := os.Chtimes(filepath.Join(excluded, "a.php"), now, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(kept, "a.php"), now.Add(-time.Hour), now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, policy := range []ConflictPolicy{ConflictSkip, ConflictNewer} {
+		for _, sources := range [][]string{{excluded, kept}, {kept, excluded}} {
+			opts := testOptions(t, "", "pro")
+			opts.Sources = sources
+			opts.OnConflict = policy
+			res := runBuild(t, opts)
+			if res.Failed != 0 {
+				t.Fatalf("%s: %v", policy, res.Errors)
+			}
+			if got, want := readBuilt(t, opts.Destination, "a.php"), "<?php\n$a = 'pro';\n"; got != want {
+				t.Errorf("%s, sources %v: built %q, want %q", policy, sources, got, want)
+			}
+			if res.Conflicts.Overwritten != 0 {
+				t.Errorf("%s: %d overwritten, nothing was", policy, res.Conflicts.Overwritten)
+			}
+		}
+	}
+}
+
+func TestRunConflictNewerAcrossSources(t *testing.T) {
+	old := writeTree(t, map[string]string{"a.php": "<?php\n$a = 'old';\n"})
+	fresh := writeTree(t, map[string]string{"a.php": "<?php\n$a = 'fresh';\n"})
+	now := time.Now()
+	if err := os.Chtimes(filepath.Join(old, "a.php"), now.Add(-time.Hour), now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(fresh, "a.php"), now, now); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sources := range [][]string{{old, fresh}, {fresh, old}} {
+		opts := testOptions(t, "", "ent")
+		opts.Sources = sources
+		opts.OnConflict = ConflictNewer
+		res := runBuild(t, opts)
+		if got, want := readBuilt(t, opts.Destination, "a.php"), "<?php\n$a = 'fresh';\n"; got != want {
+			t.Errorf("sources %v: built %q, want %q", sources, got, want)
+		}
+		if got := res.Conflicts.Overwritten + res.Conflicts.Skipped; got != 1 {
+			t.Errorf("sources %v: %+v, want one conflict", sources, res.Conflicts)
+		}
+	}
+}
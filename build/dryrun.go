@@ -0,0 +1,81 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// previewFile builds a file in memory for a dry run and compares it to what is in the
+// destination, nothing is written
+func (r *runner) previewFile(f file, shortPath string, dest string) {
+	opts := r.opts
+	ctx := context.Background()
+	if opts.FileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.FileTimeout)
+		defer cancel()
+	}
+	fo := opts.fileOptions()
+	fo.ctx = ctx
+	fo.warn = func(line int, msg string) {
+		r.warn(Warning{Path: f.Path, Line: line, Msg: msg})
+	}
+	var versioned bool
+	fo.versioned = &versioned
+	fo.rel = relativePath(f.Root, f.Path)
+	fo.processors = opts.processorsFor(fo.rel)
+	start := time.Now()
+	content, built, err := renderFile(f.Path, dest, fo)
+	err = r.timedOut(ctx, f.Path, err)
+	if err == nil && !built {
+		opts.explainSkip(f.Path, "a FILE tag excludes the "+opts.Flavor+" flavor")
+	}
+	fr := FileResult{Path: shortPath, Source: f.Path, Destination: dest, Skipped: !built && err == nil, Err: err, Started: start, Size: f.Info.Size(), Bytes: int64(len(content)), Content: content, VersionSensitive: versioned, Processed: canProcessFile(dest)}
+	if built {
+		existing, readErr := ioutil.ReadFile(dest)
+		fr.Changed = readErr != nil || !bytes.Equal(existing, content)
+	}
+	fr.Duration = time.Since(start)
+	r.results <- fr
+}
+
+// previewLink works out what the symlink mode would do with a symlink on a dry run, it is
+// changed when the destination does not already hold the same link or file
+func (r *runner) previewLink(l link, shortPath string, dest string) {
+	fr := FileResult{Path: shortPath, Source: l.Link, Destination: dest, Link: true, Started: time.Now()}
+	switch r.opts.Symlinks {
+	case SymlinksSkip:
+		fr.Skipped = true
+		r.opts.explainSkip(l.Link, "symlinks are skipped")
+	case SymlinksDereference, SymlinksCopy:
+		if info, err := os.Stat(l.Link); err == nil && info.IsDir() {
+			// only a folder that is not there yet is known to change without building it all
+			destInfo, destErr := os.Stat(dest)
+			fr.Changed = destErr != nil || !destInfo.IsDir()
+			break
+		}
+		fo := r.opts.fileOptions()
+		fo.verbatim = r.opts.Symlinks == SymlinksCopy
+		content, built, err := renderFile(l.Link, dest, fo)
+		if err != nil || !built {
+			fr.Err, fr.Skipped = err, err == nil
+			break
+		}
+		existing, readErr := ioutil.ReadFile(dest)
+		fr.Changed = readErr != nil || !bytes.Equal(existing, content)
+		fr.Bytes = int64(len(content))
+	default:
+		target, err := r.linkTarget(l, dest)
+		if err != nil {
+			fr.Err = err
+			break
+		}
+		existing, readErr := os.Readlink(dest)
+		fr.Changed = readErr != nil || existing != target
+	}
+	fr.Duration = time.Since(fr.Started)
+	r.results <- fr
+}
@@ -0,0 +1,223 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// ErrSourceMissing is returned when the source folder or file to build does not exist
+var ErrSourceMissing = errors.New("source does not exist")
+
+// ErrFileExcluded is returned by BuildContent when a FILE tag excludes the whole file from the flavor
+var ErrFileExcluded = errors.New("file is excluded from the flavor by a FILE tag")
+
+// ErrConflict is returned when a destination already exists and the conflict policy is error
+var ErrConflict = errors.New("destination already exists")
+
+// ErrNoSpace is returned by Run when it stopped because the destination ran out of disk space
+var ErrNoSpace = errors.New("destination is out of disk space")
+
+// ErrTooManyFailures is returned by Run when it stopped because Options.MaxFailures files failed
+var ErrTooManyFailures = errors.New("too many files failed")
+
+// ErrMissingVersion is returned for a file that uses the version placeholder when no version
+// was given and Options.OnMissingVersion is error
+var ErrMissingVersion = errors.New("uses the version placeholder but no version was given")
+
+// ErrTimedOut is returned for a file that took longer to build than Options.FileTimeout
+var ErrTimedOut = errors.New("timed out")
+
+// ErrCaseCollision is returned for a file whose destination only differs in case from the
+// destination of another file and Options.FailCaseClashes is set
+var ErrCaseCollision = errors.New("destinations only differ in case")
+
+// ParseError is returned when the build tags in a file can not be understood
+type ParseError struct {
+	Path string
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Msg)
+}
+
+// ReadError is returned when a source file could not be read
+type ReadError struct {
+	Path string
+	Err  error
+}
+
+func (e *ReadError) Error() string {
+	return fmt.Sprintf("could not read %s: %v", e.Path, e.Err)
+}
+
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
+// DirError is returned for a file or symlink that was not written because the folder it goes
+// in could not be created
+type DirError struct {
+	Path string
+	Dir  string
+	Err  error
+}
+
+func (e *DirError) Error() string {
+	return fmt.Sprintf("%s: could not create directory %s: %v", e.Path, e.Dir, e.Err)
+}
+
+func (e *DirError) Unwrap() error {
+	return e.Err
+}
+
+// isUnreadable checks if err is a source file that can not be read because of its permissions
+func isUnreadable(err error) bool {
+	var readErr *ReadError
+	return errors.As(err, &readErr) && errors.Is(readErr.Err, os.ErrPermission)
+}
+
+// WriteError is returned when a built file could not be written to the destination
+type WriteError struct {
+	Path string
+	Err  error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("could not write %s: %v", e.Path, e.Err)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// VerifyError is returned when a file read back from the destination is not what was written
+type VerifyError struct {
+	Path     string
+	Expected string
+	Actual   string
+	// Err is set when the file could not be read back at all
+	Err error
+}
+
+func (e *VerifyError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("verify failed for %s: %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("verify failed for %s: wrote sha256 %s but read back %s", e.Path, e.Expected, e.Actual)
+}
+
+func (e *VerifyError) Unwrap() error {
+	return e.Err
+}
+
+// LintError is returned when php -l finds a built PHP file is not valid PHP, usually because
+// a build tag dropped half of a block
+type LintError struct {
+	Path   string
+	Source string
+	// Msg is the error php -l found, its line is a line of the built file
+	Msg string
+	// Err is set when php could not be run at all
+	Err error
+}
+
+func (e *LintError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("could not lint %s: %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("lint failed for %s built from %s: %s", e.Path, e.Source, e.Msg)
+}
+
+func (e *LintError) Unwrap() error {
+	return e.Err
+}
+
+// ProcessError is returned when a Processor failed to transform a file
+type ProcessError struct {
+	Path      string
+	Processor string
+	Err       error
+}
+
+func (e *ProcessError) Error() string {
+	return fmt.Sprintf("processor %s failed on %s: %v", e.Processor, e.Path, e.Err)
+}
+
+func (e *ProcessError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is returned for a file when building it panicked, the rest of the build carries on
+type PanicError struct {
+	Path  string
+	Value interface{}
+	// Stack is where the panic happened
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic while building %s: %v", e.Path, e.Value)
+}
+
+// isNoSpace checks if err comes from a disk being full
+func isNoSpace(err error) bool {
+	if errors.Is(err, syscall.ENOSPC) {
+		return true
+	}
+	var errno syscall.Errno
+	// ERROR_HANDLE_DISK_FULL and ERROR_DISK_FULL
+	return runtime.GOOS == "windows" && errors.As(err, &errno) && (errno == 39 || errno == 112)
+}
+
+// Failure is a file or symlink that failed to build, see Result.Failures
+type Failure struct {
+	Path string
+	// Operation is what was being done when it failed: read, parse, version, process, mkdir,
+	// write, symlink, verify, lint, conflict, timeout or build for anything else
+	Operation string
+	Err       error
+}
+
+// failedOperation works out what was being done when err happened to a file, link is set
+// for a symlink
+func failedOperation(err error, link bool) string {
+	var (
+		dirErr    *DirError
+		parseErr  *ParseError
+		readErr   *ReadError
+		writeErr  *WriteError
+		verifyErr *VerifyError
+		procErr   *ProcessError
+		lintErr   *LintError
+	)
+	switch {
+	case errors.As(err, &dirErr):
+		return "mkdir"
+	case errors.As(err, &parseErr):
+		return "parse"
+	case errors.As(err, &readErr), errors.Is(err, ErrSourceMissing):
+		return "read"
+	case errors.Is(err, ErrMissingVersion):
+		return "version"
+	case errors.As(err, &procErr):
+		return "process"
+	case errors.As(err, &verifyErr):
+		return "verify"
+	case errors.As(err, &lintErr):
+		return "lint"
+	case errors.Is(err, ErrConflict), errors.Is(err, ErrCaseCollision):
+		return "conflict"
+	case errors.Is(err, ErrTimedOut):
+		return "timeout"
+	case link:
+		return "symlink"
+	case errors.As(err, &writeErr):
+		return "write"
+	}
+	return "build"
+}
@@ -0,0 +1,166 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildFileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		// setup prepares the destination, dest is where the file is built to
+		setup func(t *testing.T, dest string)
+		check func(t *testing.T, err error)
+	}{
+		{
+			name: "missing source",
+			check: func(t *testing.T, err error) {
+				if !errors.Is(err, ErrSourceMissing) {
+					t.Errorf("got %v, want ErrSourceMissing", err)
+				}
+			},
+		},
+		{
+			name:   "unclosed BEGIN",
+			source: "<?php\n// BEGIN SUGARCRM flav=ent ONLY\n$a = 1;\n",
+			check:  wantParseError(2),
+		},
+		{
+			name:   "END without BEGIN",
+			source: "<?php\n$a = 1;\n// END SUGARCRM flav=ent ONLY\n",
+			check:  wantParseError(3),
+		},
+		{
+			name:   "END for another BEGIN",
+			source: "<?php\n// BEGIN SUGARCRM flav=ent ONLY\n// END SUGARCRM flav=pro ONLY\n",
+			check:  wantParseError(3),
+		},
+		{
+			name:   "destination is a folder",
+			source: "<?php\n",
+			setup: func(t *testing.T, dest string) {
+				if err := os.MkdirAll(filepath.Join(dest, "inside"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			},
+			check: func(t *testing.T, err error) {
+				var writeErr *WriteError
+				if !errors.As(err, &writeErr) {
+					t.Errorf("got %T %v, want a *WriteError", err, err)
+				}
+			},
+		},
+		{
+			name:   "destination folder is a file",
+			source: "<?php\n",
+			setup: func(t *testing.T, dest string) {
+				if err := ioutil.WriteFile(filepath.Dir(dest), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			check: func(t *testing.T, err error) {
+				var dirErr *DirError
+				if !errors.As(err, &dirErr) {
+					t.Errorf("got %T %v, want a *DirError", err, err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src", "a.php")
+			dest := filepath.Join(dir, "dest", "a.php")
+			if tt.source != "" {
+				if err := os.MkdirAll(filepath.Dir(src), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(src, []byte(tt.source), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.setup != nil {
+				tt.setup(t, dest)
+			}
+			for name, buildFn := range map[string]func(string, string, string, string) (bool, error){"BuildFile": BuildFile, "StreamFile": StreamFile} {
+				_, err := buildFn(src, dest, "ent", "7.0")
+				if err == nil {
+					t.Fatalf("%s did not fail", name)
+				}
+				tt.check(t, err)
+			}
+		})
+	}
+}
+
+// wantParseError checks for a *ParseError on line
+func wantParseError(line int) func(t *testing.T, err error) {
+	return func(t *testing.T, err error) {
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("got %T %v, want a *ParseError", err, err)
+			return
+		}
+		if parseErr.Line != line {
+			t.Errorf("parse error on line %d, want %d: %v", parseErr.Line, line, err)
+		}
+	}
+}
+
+func TestBuildContentParseError(t *testing.T) {
+	_, err := BuildContent(strings.NewReader("<?php\n// BEGIN SUGARCRM flav=ent ONLY\n"), "ent", "7.0")
+	wantParseError(2)(t, err)
+	if err != nil && !strings.HasPrefix(err.Error(), "<content>:2: ") {
+		t.Errorf("the error does not name the content: %v", err)
+	}
+}
+
+func TestFailedOperation(t *testing.T) {
+	tests := []struct {
+		err  error
+		link bool
+		want string
+	}{
+		{&DirError{Path: "a", Dir: "b", Err: os.ErrPermission}, false, "mkdir"},
+		{&ParseError{Path: "a", Line: 1}, false, "parse"},
+		{fmt.Errorf("wrapped: %w", &ParseError{Path: "a", Line: 1}), false, "parse"},
+		{&ReadError{Path: "a", Err: os.ErrPermission}, false, "read"},
+		{fmt.Errorf("a: %w", ErrSourceMissing), false, "read"},
+		{fmt.Errorf("a: %w", ErrMissingVersion), false, "version"},
+		{&ProcessError{Path: "a", Processor: "p", Err: errors.New("x")}, false, "process"},
+		{&VerifyError{Path: "a"}, false, "verify"},
+		{&LintError{Path: "a"}, false, "lint"},
+		{fmt.Errorf("a: %w", ErrConflict), false, "conflict"},
+		{fmt.Errorf("a: %w", ErrCaseCollision), false, "conflict"},
+		{fmt.Errorf("a: %w", ErrTimedOut), false, "timeout"},
+		{&WriteError{Path: "a", Err: os.ErrPermission}, false, "write"},
+		{&WriteError{Path: "a", Err: os.ErrPermission}, true, "symlink"},
+		{errors.New("anything else"), false, "build"},
+	}
+	for _, tt := range tests {
+		if got := failedOperation(tt.err, tt.link); got != tt.want {
+			t.Errorf("failedOperation(%v, %v) = %q, want %q", tt.err, tt.link, got, tt.want)
+		}
+	}
+}
+
+func TestErrorsUnwrap(t *testing.T) {
+	for _, err := range []error{
+		&ReadError{Path: "a", Err: os.ErrPermission},
+		&WriteError{Path: "a", Err: os.ErrPermission},
+		&DirError{Path: "a", Dir: "b", Err: os.ErrPermission},
+		&VerifyError{Path: "a", Err: os.ErrPermission},
+		&ProcessError{Path: "a", Processor: "p", Err: os.ErrPermission},
+		&LintError{Path: "a", Err: os.ErrPermission},
+	} {
+		if !errors.Is(fmt.Errorf("wrapped: %w", err), os.ErrPermission) {
+			t.Errorf("%T does not unwrap to what caused it", err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package build
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// Explanation is what Explain found out about how a file is built
+type Explanation struct {
+	Path    string
+	Flavor  string
+	Version string
+	// Processed is false for files whose tags and variables are never looked at, they are
+	// copied as they are
+	Processed bool
+	// Built is false when a FILE tag excludes the whole file from the flavor
+	Built bool
+	// Note says why the file as a whole was copied or left out, it is empty otherwise
+	Note  string
+	Lines []ExplainedLine
+	// Output is exactly what a build writes to the destination
+	Output []byte
+}
+
+// ExplainedLine is a single line of the source and what the build did with it
+type ExplainedLine struct {
+	Line   int
+	Source string
+	// Output is the line after its variables were substituted, it is only written when Kept
+	Output string
+	Kept   bool
+	// Note says why the line was kept or dropped or what was substituted in it, it is
+	// empty for a line that is kept as it is
+	Note string
+}
+
+// Explain builds srcPath in memory for flavor and version and traces every line: the build
+// tags, which lines each of them keeps or drops and where variables were substituted. When the
+// tags can not be parsed the lines traced up to the error are returned along with it.
+func Explain(srcPath string, flavor string, version string) (*Explanation, error) {
+	content, err := ioutil.ReadFile(srcPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s: %w", srcPath, ErrSourceMissing)
+		}
+		return nil, &ReadError{Path: srcPath, Err: err}
+	}
+	e := &Explanation{Path: srcPath, Flavor: flavor, Version: version, Built: true}
+
+	if !canProcessFile(srcPath) {
+		e.Note = fmt.Sprintf("files ending in %q are copied as they are, only %s files are processed", path.Ext(srcPath), strings.Join(ProcessibleExtensions, ", "))
+		e.Output = content
+		return e, nil
+	}
+	e.Processed = true
+
+	fileString, _ := cutBOM(string(content))
+	// like buildContent only the first tag can be a FILE tag for the whole file
+	var fileTagLine int
+	if matches := TagRegex.FindStringSubmatch(fileString); matches != nil && matches[1] == "FILE" {
+		fileTagLine = lineOf(fileString, matches[0])
+		ok, err := fileTagAllows(matches, srcPath, fileTagLine, flavor)
+		if err != nil {
+			return e, err
+		}
+		if !ok {
+			e.Built = false
+			e.Note = fmt.Sprintf("FILE %s on line %d leaves the whole file out of %s", strings.TrimSpace(matches[2]), fileTagLine, flavor)
+			return e, nil
+		}
+		e.Note = fmt.Sprintf("FILE %s on line %d builds the whole file for %s", strings.TrimSpace(matches[2]), fileTagLine, flavor)
+	}
+	if err := e.traceLines(fileString, fileTagLine); err != nil {
+		return e, err
+	}
+
+	e.Output, _, err = renderFile(srcPath, srcPath, fileOptions{flavor: flavor, version: version})
+	return e, err
+}
+
+// traceLines follows the lines the same way processLines does with tagBlocks, every tag line is
+// dropped. fileTagLine is the line of the FILE tag that decided the file was built.
+func (e *Explanation) traceLines(fileString string, fileTagLine int) error {
+	var blocks tagBlocks
+	scanner := newLineScanner(strings.NewReader(fileString))
+	var lineNum int
+	for scanner.Scan() {
+		lineNum++
+		line := ExplainedLine{Line: lineNum, Source: scanner.Text()}
+		line.Output = replaceVars(line.Source, e.Flavor, e.Version)
+
+		if matches := TagRegex.FindStringSubmatch(line.Output); matches != nil {
+			condition := strings.TrimSpace(matches[2])
+			switch matches[1] {
+			case "BEGIN":
+				tagOk, err := tagAllows(matches[2], e.Flavor)
+				if err != nil {
+					return &ParseError{Path: e.Path, Line: lineNum, Msg: "BEGIN " + err.Error()}
+				}
+				inside, _ := blocks.droppedBy()
+				blocks.begin(lineNum, condition, tagOk)
+				switch {
+				case inside > 0:
+					line.Note = fmt.Sprintf("BEGIN %s is inside lines dropped by line %d, the lines after it are dropped too", condition, inside)
+				case tagOk:
+					line.Note = fmt.Sprintf("BEGIN %s keeps the lines after it for %s", condition, e.Flavor)
+				default:
+					line.Note = fmt.Sprintf("BEGIN %s drops the lines after it for %s", condition, e.Flavor)
+				}
+			case "END":
+				if msg := blocks.end(matches[2]); msg != "" {
+					return &ParseError{Path: e.Path, Line: lineNum, Msg: msg}
+				}
+				if blocks.keep() {
+					line.Note = "END keeps the lines after it again"
+				} else {
+					by, kind := blocks.droppedBy()
+					line.Note = fmt.Sprintf("END closes the block, the lines after it are still dropped by the %s on line %d", kind, by)
+				}
+			case "ELSE":
+				if msg := blocks.elseBranch(lineNum, matches[2]); msg != "" {
+					return &ParseError{Path: e.Path, Line: lineNum, Msg: msg}
+				}
+				if blocks.keep() {
+					line.Note = fmt.Sprintf("ELSE keeps the lines up to the END for %s", e.Flavor)
+				} else {
+					line.Note = fmt.Sprintf("ELSE drops the lines up to the END for %s", e.Flavor)
+				}
+			case "FILE":
+				line.Note = "FILE only counts as the first tag in a file, this one is dropped"
+				if lineNum == fileTagLine {
+					line.Note = "FILE decides if the whole file is built, the tag itself is dropped"
+				}
+			}
+			e.Lines = append(e.Lines, line)
+			continue
+		}
+
+		line.Kept = blocks.keep()
+		switch {
+		case !line.Kept:
+			by, kind := blocks.droppedBy()
+			line.Note = fmt.Sprintf("dropped by the %s on line %d", kind, by)
+		case line.Output != line.Source:
+			var substituted []string
+			if strings.Contains(line.Source, versionVar) {
+				substituted = append(substituted, fmt.Sprintf("%s with %q", versionVar, e.Version))
+			}
+			if strings.Contains(line.Source, flavorVar) {
+				substituted = append(substituted, fmt.Sprintf("%s with %q", flavorVar, e.Flavor))
+			}
+			for _, v := range buildInfo.vars() {
+				if strings.Contains(line.Source, v[0]) {
+					substituted = append(substituted, fmt.Sprintf("%s with %q", v[0], v[1]))
+				}
+			}
+			line.Note = "replaced " + strings.Join(substituted, " and ")
+		}
+		e.Lines = append(e.Lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading %s: %w", e.Path, err)
+	}
+	if line := blocks.unclosed(); line > 0 {
+		return &ParseError{Path: e.Path, Line: line, Msg: "BEGIN tag is never closed with an END"}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package build
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// WriteFailures saves the relative paths of the files that failed to build to path, one per
+// line, so they can be built again with ReadFailures and Options.Paths
+func WriteFailures(path string, paths []string) error {
+	sorted := append([]string(nil), paths...)
+	sort.Strings(sorted)
+	var content strings.Builder
+	for _, p := range sorted {
+		content.WriteString(filepath.ToSlash(p) + "\n")
+	}
+	return ioutil.WriteFile(path, []byte(content.String()), 0644)
+}
+
+// ReadFailures reads the relative paths written by WriteFailures, blank lines and repeats
+// are ignored
+func ReadFailures(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("failures file %s does not exist", path)
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var paths []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		rel := filepath.Clean(filepath.FromSlash(line))
+		if !seen[rel] {
+			seen[rel] = true
+			paths = append(paths, rel)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return paths, nil
+}
@@ -2,138 +2,495 @@ package build
 
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"bytes"
 	"bufio"
+	"io"
+	"math"
 	"strings"
 	"regexp"
 	"io/ioutil"
 	"path"
+	"hash"
+	"time"
 
 	"github.com/jwhitcraft/rome/utils"
 )
 
 var (
 	ProcessibleExtensions = []string{
-		"php", "json", "js",
+		"php", "json", "js", "tpl", "html", "xml",
 	}
+	// Flavors has the flavors every flavor builds the tags of, each one is a superset of the one before it
 	Flavors = map[string][]string{
-		"pro": {"pro"},
-		"corp": {"pro", "corp"},
-		"ent": {"pro", "corp", "ent"},
-		"ult": {"pro", "corp", "ent", "ult"},
+		"core": {"core"},
+		"pro": {"core", "pro"},
+		"corp": {"core", "pro", "corp"},
+		"ent": {"core", "pro", "corp", "ent"},
+		"ult": {"core", "pro", "corp", "ent", "ult"},
 	}
+	// FlavorOrder lists the Flavors from the smallest to the largest
+	FlavorOrder = []string{"core", "pro", "corp", "ent", "ult"}
 
-	TagRegex = regexp.MustCompile("//[[:space:]]*(BEGIN|END|FILE|ELSE)[[:space:]]*SUGARCRM[[:space:]]*(.*) ONLY")
+	// TagRegex finds build tags in //, /* */, <!-- --> and Smarty {* *} comments, the condition
+	// runs up to ONLY or, when ONLY is left out, the end of the comment
+	TagRegex = regexp.MustCompile(`(?://|/\*|<!--|\{\*)[[:space:]]*(BEGIN|END|FILE|ELSE)[[:space:]]*SUGARCRM[[:space:]]*(.*?)(?: ONLY|[[:space:]]*(?:\*/|-->|\*\}))`)
 
-	VarRegex = regexp.MustCompile( "@_SUGAR_(FLAV|VERSION)")
+	VarRegex = regexp.MustCompile( "@_SUGAR_(FLAV|VERSION|BUILD_SHA|BUILD_DATE|BUILDER)")
 )
 
-func BuildFile(srcPath string, destPath string, buildFlavor string, buildVersion string) bool {
-	var useLine bool = true
-	var shouldProcess bool = false
+// versionVar is replaced with the version being built
+const versionVar = "@_SUGAR_VERSION"
 
-	var skippedLines utils.Counter
+// flavorVar is replaced with the flavor being built
+const flavorVar = "@_SUGAR_FLAV"
+
+// BuildFile builds a single file from srcPath into destPath for the given flavor and version.
+// It returns false when the file was not written because its FILE tag excludes the flavor.
+func BuildFile(srcPath string, destPath string, buildFlavor string, buildVersion string) (bool, error) {
+	return buildFile(srcPath, destPath, fileOptions{flavor: buildFlavor, version: buildVersion})
+}
+
+// fileOptions carries everything that changes how a single file is built
+type fileOptions struct {
+	flavor  string
+	version string
+
+	// lineEndings is applied to everything but binary files
+	lineEndings LineEndings
+
+	// stripBOM removes the byte order mark from the start of everything but binary files
+	stripBOM bool
+
+	// resolveIncludes inlines the files include directives in kept blocks point to
+	resolveIncludes bool
+
+	// verbatim copies the file without looking at its build tags or variables
+	verbatim bool
+
+	// linkIdentical hardlinks the file to its source when it would be copied as it is and
+	// reflink clones it from the source instead, if the filesystem can
+	linkIdentical bool
+	reflink       ReflinkMode
+
+	// fileMode and dirMode are the permissions of what is created, zero keeps the defaults
+	fileMode os.FileMode
+	dirMode  os.FileMode
+
+	// preserveMode gives the file the permissions of its source instead of fileMode and
+	// preserveTimes its modification time, neither applies to a sink
+	preserveMode  bool
+	preserveTimes bool
+
+	// failMissingVersion fails files that use the version placeholder, it is set when there
+	// is no version to replace it with
+	failMissingVersion bool
+
+	// sink, when set, gets the file under sinkName instead of it being written to the disk
+	sink     Sink
+	sinkName string
+
+	// ctx stops the build of the file between reads and lines once it is done, it may be nil
+	ctx context.Context
+
+	// warn is told about anything suspicious in the file, it may be nil
+	warn warnFunc
+
+	// hash is fed every byte written to the destination when it is set
+	hash hash.Hash
+
+	// versioned, when set, is told if the file uses the version variable
+	versioned *bool
+
+	// noOp, when set, is told if the file was processed without finding any tags or variables
+	noOp *bool
 
+	// written, when set, is added to for every byte written to the destination
+	written *int64
+
+	// processors transform what the file is built into, rel is the path they are told about
+	processors []Processor
+	rel        string
+}
+
+// destWriter adds the hash and the byte count, if they are wanted, to the writer for the destination
+func (fo fileOptions) destWriter(w io.Writer) io.Writer {
+	if fo.written != nil {
+		w = &countingWriter{w: w, n: fo.written}
+	}
+	if fo.hash == nil {
+		return w
+	}
+	return io.MultiWriter(w, fo.hash)
+}
+
+// create starts writing the file built from srcPath to destPath, or to the sink when there is one
+func (fo fileOptions) create(srcPath string, destPath string) (SinkFile, error) {
+	if fo.sink != nil {
+		fw, err := fo.sink.Create(fo.sinkName)
+		if err != nil {
+			return nil, &WriteError{Path: destPath, Err: err}
+		}
+		return fw, nil
+	}
 	// lets make sure the that folder exists
-	var destFolder string = path.Dir(destPath)
-	var fileExt string = path.Ext(destPath)
-	// var fileName string = path.Base(destPath)
-	os.MkdirAll(destFolder, 0775)
+	if err := MakeDirs(path.Dir(destPath), fo.dirMode); err != nil {
+		return nil, &DirError{Path: destPath, Dir: path.Dir(destPath), Err: err}
+	}
+	mode := fo.fileMode
+	if fo.preserveMode {
+		info, err := os.Stat(srcPath)
+		if err != nil {
+			return nil, &ReadError{Path: srcPath, Err: err}
+		}
+		mode = info.Mode().Perm()
+	}
+	fw, err := createAtomic(destPath, mode)
+	if err != nil {
+		return nil, &WriteError{Path: destPath, Err: err}
+	}
+	return fw, nil
+}
 
-	var canProcess bool = contains(ProcessibleExtensions, fileExt)
+// keepTimes gives destPath the modification time of srcPath when preserveTimes is set
+func (fo fileOptions) keepTimes(srcPath string, destPath string) error {
+	if !fo.preserveTimes || fo.sink != nil {
+		return nil
+	}
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(destPath, time.Now(), info.ModTime())
+}
 
-	// regardless, if the file is in the node_modules folder
-	// don't try and process it
-	if strings.Contains(destFolder, "node_modules") {
-		canProcess = false
+// reader stops reading r with the error of ctx once it is done
+func (fo fileOptions) reader(r io.Reader) io.Reader {
+	if fo.ctx == nil {
+		return r
+	}
+	return &ctxReader{ctx: fo.ctx, r: r}
+}
+
+// context is ctx, or a context that is never done when there is none
+func (fo fileOptions) context() context.Context {
+	if fo.ctx == nil {
+		return context.Background()
+	}
+	return fo.ctx
+}
+
+// canceled returns the error of ctx once it is done
+func (fo fileOptions) canceled() error {
+	if fo.ctx == nil {
+		return nil
+	}
+	return fo.ctx.Err()
+}
+
+// ctxReader checks ctx before every read
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// countingWriter adds how many bytes went through it to n
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+func buildFile(srcPath string, destPath string, fo fileOptions) (bool, error) {
+	fileBytes, built, err := renderFile(srcPath, destPath, fo)
+	if err != nil || !built {
+		return false, err
+	}
+	if err := fo.canceled(); err != nil {
+		return false, err
+	}
+
+	fw, err := fo.create(srcPath, destPath)
+	if err != nil {
+		return false, err
 	}
+	defer fw.Abort()
 
+	// write the file to the disk
+	if _, err := fo.destWriter(fw).Write(fileBytes); err != nil {
+		return false, &WriteError{Path: destPath, Err: err}
+	}
+	if err := fw.Commit(); err != nil {
+		return false, &WriteError{Path: destPath, Err: err}
+	}
+	if err := fo.keepTimes(srcPath, destPath); err != nil {
+		return false, &WriteError{Path: destPath, Err: err}
+	}
+
+	return true, nil
+}
+
+// renderFile builds srcPath in memory the same way buildFile would write it to destPath, it
+// returns false when the file's FILE tag excludes the flavor
+func renderFile(srcPath string, destPath string, fo fileOptions) ([]byte, bool, error) {
 	// first load the whole file to check for the build tags
 	fileBytes, err := ioutil.ReadFile(srcPath)
-	fileString := string(fileBytes)
-	if canProcess && TagRegex.MatchString(fileString) {
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, fmt.Errorf("%s: %w", srcPath, ErrSourceMissing)
+		}
+		return nil, false, &ReadError{Path: srcPath, Err: err}
+	}
+
+	if err := fo.canceled(); err != nil {
+		return nil, false, err
+	}
+
+	if !fo.verbatim && canProcessFile(destPath) {
+		versioned := bytes.Contains(fileBytes, []byte(versionVar))
+		if fo.versioned != nil {
+			*fo.versioned = versioned
+		}
+		if versioned && fo.failMissingVersion {
+			return nil, false, fmt.Errorf("%s: %w", srcPath, ErrMissingVersion)
+		}
+		if fo.noOp != nil {
+			*fo.noOp = !VarRegex.Match(fileBytes) && !TagRegex.Match(fileBytes) && !(fo.resolveIncludes && IncludeRegex.Match(fileBytes)) && len(fo.processors) == 0
+		}
+		var inc *includer
+		if fo.resolveIncludes {
+			inc = newIncluder(srcPath, fo.flavor, fo.version)
+		}
+		fileBytes, err = buildContent(fo.context(), string(fileBytes), srcPath, fo.flavor, fo.version, fo.warn, inc)
+		if errors.Is(err, ErrFileExcluded) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	if !isBinary(fileBytes) {
+		fileBytes = normalizeLineEndings(fileBytes, fo.lineEndings)
+		if fo.stripBOM && hasBOM(fileBytes) {
+			fileBytes = fileBytes[len(utf8BOM):]
+		}
+	}
+	if fileBytes, err = fo.process(srcPath, fileBytes); err != nil {
+		return nil, false, err
+	}
+	return fileBytes, true, nil
+}
+
+// BuildToWriter builds srcPath exactly like BuildFile would but writes the result to w instead of a destination
+func BuildToWriter(srcPath string, w io.Writer, buildFlavor string, buildVersion string) (bool, error) {
+	fileBytes, err := ioutil.ReadFile(srcPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, fmt.Errorf("%s: %w", srcPath, ErrSourceMissing)
+		}
+		return false, err
+	}
+	if canProcessFile(srcPath) {
+		fileBytes, err = buildContent(context.Background(), string(fileBytes), srcPath, buildFlavor, buildVersion, nil, nil)
+		if errors.Is(err, ErrFileExcluded) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	_, err = w.Write(fileBytes)
+	return true, err
+}
+
+// contentPath stands in for the path in errors about content that is not read from a file
+const contentPath = "<content>"
+
+// BuildContent runs the build tag and variable substitution over everything in reader
+// without touching the disk. ErrFileExcluded is returned when a FILE tag excludes the flavor,
+// a ParseError has the path <content>.
+func BuildContent(reader io.Reader, buildFlavor string, buildVersion string) ([]byte, error) {
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return buildContent(context.Background(), string(content), contentPath, buildFlavor, buildVersion, nil, nil)
+}
+
+// buildContent does the substitution for BuildContent and BuildFile, srcPath is only used for errors.
+// It stops with the error of ctx once ctx is done.
+// A byte order mark is kept out of the way of the tags on the first line and put back after.
+// Include directives are only resolved when inc is set.
+func buildContent(ctx context.Context, fileString string, srcPath string, buildFlavor string, buildVersion string, warn warnFunc, inc *includer) ([]byte, error) {
+	var shouldProcess bool = false
+	fileString, bom := cutBOM(fileString)
+	if inc != nil && IncludeRegex.MatchString(fileString) {
+		shouldProcess = true
+	}
+	if TagRegex.MatchString(fileString) {
 		shouldProcess = true
 		// check to see if it's a type of FILE
 		matches := TagRegex.FindStringSubmatch(fileString)
-		if matches[1] == "FILE" {
-			tagFlav := getTagFlavor(matches[2])
-			tagOk := contains(Flavors[buildFlavor], tagFlav)
-			//fmt.Printf("// File Tag Found for flavor: %s and building %s, should build file: %t\n", tagFlav, buildFlavor, tagOk)
-			if tagOk == false {
-				return false
-			}
+		tagOk, err := fileTagAllows(matches, srcPath, lineOf(fileString, matches[0]), buildFlavor)
+		if err != nil {
+			return nil, err
+		}
+		if !tagOk {
+			return nil, ErrFileExcluded
 		}
 	}
 
 	// do the variable replacement
-	if canProcess && VarRegex.MatchString(fileString) {
-		matches := VarRegex.FindStringSubmatch(fileString)
-		switch matches[1] {
-		case "VERSION":
-			fileString = strings.Replace(fileString, "@_SUGAR_VERSION", buildVersion, -1)
-		case "FLAV":
-			fileString = strings.Replace(fileString, "@_SUGAR_FLAV", buildFlavor, -1)
-		}
+	fileString = replaceVars(fileString, buildFlavor, buildVersion)
+	var out bytes.Buffer
+	if bom {
+		out.Write(utf8BOM)
+	}
+	if !shouldProcess {
+		out.WriteString(fileString)
+		return out.Bytes(), nil
 	}
 
+	if err := processLines(ctx, newLineScanner(strings.NewReader(fileString)), &out, srcPath, buildFlavor, warn, inc); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
 
-	if err != nil {
-		fmt.Printf("pre-preocess error: %v\n",err)
+// canProcessFile checks if the build tags and variables in a file should be looked at
+func canProcessFile(destPath string) bool {
+	var fileExt string = strings.TrimPrefix(path.Ext(destPath), ".")
+
+	// regardless, if the file is in the node_modules folder
+	// don't try and process it
+	if strings.Contains(path.Dir(destPath), "node_modules") {
 		return false
 	}
 
-	fw, err := os.Create(destPath)
-	defer fw.Close()
+	return contains(ProcessibleExtensions, fileExt)
+}
+
+// fileTagAllows checks the first build tag found in a file, when it is a FILE tag the whole
+// file is only built if the tag allows the flavor
+func fileTagAllows(matches []string, srcPath string, line int, buildFlavor string) (bool, error) {
+	if matches[1] != "FILE" {
+		return true, nil
+	}
+	tagOk, err := tagAllows(matches[2], buildFlavor)
+	if err != nil {
+		return false, &ParseError{Path: srcPath, Line: line, Msg: "FILE " + err.Error()}
+	}
+	//fmt.Printf("// File Tag Found for flavor: %s and building %s, should build file: %t\n", matches[2], buildFlavor, tagOk)
+	return tagOk, nil
+}
+
+// replaceVars substitutes the flavor and version variables and those of the BuildInfo
+func replaceVars(content string, buildFlavor string, buildVersion string) string {
+	if !VarRegex.MatchString(content) {
+		return content
+	}
+	content = strings.Replace(content, "@_SUGAR_VERSION", buildVersion, -1)
+	content = buildInfo.replace(content)
+	return strings.Replace(content, "@_SUGAR_FLAV", buildFlavor, -1)
+}
 
-	if shouldProcess {
-		f := bytes.NewReader(fileBytes)
-		if err != nil {
-			fmt.Printf("error opening file: %v\n",err)
-			os.Exit(1)
-		}
-		writer := bufio.NewWriter(fw)
-		scanner := bufio.NewScanner(f)
-		for scanner.Scan() {
-			val := scanner.Text()
-
-			if TagRegex.MatchString(val) {
-				// get the matches
-				matches := TagRegex.FindStringSubmatch(val)
-
-				switch matches[1] {
-				case "BEGIN":
-					tagFlav := getTagFlavor(matches[2])
-					tagOk := contains(Flavors[buildFlavor], tagFlav)
-					//fmt.Printf("// Begin Tag Found for flavor: %s and building %s, should use lines: %t\n", tagFlav, buildFlavor, tagOk)
-					useLine = tagOk
-					if tagOk == false {
-						skippedLines.Increment()
-					}
-				case "END":
-					//fmt.Printf("// Skipped %d lines\n", skippedLines.get())
-					skippedLines.Reset()
-					useLine = true
+// newLineScanner scans the lines of r, unlike a plain bufio.Scanner it takes lines of any
+// length, a minified bundle or a data file can be a single line of megabytes
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), math.MaxInt)
+	return scanner
+}
+
+// processLines writes every line from scanner that the build tags allow for the flavor, see tagBlocks.
+// ctx is checked before every line, so a file with an endless or huge number of lines is stopped.
+func processLines(ctx context.Context, scanner *bufio.Scanner, writer io.Writer, srcPath string, buildFlavor string, warn warnFunc, inc *includer) error {
+	var blocks tagBlocks
+	var skippedLines utils.Counter
+	var lineNum int
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		lineNum++
+		val := scanner.Text()
+
+		if TagRegex.MatchString(val) {
+			// get the matches
+			matches := TagRegex.FindStringSubmatch(val)
+			if msg := tagWarning(matches[1], matches[2]); msg != "" {
+				warn.warn(lineNum, msg)
+			}
+
+			switch matches[1] {
+			case "BEGIN":
+				tagOk, err := tagAllows(matches[2], buildFlavor)
+				if err != nil {
+					return &ParseError{Path: srcPath, Line: lineNum, Msg: "BEGIN " + err.Error()}
+				}
+				//fmt.Printf("// Begin Tag Found for flavor: %s and building %s, should use lines: %t\n", matches[2], buildFlavor, tagOk)
+				blocks.begin(lineNum, strings.TrimSpace(matches[2]), tagOk)
+				if !blocks.keep() {
+					skippedLines.Increment()
+				}
+			case "ELSE":
+				if msg := blocks.elseBranch(lineNum, matches[2]); msg != "" {
+					return &ParseError{Path: srcPath, Line: lineNum, Msg: msg}
 				}
-			} else if useLine {
-				fmt.Println(val) // Println will add back the final '\n'
-				fmt.Fprintln(writer, val)
-			} else {
-				skippedLines.Increment()
+			case "END":
+				if msg := blocks.end(matches[2]); msg != "" {
+					return &ParseError{Path: srcPath, Line: lineNum, Msg: msg}
+				}
+				//fmt.Printf("// Skipped %d lines\n", skippedLines.get())
+				skippedLines.Reset()
 			}
-		}
-		if err := scanner.Err(); err != nil {
-			fmt.Fprintln(os.Stderr, "reading standard input:", err)
+		} else if blocks.keep() {
+			if matches := IncludeRegex.FindStringSubmatch(val); inc != nil && matches != nil {
+				included, err := inc.include(ctx, srcPath, matches[1], lineNum)
+				if err != nil {
+					return err
+				}
+				if _, err := writer.Write(included); err != nil {
+					return err
+				}
+				continue
+			}
+			fmt.Fprintln(writer, val)
 		} else {
-			// write the file to the disk
-			writer.Flush()
+			skippedLines.Increment()
 		}
-	} else {
-		fw.WriteString(fileString)
 	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading %s: %w", srcPath, err)
+	}
+	if line := blocks.unclosed(); line > 0 {
+		return &ParseError{Path: srcPath, Line: line, Msg: "BEGIN tag is never closed with an END"}
+	}
+	return nil
+}
 
-	return true
+// lineOf returns the 1 based line number where match first appears in content
+func lineOf(content string, match string) int {
+	idx := strings.Index(content, match)
+	if idx < 0 {
+		return 0
+	}
+	return strings.Count(content[:idx], "\n") + 1
 }
 
 func getTagFlavor(eval string) string {
@@ -0,0 +1,175 @@
+package build
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// buildString runs BuildContent over source
+func buildString(t *testing.T, source string, flavor string, version string) (string, error) {
+	t.Helper()
+	out, err := BuildContent(strings.NewReader(source), flavor, version)
+	return string(out), err
+}
+
+func TestBuildContent(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		flavor string
+		want   string
+	}{
+		{
+			name:   "no tags",
+			source: "<?php\n$a = 1;\n",
+			flavor: "ent",
+			want:   "<?php\n$a = 1;\n",
+		},
+		{
+			name:   "flav= keeps the block for the flavor",
+			source: "<?php\n// BEGIN SUGARCRM flav=ent ONLY\n$a = 1;\n// END SUGARCRM flav=ent ONLY\n$b = 2;\n",
+			flavor: "ent",
+			want:   "<?php\n$a = 1;\n$b = 2;\n",
+		},
+		{
+			name:   "flav= keeps the block for a flavor above it",
+			source: "<?php\n// BEGIN SUGARCRM flav=pro ONLY\n$a = 1;\n// END SUGARCRM flav=pro ONLY\n",
+			flavor: "ult",
+			want:   "<?php\n$a = 1;\n",
+		},
+		{
+			name:   "flav= drops the block for a flavor below it",
+			source: "<?php\n// BEGIN SUGARCRM flav=ent ONLY\n$a = 1;\n// END SUGARCRM flav=ent ONLY\n$b = 2;\n",
+			flavor: "pro",
+			want:   "<?php\n$b = 2;\n",
+		},
+		{
+			name:   "flav!= keeps the block for other flavors",
+			source: "<?php\n// BEGIN SUGARCRM flav!=ent ONLY\n$a = 1;\n// END SUGARCRM flav!=ent ONLY\n",
+			flavor: "pro",
+			want:   "<?php\n$a = 1;\n",
+		},
+		{
+			name:   "flav!= drops the block for the flavor",
+			source: "<?php\n// BEGIN SUGARCRM flav!=ent ONLY\n$a = 1;\n// END SUGARCRM flav!=ent ONLY\n$b = 2;\n",
+			flavor: "ent",
+			want:   "<?php\n$b = 2;\n",
+		},
+		{
+			name:   "block comment tags",
+			source: "var a = 1;\n/* BEGIN SUGARCRM flav=ent ONLY */\nvar b = 2;\n/* END SUGARCRM flav=ent ONLY */\n",
+			flavor: "pro",
+			want:   "var a = 1;\n",
+		},
+		{
+			name:   "variables",
+			source: "<?php\n$version = '@_SUGAR_VERSION';\n$flavor = '@_SUGAR_FLAV';\n",
+			flavor: "ent",
+			want:   "<?php\n$version = '7.0';\n$flavor = 'ent';\n",
+		},
+		{
+			name:   "variables in a kept block",
+			source: "<?php\n// BEGIN SUGARCRM flav=pro ONLY\n$v = '@_SUGAR_VERSION @_SUGAR_FLAV';\n// END SUGARCRM flav=pro ONLY\n",
+			flavor: "ent",
+			want:   "<?php\n$v = '7.0 ent';\n",
+		},
+		{
+			name:   "FILE tag that keeps the file",
+			source: "<?php\n// FILE SUGARCRM flav=pro ONLY\n$a = 1;\n",
+			flavor: "ent",
+			want:   "<?php\n$a = 1;\n",
+		},
+	}
+	for _, tt := range tests {
+		got, err := buildString(t, tt.source, tt.flavor, "7.0")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: built %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBuildContentFileTagExcludes(t *testing.T) {
+	_, err := buildString(t, "<?php\n// FILE SUGARCRM flav=ent ONLY\n$a = 1;\n", "pro", "7.0")
+	if !errors.Is(err, ErrFileExcluded) {
+		t.Errorf("got %v, want ErrFileExcluded", err)
+	}
+}
+
+func TestBuildContentBlocks(t *testing.T) {
+	nested := "a\n" +
+		"// BEGIN SUGARCRM flav=pro ONLY\n" +
+		"b\n" +
+		"// BEGIN SUGARCRM flav=ent ONLY\n" +
+		"c\n" +
+		"// END SUGARCRM flav=ent ONLY\n" +
+		"d\n" +
+		"// END SUGARCRM flav=pro ONLY\n" +
+		"e\n"
+	elseBlock := func(open, elseTag, end string) string {
+		return "a\n" + open + "\nb\n" + elseTag + "\nc\n" + end + "\nd\n"
+	}
+	tests := []struct {
+		name   string
+		source string
+		flavor string
+		want   string
+	}{
+		{"nested blocks both kept", nested, "ent", "a\nb\nc\nd\ne\n"},
+		{"nested block dropped in a kept one", nested, "pro", "a\nb\nd\ne\n"},
+		{"nested block kept in a dropped one", "a\n// BEGIN SUGARCRM flav=ent ONLY\nb\n// BEGIN SUGARCRM flav=pro ONLY\nc\n// END SUGARCRM flav=pro ONLY\nd\n// END SUGARCRM flav=ent ONLY\ne\n", "pro", "a\ne\n"},
+		{"ELSE without a condition when the BEGIN keeps", elseBlock("// BEGIN SUGARCRM flav=ent ONLY", "// ELSE SUGARCRM ONLY", "// END SUGARCRM ONLY"), "ent", "a\nb\nd\n"},
+		{"ELSE without a condition when the BEGIN drops", elseBlock("// BEGIN SUGARCRM flav=ent ONLY", "// ELSE SUGARCRM ONLY", "// END SUGARCRM ONLY"), "pro", "a\nc\nd\n"},
+		{"ELSE with the condition of the BEGIN", elseBlock("// BEGIN SUGARCRM flav=ent ONLY", "// ELSE SUGARCRM flav=ent ONLY", "// END SUGARCRM flav=ent ONLY"), "pro", "a\nc\nd\n"},
+		{"ELSE with the condition spaced differently", elseBlock("// BEGIN SUGARCRM flav in (ent, ult) ONLY", "// ELSE SUGARCRM FLAV IN (ent,ult) ONLY", "// END SUGARCRM flav in (ent, ult) ONLY"), "ult", "a\nb\nd\n"},
+		{"ELSE in block comments", elseBlock("/* BEGIN SUGARCRM flav=ent ONLY */", "/* ELSE SUGARCRM flav=ent ONLY */", "/* END SUGARCRM flav=ent ONLY */"), "pro", "a\nc\nd\n"},
+		{"ELSE in HTML comments", elseBlock("<!-- BEGIN SUGARCRM flav=ent ONLY -->", "<!-- ELSE SUGARCRM -->", "<!-- END SUGARCRM -->"), "ent", "a\nb\nd\n"},
+		{"ELSE in Smarty comments", elseBlock("{* BEGIN SUGARCRM flav=ent *}", "{* ELSE SUGARCRM *}", "{* END SUGARCRM *}"), "pro", "a\nc\nd\n"},
+		{"ELSE in a dropped block", "a\n// BEGIN SUGARCRM flav=ent ONLY\n" + elseBlock("// BEGIN SUGARCRM flav=ult ONLY", "// ELSE SUGARCRM ONLY", "// END SUGARCRM ONLY") + "// END SUGARCRM flav=ent ONLY\n", "pro", "a\n"},
+		{"ELSE in a nested block", "// BEGIN SUGARCRM flav=pro ONLY\n" + elseBlock("// BEGIN SUGARCRM flav=ult ONLY", "// ELSE SUGARCRM ONLY", "// END SUGARCRM ONLY") + "// END SUGARCRM flav=pro ONLY\n", "ent", "a\nc\nd\n"},
+	}
+	for _, tt := range tests {
+		got, err := buildString(t, tt.source, tt.flavor, "7.0")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: built %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBuildContentBlockErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		line   int
+		msg    string
+	}{
+		{"a second ELSE", "// BEGIN SUGARCRM flav=ent ONLY\na\n// ELSE SUGARCRM ONLY\nb\n// ELSE SUGARCRM ONLY\n// END SUGARCRM ONLY\n", 5, "second ELSE"},
+		{"an END without a BEGIN", "a\n// END SUGARCRM flav=ent ONLY\n", 2, "END tag without a matching BEGIN"},
+		{"an END after the block is closed", "// BEGIN SUGARCRM flav=ent ONLY\n// END SUGARCRM flav=ent ONLY\n// END SUGARCRM flav=ent ONLY\n", 3, "END tag without a matching BEGIN"},
+		{"an ELSE without a BEGIN", "a\n// ELSE SUGARCRM ONLY\n", 2, "ELSE tag without a matching BEGIN"},
+		{"an unclosed BEGIN", "// BEGIN SUGARCRM flav=pro ONLY\na\n// BEGIN SUGARCRM flav=ent ONLY\n// END SUGARCRM flav=ent ONLY\n", 1, "never closed"},
+		{"an END for another BEGIN", "// BEGIN SUGARCRM flav=ent ONLY\na\n// END SUGARCRM flav=pro ONLY\n", 3, "does not match the BEGIN flav=ent on line 1"},
+		{"an ELSE for another BEGIN", "// BEGIN SUGARCRM flav=ent ONLY\na\n// ELSE SUGARCRM flav=ult ONLY\n// END SUGARCRM ONLY\n", 3, "does not match the BEGIN flav=ent on line 1"},
+	}
+	for _, tt := range tests {
+		for _, flavor := range []string{"pro", "ent"} {
+			_, err := buildString(t, tt.source, flavor, "7.0")
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Errorf("%s for %s: got %T %v, want a *ParseError", tt.name, flavor, err, err)
+				continue
+			}
+			if parseErr.Line != tt.line || !strings.Contains(parseErr.Msg, tt.msg) || parseErr.Path != contentPath {
+				t.Errorf("%s for %s: got %v, want line %d saying %q", tt.name, flavor, err, tt.line, tt.msg)
+			}
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package build
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Filter selects which files under the sources are built. Patterns are globs where ** crosses
+// folders, a pattern without a / is matched against the name of the file or folder at any depth
+// and anything else against the path relative to the source. Like gitignore an exclude pattern
+// starting with ! includes again what an earlier pattern excluded, and the last pattern to match
+// a file or any folder it is in wins.
+type Filter struct {
+	include []filterPattern
+	exclude []filterPattern
+
+	// only, when set, holds the relative paths of the only files that are built and
+	// onlyDirs every folder they are in
+	only     map[string]bool
+	onlyDirs map[string]bool
+}
+
+type filterPattern struct {
+	pattern  string
+	re       *regexp.Regexp
+	baseOnly bool
+	negate   bool
+
+	// segments matches the pattern a folder at a time, nil where ** can cross folders
+	segments []*regexp.Regexp
+}
+
+// NewFilter compiles the include and exclude patterns. When there are include patterns only
+// files matching one of them are built, files matching an exclude pattern never are.
+func NewFilter(include []string, exclude []string) (*Filter, error) {
+	f := &Filter{}
+	var err error
+	if f.include, err = compilePatterns(include, false); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = compilePatterns(exclude, true); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// LimitTo narrows the filter down to the files at the relative paths, a nil filter is
+// created so the result can always be used
+func (f *Filter) LimitTo(paths map[string]bool) *Filter {
+	if f == nil {
+		f = &Filter{}
+	}
+	f.only = paths
+	f.onlyDirs = make(map[string]bool)
+	for rel := range paths {
+		for dir := filepath.Dir(rel); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+			f.onlyDirs[dir] = true
+		}
+	}
+	return f
+}
+
+// compilePatterns compiles globs, when negatable a leading ! negates the pattern and \! is a
+// literal !
+func compilePatterns(patterns []string, negatable bool) ([]filterPattern, error) {
+	var compiled []filterPattern
+	for _, pattern := range patterns {
+		glob := strings.TrimSuffix(filepath.ToSlash(pattern), "/")
+		negate := strings.HasPrefix(glob, "!")
+		if negate && !negatable {
+			return nil, fmt.Errorf("pattern %q is not valid: only exclude patterns can be negated", pattern)
+		}
+		glob = strings.TrimPrefix(glob, "!")
+		if strings.HasPrefix(glob, `\!`) {
+			glob = glob[1:]
+		}
+		re, _, err := globToRegexp(strings.TrimPrefix(glob, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q is not valid: %v", pattern, err)
+		}
+		p := filterPattern{pattern: pattern, re: re, baseOnly: !strings.Contains(glob, "/"), negate: negate}
+		if negate && !p.baseOnly {
+			for _, segment := range strings.Split(strings.TrimPrefix(glob, "/"), "/") {
+				var segmentRe *regexp.Regexp
+				if !strings.Contains(segment, "**") {
+					if segmentRe, _, err = globToRegexp(segment); err != nil {
+						return nil, fmt.Errorf("pattern %q is not valid: %v", pattern, err)
+					}
+				}
+				p.segments = append(p.segments, segmentRe)
+			}
+		}
+		compiled = append(compiled, p)
+	}
+	return compiled, nil
+}
+
+func (p filterPattern) match(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	if p.baseOnly {
+		return p.re.MatchString(rel[strings.LastIndex(rel, "/")+1:])
+	}
+	return p.re.MatchString(rel)
+}
+
+// couldMatchUnder checks if the pattern can match the folder at rel or anything under it
+func (p filterPattern) couldMatchUnder(rel string) bool {
+	if p.baseOnly {
+		return true
+	}
+	for i, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		if i >= len(p.segments) || p.segments[i] == nil {
+			return true
+		}
+		if !p.segments[i].MatchString(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// excluded checks the exclude patterns against rel and every folder it is in, the last pattern
+// to match decides. negated is set when that was a ! pattern.
+func (f *Filter) excluded(rel string) (excluded bool, negated bool, pattern string) {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i := range parts {
+		prefix := strings.Join(parts[:i+1], "/")
+		for _, p := range f.exclude {
+			if p.match(prefix) {
+				excluded, negated, pattern = !p.negate, p.negate, p.pattern
+			}
+		}
+	}
+	return excluded, negated, pattern
+}
+
+// negated checks if a ! pattern included rel or a folder it is in again
+func (f *Filter) negated(rel string) bool {
+	if f == nil {
+		return false
+	}
+	_, negated, _ := f.excluded(rel)
+	return negated
+}
+
+// negatesUnder checks if a ! pattern could include the folder at rel or anything under it, such
+// folders have to be walked even when they are excluded
+func (f *Filter) negatesUnder(rel string) bool {
+	if f == nil {
+		return false
+	}
+	for _, p := range f.exclude {
+		if p.negate && p.couldMatchUnder(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// allows checks if the file at rel is built, when it is not the reason is returned
+func (f *Filter) allows(rel string) (bool, string) {
+	if f == nil {
+		return true, ""
+	}
+	if excluded, _, pattern := f.excluded(rel); excluded {
+		return false, "matches the exclude pattern " + pattern
+	}
+	if f.only != nil && !f.only[rel] {
+		return false, "has not changed"
+	}
+	if len(f.include) == 0 {
+		return true, ""
+	}
+	for _, p := range f.include {
+		if p.match(rel) {
+			return true, ""
+		}
+	}
+	return false, "does not match any include pattern"
+}
+
+// prunes checks if the folder at rel is excluded, nothing under it is looked at when it is. An
+// excluded folder is still walked when a ! pattern could include something under it.
+func (f *Filter) prunes(rel string) (bool, string) {
+	if f == nil {
+		return false, ""
+	}
+	if excluded, _, pattern := f.excluded(rel); excluded && !f.negatesUnder(rel) {
+		return true, "matches the exclude pattern " + pattern
+	}
+	if f.only != nil && !f.onlyDirs[rel] {
+		return true, "nothing under it has changed"
+	}
+	return false, ""
+}
+
+// IgnoreFile is the file in the root of a source whose patterns are excluded from that source,
+// see Options.IgnoreFiles
+const IgnoreFile = ".romeignore"
+
+// withIgnoreFile returns f with the patterns of the IgnoreFile in root, when it has one, in
+// front of its exclude patterns, so the patterns f was created with still have the last word.
+// Like .gitignore a pattern is relative to root, but a trailing / matches files as well.
+func (f *Filter) withIgnoreFile(root string) (*Filter, error) {
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return f, nil
+	}
+	path := filepath.Join(root, IgnoreFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return f, nil
+	}
+	patterns, err := ReadPatternFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ignored, err := compilePatterns(patterns, true)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	withIgnored := &Filter{}
+	if f != nil {
+		*withIgnored = *f
+	}
+	withIgnored.exclude = append(ignored, withIgnored.exclude...)
+	return withIgnored, nil
+}
+
+// ReadPatternFile reads a file of patterns, one per line, blank lines and lines starting
+// with # are ignored
+func ReadPatternFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("pattern file %s does not exist", path)
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return patterns, nil
+}
@@ -0,0 +1,165 @@
+package build
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFilterAllows(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		allowed map[string]bool
+	}{
+		{
+			name:    "a name matches at any depth",
+			exclude: []string{"*.log", "cache"},
+			allowed: map[string]bool{
+				"a.php":              true,
+				"a.log":              false,
+				"modules/x/a.log":    false,
+				"cache/a.php":        false,
+				"modules/cache/a.js": false,
+				"modules/cached.php": true,
+			},
+		},
+		{
+			name:    "anchored patterns only match from the source",
+			exclude: []string{"/vendor", "modules/*/tests"},
+			allowed: map[string]bool{
+				"vendor/a.php":               false,
+				"modules/vendor/a.php":       true,
+				"modules/Accounts/tests/a":   false,
+				"modules/Accounts/x/tests/a": true,
+				"tests/a.php":                true,
+			},
+		},
+		{
+			name:    "a folder pattern excludes what is under it",
+			exclude: []string{"build/", "**/node_modules/"},
+			allowed: map[string]bool{
+				"build/a.php":                  false,
+				"build/deep/down/a.php":        false,
+				"src/build/a.php":              false,
+				"node_modules.js":              true,
+				"include/js/node_modules/a.js": false,
+			},
+		},
+		{
+			name:    "negation includes again",
+			exclude: []string{"*.js", "!keep.js", "vendor", "!vendor/sugar/**"},
+			allowed: map[string]bool{
+				"a.js":               false,
+				"keep.js":            true,
+				"modules/keep.js":    true,
+				"vendor/a.php":       false,
+				"vendor/sugar/a.php": true,
+				"vendor/sugar/a.js":  true,
+			},
+		},
+		{
+			name:    "the last pattern to match wins",
+			exclude: []string{"!a.php", "*.php"},
+			allowed: map[string]bool{"a.php": false, "b.php": false, "c.js": true},
+		},
+		{
+			name:    "an escaped ! is literal",
+			exclude: []string{`\!important.php`},
+			allowed: map[string]bool{"!important.php": false, "important.php": true},
+		},
+		{
+			name:    "include patterns",
+			include: []string{"*.php", "include/**"},
+			exclude: []string{"include/ignored/**"},
+			allowed: map[string]bool{
+				"a.php":                true,
+				"a.js":                 false,
+				"include/a.js":         true,
+				"include/ignored/a.js": false,
+			},
+		},
+	}
+	for _, tt := range tests {
+		f, err := NewFilter(tt.include, tt.exclude)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for rel, want := range tt.allowed {
+			got, reason := f.allows(filepath.FromSlash(rel))
+			if got != want {
+				t.Errorf("%s: allows(%q) = %v (%s), want %v", tt.name, rel, got, reason, want)
+			}
+			if !got && reason == "" {
+				t.Errorf("%s: %s is not built without a reason", tt.name, rel)
+			}
+		}
+	}
+}
+
+func TestFilterPrunes(t *testing.T) {
+	f, err := NewFilter(nil, []string{"vendor", "cache/", "modules/*/tests", "!vendor/sugar/**", "!modules/Keep/tests/fixture.php"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an excluded folder is still walked when a ! pattern could include something under it
+	tests := map[string]bool{
+		"modules":                false,
+		"cache":                  true,
+		"modules/x/cache":        true,
+		"vendor":                 false,
+		"vendor/sugar":           false,
+		"vendor/other":           true,
+		"modules/Accounts/tests": true,
+		"modules/Keep/tests":     false,
+	}
+	for rel, want := range tests {
+		if got, _ := f.prunes(filepath.FromSlash(rel)); got != want {
+			t.Errorf("prunes(%q) = %v, want %v", rel, got, want)
+		}
+	}
+}
+
+func TestNewFilterErrors(t *testing.T) {
+	if _, err := NewFilter([]string{"!a.php"}, nil); err == nil {
+		t.Error("a negated include pattern did not fail")
+	}
+	if _, err := NewFilter(nil, []string{"!a.php"}); err != nil {
+		t.Errorf("a negated exclude pattern failed: %v", err)
+	}
+}
+
+func TestRunFilterNegation(t *testing.T) {
+	src := writeTree(t, map[string]string{
+		"a.php":                   "<?php\n",
+		"vendor/other/a.php":      "<?php\n",
+		"vendor/sugar/a.php":      "<?php\n",
+		"vendor/sugar/deep/b.php": "<?php\n",
+		"modules/tests/a.php":     "<?php\n",
+		"modules/tests/keep.php":  "<?php\n",
+		".romeignore":             "# ignored\nmodules/tests/\n!modules/tests/keep.php\n",
+	})
+	f, err := NewFilter(nil, []string{"vendor", "!vendor/sugar/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions(t, src, "ent")
+	opts.Filter = f
+	opts.IgnoreFiles = true
+	if res := runBuild(t, opts); res.Failed != 0 {
+		t.Fatal(res.Errors)
+	}
+	want := map[string]bool{
+		"a.php":                   true,
+		"vendor/other/a.php":      false,
+		"vendor/sugar/a.php":      true,
+		"vendor/sugar/deep/b.php": true,
+		"modules/tests/a.php":     false,
+		"modules/tests/keep.php":  true,
+	}
+	for rel, built := range want {
+		if got := readBuilt(t, opts.Destination, rel) != "<missing>"; got != built {
+			t.Errorf("%s built is %v, want %v", rel, got, built)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package build
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// builtinFlavors are the flavors SugarCRM ships with, they can not be redefined
+var builtinFlavors = append([]string(nil), FlavorOrder...)
+
+// DefineFlavors adds custom flavors to Flavors and FlavorOrder. Each one is built on the flavors
+// it lists: it keeps its own tags and the tags of everything those flavors keep, so listing only
+// the flavor it is built on is enough. Custom flavors can be built on each other. Every call
+// replaces the custom flavors of the call before it, nothing changes when there is an error.
+func DefineFlavors(custom map[string][]string) error {
+	flavors := make(map[string][]string, len(builtinFlavors)+len(custom))
+	for _, name := range builtinFlavors {
+		flavors[name] = Flavors[name]
+	}
+	parents := make(map[string][]string, len(custom))
+	names := make([]string, 0, len(custom))
+	for name, list := range custom {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := flavors[name]; ok {
+			return fmt.Errorf("%s is a built in flavor, it can not be redefined", name)
+		}
+		if _, ok := parents[name]; ok || name == "" {
+			return fmt.Errorf("flavor %q is defined more than once", name)
+		}
+		parents[name] = make([]string, 0, len(list))
+		for _, parent := range list {
+			parents[name] = append(parents[name], strings.ToLower(strings.TrimSpace(parent)))
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// building names the flavors being resolved to find flavors built on each other
+	var building []string
+	var resolve func(name string) ([]string, error)
+	resolve = func(name string) ([]string, error) {
+		if keeps, ok := flavors[name]; ok {
+			return keeps, nil
+		}
+		for i, b := range building {
+			if b == name {
+				return nil, fmt.Errorf("flavors %s are built on each other", strings.Join(append(building[i:], name), " -> "))
+			}
+		}
+		building = append(building, name)
+		var keeps []string
+		for _, parent := range parents[name] {
+			if _, ok := parents[parent]; !ok && flavors[parent] == nil {
+				return nil, fmt.Errorf("flavor %s is built on %s, which is not a flavor", name, parent)
+			}
+			parentKeeps, err := resolve(parent)
+			if err != nil {
+				return nil, err
+			}
+			for _, keep := range parentKeeps {
+				if !contains(keeps, keep) {
+					keeps = append(keeps, keep)
+				}
+			}
+		}
+		keeps = append(keeps, name)
+		building = building[:len(building)-1]
+		flavors[name] = keeps
+		return keeps, nil
+	}
+	for _, name := range names {
+		if _, err := resolve(name); err != nil {
+			return err
+		}
+	}
+
+	Flavors = flavors
+	FlavorOrder = append(append([]string(nil), builtinFlavors...), names...)
+	return nil
+}
@@ -0,0 +1,93 @@
+package build
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// defineFlavors calls DefineFlavors and puts the built in flavors back when the test is done
+func defineFlavors(t *testing.T, custom map[string][]string) error {
+	t.Helper()
+	t.Cleanup(func() {
+		if err := DefineFlavors(nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return DefineFlavors(custom)
+}
+
+func TestDefineFlavorsHierarchy(t *testing.T) {
+	err := defineFlavors(t, map[string][]string{
+		" Base ": {"PRO"},
+		"mid":    {"base"},
+		"top":    {"mid", "corp"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"base": {"core", "pro", "base"},
+		"mid":  {"core", "pro", "base", "mid"},
+		"top":  {"core", "pro", "base", "mid", "corp", "top"},
+		"ent":  {"core", "pro", "corp", "ent"},
+	}
+	for name, keeps := range want {
+		if !reflect.DeepEqual(Flavors[name], keeps) {
+			t.Errorf("%s keeps %v, want %v", name, Flavors[name], keeps)
+		}
+	}
+	if got, want := FlavorOrder, []string{"core", "pro", "corp", "ent", "ult", "base", "mid", "top"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FlavorOrder %v, want %v", got, want)
+	}
+
+	got, err := buildString(t, "<?php\n// BEGIN SUGARCRM flav=base ONLY\n$b = 1;\n// END SUGARCRM flav=base ONLY\n// BEGIN SUGARCRM flav=ent ONLY\n$e = 1;\n// END SUGARCRM flav=ent ONLY\n", "top", "7.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<?php\n$b = 1;\n"; got != want {
+		t.Errorf("top built %q, want %q", got, want)
+	}
+
+	// a second call replaces the custom flavors of the first
+	if err := DefineFlavors(map[string][]string{"other": {"ult"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Flavors["top"]; ok {
+		t.Error("top is still a flavor")
+	}
+	if got, want := FlavorOrder, []string{"core", "pro", "corp", "ent", "ult", "other"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FlavorOrder %v, want %v", got, want)
+	}
+}
+
+func TestDefineFlavorsErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		custom map[string][]string
+		want   string
+	}{
+		{"cycle", map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}}, "are built on each other"},
+		{"itself", map[string][]string{"a": {"a"}}, "flavors a -> a are built on each other"},
+		{"built in", map[string][]string{"ENT": {"pro"}}, "ent is a built in flavor"},
+		{"unknown parent", map[string][]string{"a": {"pro"}, "b": {"a", "nope"}}, "flavor b is built on nope, which is not a flavor"},
+		{"twice", map[string][]string{"a": {"pro"}, " A": {"ent"}}, "defined more than once"},
+		{"empty name", map[string][]string{" ": {"pro"}}, "defined more than once"},
+	}
+	if err := defineFlavors(t, map[string][]string{"kept": {"pro"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		err := DefineFlavors(tt.custom)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error with %q", tt.name, err, tt.want)
+		}
+		// nothing changes when there is an error
+		if _, ok := Flavors["kept"]; !ok || len(FlavorOrder) != 6 {
+			t.Errorf("%s: the flavors changed to %v", tt.name, FlavorOrder)
+		}
+		if _, ok := Flavors["a"]; ok {
+			t.Errorf("%s: a was defined", tt.name)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ChangedSince asks git which files under the source folder changed since ref, including
+// changes that are not committed yet and new files that are not ignored. The paths are
+// relative to source. An error is returned when source is not in a git repository or git
+// does not know the ref.
+func ChangedSince(source string, ref string) (map[string]bool, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a folder", source)
+	}
+	if _, err := git(source, "rev-parse", "--verify", "--quiet", ref+"^{commit}"); err != nil {
+		return nil, fmt.Errorf("%s is not a git repository or does not have %s", source, ref)
+	}
+
+	changed := make(map[string]bool)
+	diff, err := git(source, "diff", "--name-only", "--relative", "-z", ref, "--")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := git(source, "ls-files", "--others", "--exclude-standard", "-z")
+	if err != nil {
+		return nil, err
+	}
+	for _, out := range [][]byte{diff, untracked} {
+		for _, name := range bytes.Split(out, []byte{0}) {
+			if len(name) > 0 {
+				changed[filepath.FromSlash(string(name))] = true
+			}
+		}
+	}
+	return changed, nil
+}
+
+// SourceCommit asks git which commit the source folder is at and if it has changes that are
+// not committed. An error is returned when source is not in a git repository.
+func SourceCommit(source string) (commit string, dirty bool, err error) {
+	out, err := git(source, "rev-parse", "HEAD")
+	if err != nil {
+		return "", false, fmt.Errorf("%s is not a git repository", source)
+	}
+	status, err := git(source, "status", "--porcelain", "--", ".")
+	if err != nil {
+		return "", false, err
+	}
+	return strings.TrimSpace(string(out)), len(status) > 0, nil
+}
+
+// git runs a git command in dir and returns what it printed
+func git(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %v %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
@@ -0,0 +1,60 @@
+package build
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// shouldGzip checks if a built file of the given size gets a precompressed .gz sibling
+func (opts Options) shouldGzip(dest string, size int64) bool {
+	if len(opts.GzipExtensions) == 0 || size < opts.GzipMinSize {
+		return false
+	}
+	ext := strings.ToLower(filepath.Ext(dest))
+	for _, want := range opts.GzipExtensions {
+		if ext == "."+strings.TrimPrefix(strings.ToLower(want), ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipFile writes a gzip compressed copy of dest next to it as dest.gz and returns its size,
+// see createAtomic for mode
+func gzipFile(dest string, mode os.FileMode) (int64, error) {
+	src, err := os.Open(dest)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	gzPath := dest + ".gz"
+	fw, err := createAtomic(gzPath, mode)
+	if err != nil {
+		return 0, &WriteError{Path: gzPath, Err: err}
+	}
+	defer fw.Abort()
+
+	var written int64
+	zw, err := gzip.NewWriterLevel(&countingWriter{w: fw, n: &written}, gzip.BestCompression)
+	if err != nil {
+		return 0, err
+	}
+	zw.Name = filepath.Base(dest)
+	if info, err := src.Stat(); err == nil {
+		zw.ModTime = info.ModTime()
+	}
+	if _, err := io.Copy(zw, src); err != nil {
+		return written, &WriteError{Path: gzPath, Err: err}
+	}
+	if err := zw.Close(); err != nil {
+		return written, &WriteError{Path: gzPath, Err: err}
+	}
+	if err := fw.Commit(); err != nil {
+		return written, &WriteError{Path: gzPath, Err: err}
+	}
+	return written, nil
+}
@@ -0,0 +1,91 @@
+package build
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// keepsBytes is whether a file that needs no processing is written exactly as its source,
+// nothing may change its line endings, byte order mark or content and it has to end up on the disk
+func (fo fileOptions) keepsBytes() bool {
+	return fo.sink == nil && !fo.stripBOM && (fo.lineEndings == "" || fo.lineEndings == LineEndingsKeep) && len(fo.processors) == 0
+}
+
+// canLink is whether a file that needs no processing can be hardlinked to its source. Nothing
+// may change its bytes and nothing may change its permissions, they are shared with the source.
+func (fo fileOptions) canLink() bool {
+	return fo.linkIdentical && fo.keepsBytes() && fo.fileMode == 0
+}
+
+// asIs is whether the build would write srcPath to destPath exactly as it is, processed is
+// whether it was looked at for build tags and variables at all
+func asIs(srcPath string, destPath string, fo fileOptions) (ok bool, processed bool) {
+	if fo.verbatim || !canProcessFile(destPath) {
+		return true, false
+	}
+	content, err := ioutil.ReadFile(srcPath)
+	if err != nil {
+		// building it reports the error
+		return false, true
+	}
+	if VarRegex.Match(content) || TagRegex.Match(content) || (fo.resolveIncludes && IncludeRegex.Match(content)) || bytes.Contains(content, []byte(versionVar)) {
+		return false, true
+	}
+	return true, true
+}
+
+// linkFile hardlinks destPath to srcPath when the build would write the file exactly as it
+// is, a file with build tags or variables returns false so it is built instead. So does a
+// source that can not be linked, like one on another filesystem than the destination.
+func linkFile(srcPath string, destPath string, fo fileOptions) (bool, error) {
+	ok, processed := asIs(srcPath, destPath, fo)
+	if !ok {
+		return false, nil
+	}
+	if err := fo.canceled(); err != nil {
+		return false, err
+	}
+
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return false, nil
+	}
+	if err := MakeDirs(path.Dir(destPath), fo.dirMode); err != nil {
+		return false, &DirError{Path: destPath, Dir: path.Dir(destPath), Err: err}
+	}
+	if err := linkAtomic(srcPath, destPath); err != nil {
+		return false, nil
+	}
+	return true, fo.copiedAsIs(srcPath, info.Size(), processed)
+}
+
+// copiedAsIs records a file that was linked or cloned without reading it through the
+// destination writer, like destWriter would have
+func (fo fileOptions) copiedAsIs(srcPath string, size int64, processed bool) error {
+	if fo.hash != nil {
+		if err := hashInto(fo.hash, srcPath); err != nil {
+			return &ReadError{Path: srcPath, Err: err}
+		}
+	}
+	if fo.written != nil {
+		*fo.written += size
+	}
+	if fo.noOp != nil {
+		*fo.noOp = processed
+	}
+	return nil
+}
+
+// hashInto feeds the file at path to w
+func hashInto(w io.Writer, path string) error {
+	fr, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fr.Close()
+	_, err = io.Copy(w, fr)
+	return err
+}
@@ -0,0 +1,75 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IncludeRegex matches the directive that pulls another file into a kept block when includes
+// are resolved, the path is relative to the file the directive is in
+var IncludeRegex = regexp.MustCompile("//[[:space:]]*INCLUDE[[:space:]]*SUGARCRM[[:space:]]+([^[:space:]]+)")
+
+// maxIncludeDepth is how deep includes can nest before the build gives up on the file
+const maxIncludeDepth = 16
+
+// includer builds the files pulled in by include directives, stack holds the absolute paths
+// of the files being built with the outermost one first
+type includer struct {
+	flavor  string
+	version string
+	stack   []string
+}
+
+// newIncluder starts resolving the includes of srcPath
+func newIncluder(srcPath string, flavor string, version string) *includer {
+	return &includer{flavor: flavor, version: version, stack: []string{absPath(srcPath)}}
+}
+
+// include builds the file target points to from the directive on line of srcPath, a file its
+// FILE tag excludes from the flavor includes nothing
+func (inc *includer) include(ctx context.Context, srcPath string, target string, line int) ([]byte, error) {
+	path := target
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(srcPath), target)
+	}
+	abs := absPath(path)
+	for i, seen := range inc.stack {
+		if seen == abs {
+			cycle := append(append([]string(nil), inc.stack[i:]...), abs)
+			return nil, &ParseError{Path: srcPath, Line: line, Msg: "include cycle: " + strings.Join(cycle, " -> ")}
+		}
+	}
+	if len(inc.stack) >= maxIncludeDepth {
+		return nil, &ParseError{Path: srcPath, Line: line, Msg: fmt.Sprintf("includes are nested more than %d deep", maxIncludeDepth)}
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, &ParseError{Path: srcPath, Line: line, Msg: "INCLUDE " + err.Error()}
+	}
+	nested := &includer{flavor: inc.flavor, version: inc.version, stack: append(append([]string(nil), inc.stack...), abs)}
+	built, err := buildContent(ctx, string(content), path, inc.flavor, inc.version, nil, nested)
+	if errors.Is(err, ErrFileExcluded) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(built) > 0 && built[len(built)-1] != '\n' {
+		built = append(built, '\n')
+	}
+	return built, nil
+}
+
+// absPath makes path absolute when it can
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,90 @@
+package build
+
+// DiffOp says what happened to a line in a diff
+type DiffOp byte
+
+const (
+	DiffKeep   DiffOp = ' '
+	DiffRemove DiffOp = '-'
+	DiffAdd    DiffOp = '+'
+)
+
+// DiffLine is a single line of a diff, LineA and LineB are the 1 based line numbers it has on
+// either side, zero for the side it is not on
+type DiffLine struct {
+	Op    DiffOp
+	LineA int
+	LineB int
+	Text  string
+}
+
+// DiffLines finds the shortest list of removes and adds that turns a into b, using Myers'
+// algorithm. Unchanged lines are included so the changes can be shown in context.
+func DiffLines(a []string, b []string) []DiffLine {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+
+	// walk the diagonals until the end of both is reached, keeping what every round reached so
+	// the path can be traced back
+search:
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				trace = append(trace, append([]int(nil), v...))
+				break search
+			}
+		}
+		trace = append(trace, append([]int(nil), v...))
+	}
+
+	// trace the path back from the end, collecting lines in reverse
+	var lines []DiffLine
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		var prevX, prevY int
+		if d > 0 {
+			v := trace[d-1]
+			k := x - y
+			prevK := k - 1
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				prevK = k + 1
+			}
+			prevX = v[offset+prevK]
+			prevY = prevX - prevK
+		}
+		for x > prevX && y > prevY {
+			x--
+			y--
+			lines = append(lines, DiffLine{Op: DiffKeep, LineA: x + 1, LineB: y + 1, Text: a[x]})
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			y--
+			lines = append(lines, DiffLine{Op: DiffAdd, LineB: y + 1, Text: b[y]})
+		} else {
+			x--
+			lines = append(lines, DiffLine{Op: DiffRemove, LineA: x + 1, Text: a[x]})
+		}
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines
+}
@@ -0,0 +1,110 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// LineEndings decides what line endings built text files are written with
+type LineEndings string
+
+const (
+	// LineEndingsKeep writes lines with whatever endings the build produced
+	LineEndingsKeep LineEndings = "keep"
+	// LineEndingsLF writes every line ending as \n
+	LineEndingsLF LineEndings = "lf"
+	// LineEndingsCRLF writes every line ending as \r\n
+	LineEndingsCRLF LineEndings = "crlf"
+)
+
+// LineEndingModes lists every valid line ending mode
+var LineEndingModes = []LineEndings{LineEndingsKeep, LineEndingsLF, LineEndingsCRLF}
+
+// binarySniffLen is how much of a file is looked at to decide if it is binary
+const binarySniffLen = 8000
+
+// ParseLineEndings validates the name of a line ending mode, an empty name means keep
+func ParseLineEndings(name string) (LineEndings, error) {
+	if name == "" {
+		return LineEndingsKeep, nil
+	}
+	for _, le := range LineEndingModes {
+		if string(le) == name {
+			return le, nil
+		}
+	}
+	return "", fmt.Errorf("unknown line endings %q, must be one of %v", name, LineEndingModes)
+}
+
+// isBinary guesses a file is binary the same way git does, by looking for a NUL byte near the start
+func isBinary(content []byte) bool {
+	if len(content) > binarySniffLen {
+		content = content[:binarySniffLen]
+	}
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+// normalizeLineEndings rewrites every \r\n and \n in content to le, a lone \r is left alone
+func normalizeLineEndings(content []byte, le LineEndings) []byte {
+	if le != LineEndingsLF && le != LineEndingsCRLF {
+		return content
+	}
+	content = bytes.Replace(content, []byte("\r\n"), []byte("\n"), -1)
+	if le == LineEndingsCRLF {
+		content = bytes.Replace(content, []byte("\n"), []byte("\r\n"), -1)
+	}
+	return content
+}
+
+// lineEndingWriter is the streaming version of normalizeLineEndings, Flush has to be called
+// once everything is written in case the last byte was a \r
+type lineEndingWriter struct {
+	w         io.Writer
+	eol       []byte
+	pendingCR bool
+}
+
+func newLineEndingWriter(w io.Writer, le LineEndings) *lineEndingWriter {
+	eol := []byte("\n")
+	if le == LineEndingsCRLF {
+		eol = []byte("\r\n")
+	}
+	return &lineEndingWriter{w: w, eol: eol}
+}
+
+func (l *lineEndingWriter) Write(p []byte) (int, error) {
+	var out bytes.Buffer
+	for _, b := range p {
+		if l.pendingCR {
+			l.pendingCR = false
+			if b == '\n' {
+				out.Write(l.eol)
+				continue
+			}
+			out.WriteByte('\r')
+		}
+		switch b {
+		case '\r':
+			l.pendingCR = true
+		case '\n':
+			out.Write(l.eol)
+		default:
+			out.WriteByte(b)
+		}
+	}
+	if _, err := l.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes out a \r that was held back to see if a \n followed it
+func (l *lineEndingWriter) Flush() error {
+	if !l.pendingCR {
+		return nil
+	}
+	l.pendingCR = false
+	_, err := l.w.Write([]byte("\r"))
+	return err
+}
@@ -0,0 +1,110 @@
+package build
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNormalizeLineEndings(t *testing.T) {
+	mixed := "a\r\nb\nc\rd\r\n"
+	tests := []struct {
+		le   LineEndings
+		want string
+	}{
+		{LineEndingsKeep, mixed},
+		{LineEndingsLF, "a\nb\nc\rd\n"},
+		{LineEndingsCRLF, "a\r\nb\r\nc\rd\r\n"},
+	}
+	for _, tt := range tests {
+		if got := string(normalizeLineEndings([]byte(mixed), tt.le)); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.le, got, tt.want)
+		}
+	}
+}
+
+func TestLineEndingWriter(t *testing.T) {
+	mixed := "a\r\nb\nc\rd\r\ne\r"
+	tests := []struct {
+		le   LineEndings
+		want string
+	}{
+		{LineEndingsLF, "a\nb\nc\rd\ne\r"},
+		{LineEndingsCRLF, "a\r\nb\r\nc\rd\r\ne\r"},
+	}
+	for _, tt := range tests {
+		// a byte at a time splits every \r\n over two writes
+		for _, chunk := range []int{1, 2, 3, len(mixed)} {
+			var out bytes.Buffer
+			w := newLineEndingWriter(&out, tt.le)
+			for i := 0; i < len(mixed); i += chunk {
+				end := i + chunk
+				if end > len(mixed) {
+					end = len(mixed)
+				}
+				if _, err := w.Write([]byte(mixed[i:end])); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("%s in chunks of %d: got %q, want %q", tt.le, chunk, out.String(), tt.want)
+			}
+		}
+	}
+}
+
+func TestParseLineEndings(t *testing.T) {
+	for name, want := range map[string]LineEndings{"": LineEndingsKeep, "keep": LineEndingsKeep, "lf": LineEndingsLF, "crlf": LineEndingsCRLF} {
+		if got, err := ParseLineEndings(name); err != nil || got != want {
+			t.Errorf("ParseLineEndings(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseLineEndings("cr"); err == nil {
+		t.Error("ParseLineEndings(\"cr\") did not fail")
+	}
+}
+
+func TestRunLineEndings(t *testing.T) {
+	binary := "\x00\x01\r\n\x02\n"
+	src := writeTree(t, map[string]string{
+		"mixed.php":  "<?php\r\n$a = 1;\n$b = 2;\r\n",
+		"tagged.php": "<?php\r\n// BEGIN SUGARCRM flav=ent ONLY\r\n$a = '@_SUGAR_FLAV';\r\n// END SUGARCRM flav=ent ONLY\r\n",
+		"notes.txt":  "one\r\ntwo\n",
+		"image.bin":  binary,
+	})
+	tests := []struct {
+		le   LineEndings
+		want map[string]string
+	}{
+		{LineEndingsLF, map[string]string{
+			"mixed.php":  "<?php\n$a = 1;\n$b = 2;\n",
+			"tagged.php": "<?php\n$a = 'ent';\n",
+			"notes.txt":  "one\ntwo\n",
+			"image.bin":  binary,
+		}},
+		{LineEndingsCRLF, map[string]string{
+			"mixed.php":  "<?php\r\n$a = 1;\r\n$b = 2;\r\n",
+			"tagged.php": "<?php\r\n$a = 'ent';\r\n",
+			"notes.txt":  "one\r\ntwo\r\n",
+			"image.bin":  binary,
+		}},
+	}
+	for _, tt := range tests {
+		// streaming every file takes the other code path
+		for _, threshold := range []int64{0, 1} {
+			opts := testOptions(t, src, "ent")
+			opts.LineEndings = tt.le
+			opts.StreamThreshold = threshold
+			if res := runBuild(t, opts); res.Failed != 0 {
+				t.Fatalf("%s: %v", tt.le, res.Errors)
+			}
+			for rel, want := range tt.want {
+				if got := readBuilt(t, opts.Destination, rel); got != want {
+					t.Errorf("%s, stream threshold %d: %s is %q, want %q", tt.le, threshold, rel, got, want)
+				}
+			}
+		}
+	}
+}
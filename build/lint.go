@@ -0,0 +1,43 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// lints checks if the file built to dest has to be linted, only PHP files the build changed are,
+// a file copied as it is was broken in the source already
+func (opts Options) lints(dest string, noOp bool) bool {
+	return opts.LintPHP != "" && !noOp && strings.EqualFold(filepath.Ext(dest), ".php")
+}
+
+// lintPHP runs php -l over the file built to dest, src is what it was built from
+func lintPHP(ctx context.Context, php string, src string, dest string) error {
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, php, "-n", "-d", "display_errors=stderr", "-l", dest)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*exec.ExitError); !ok {
+		return &LintError{Path: dest, Source: src, Err: err}
+	}
+	return &LintError{Path: dest, Source: src, Msg: lintMessage(out.String())}
+}
+
+// lintMessage picks the error out of what php -l printed, it reports the path of the built
+// file and the line in it
+func lintMessage(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.Contains(line, "error") && !strings.HasPrefix(line, "Errors parsing") {
+			return strings.TrimPrefix(line, "PHP ")
+		}
+	}
+	return strings.TrimSpace(output)
+}
@@ -0,0 +1,117 @@
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ManifestFile is the name of the manifest written into a destination after a build
+const ManifestFile = ".rome-manifest.json"
+
+// ManifestEntry is what the manifest knows about a single built file
+type ManifestEntry struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	// VersionStable is set when the file does not use the version variable, so building
+	// another version would give the same file
+	VersionStable bool `json:"version_stable,omitempty"`
+}
+
+// Manifest lists every file in a build by its path relative to the destination
+type Manifest struct {
+	Flavor  string                   `json:"flavor"`
+	Version string                   `json:"version"`
+	Files   map[string]ManifestEntry `json:"files"`
+}
+
+// NewManifest returns an empty manifest for a build of flavor and version
+func NewManifest(flavor string, version string) *Manifest {
+	return &Manifest{Flavor: flavor, Version: version, Files: make(map[string]ManifestEntry)}
+}
+
+// ReadManifest reads the manifest in dest, a destination without one returns nil without an error
+func ReadManifest(dest string) (*Manifest, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dest, ManifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(content, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WriteManifest saves m into dest
+func WriteManifest(dest string, m *Manifest) error {
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dest, ManifestFile), append(content, '\n'), 0644)
+}
+
+// VersionStable returns the paths of the files that do not change with the version
+func (m *Manifest) VersionStable() map[string]bool {
+	stable := make(map[string]bool)
+	for path, entry := range m.Files {
+		if entry.VersionStable {
+			stable[path] = true
+		}
+	}
+	return stable
+}
+
+// Digest hashes the sorted paths and hashes of every file, two builds with the same digest
+// produced the same tree
+func (m *Manifest) Digest() string {
+	paths := make([]string, 0, len(m.Files))
+	for path := range m.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	h := sha256.New()
+	for _, path := range paths {
+		fmt.Fprintf(h, "%s  %s\n", m.Files[path].SHA256, path)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ManifestDiff is how one manifest differs from an older one, the paths are sorted
+type ManifestDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// DiffManifests compares the files in current to the ones in previous
+func DiffManifests(previous *Manifest, current *Manifest) ManifestDiff {
+	var diff ManifestDiff
+	for path, entry := range current.Files {
+		old, ok := previous.Files[path]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, path)
+		case old.SHA256 != entry.SHA256 || old.Size != entry.Size:
+			diff.Changed = append(diff.Changed, path)
+		}
+	}
+	for path := range previous.Files {
+		if _, ok := current.Files[path]; !ok {
+			diff.Removed = append(diff.Removed, path)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
@@ -0,0 +1,128 @@
+package build
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// recordManifest runs opts and returns the manifest of what it built, like rome build writes
+func recordManifest(t *testing.T, opts Options) (*Manifest, *Result) {
+	t.Helper()
+	m := NewManifest(opts.Flavor, opts.Version)
+	var mu sync.Mutex
+	opts.Hash = true
+	opts.OnResult = func(fr FileResult) {
+		if fr.Err != nil || fr.Skipped || fr.Link {
+			return
+		}
+		rel, err := filepath.Rel(opts.Destination, fr.Destination)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		m.Files[filepath.ToSlash(rel)] = ManifestEntry{SHA256: fr.SHA256, Size: fr.Bytes, VersionStable: !fr.VersionSensitive}
+		mu.Unlock()
+	}
+	return m, runBuild(t, opts)
+}
+
+func TestVersionSensitivity(t *testing.T) {
+	src := writeTree(t, map[string]string{
+		"plain.php":     "<?php\n$a = 1;\n",
+		"flavor.php":    "<?php\n$f = '@_SUGAR_FLAV';\n",
+		"version.php":   "<?php\n$v = '@_SUGAR_VERSION';\n",
+		"kept.php":      "<?php\n// BEGIN SUGARCRM flav=pro ONLY\n$v = '@_SUGAR_VERSION';\n// END SUGARCRM flav=pro ONLY\n",
+		"dropped.php":   "<?php\n// BEGIN SUGARCRM flav=ult ONLY\n$v = '@_SUGAR_VERSION';\n// END SUGARCRM flav=ult ONLY\n",
+		"comment.js":    "// built for @_SUGAR_VERSION\nvar a = 1;\n",
+		"notes.txt":     "not processed @_SUGAR_VERSION\n",
+		"modules/x.tpl": "{* @_SUGAR_VERSION *}\n",
+	})
+	// the whole source is looked at, a version in a dropped block still counts so a file
+	// is never left stale
+	want := map[string]bool{
+		"plain.php":     false,
+		"flavor.php":    false,
+		"version.php":   true,
+		"kept.php":      true,
+		"dropped.php":   true,
+		"comment.js":    true,
+		"notes.txt":     false,
+		"modules/x.tpl": true,
+	}
+	for _, threshold := range []int64{0, 1} {
+		opts := testOptions(t, src, "ent")
+		opts.StreamThreshold = threshold
+		m, res := recordManifest(t, opts)
+		if res.Failed != 0 {
+			t.Fatalf("stream threshold %d: %v", threshold, res.Errors)
+		}
+		for rel, sensitive := range want {
+			entry, ok := m.Files[rel]
+			if !ok {
+				t.Errorf("stream threshold %d: %s is not in the manifest", threshold, rel)
+				continue
+			}
+			if entry.VersionStable == sensitive {
+				t.Errorf("stream threshold %d: %s version stable is %v, want %v", threshold, rel, entry.VersionStable, !sensitive)
+			}
+		}
+	}
+}
+
+func TestRunSinceVersion(t *testing.T) {
+	src := writeTree(t, map[string]string{
+		"stable.php":  "<?php\n$f = '@_SUGAR_FLAV';\n",
+		"version.php": "<?php\n$v = '@_SUGAR_VERSION';\n",
+		"new.php":     "<?php\n",
+	})
+	opts := testOptions(t, src, "ent")
+	first, _ := recordManifest(t, opts)
+	delete(first.Files, "new.php")
+
+	// a file that is left alone keeps whatever is in the destination
+	marker := "left alone\n"
+	if err := ioutil.WriteFile(filepath.Join(opts.Destination, "stable.php"), []byte(marker), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteManifest(opts.Destination, first); err != nil {
+		t.Fatal(err)
+	}
+	previous, err := ReadManifest(opts.Destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Version = "7.1"
+	opts.VersionStable = previous.VersionStable()
+	res := runBuild(t, opts)
+	if res.Failed != 0 || res.Skipped != 1 {
+		t.Fatalf("failed %d and skipped %d, want 0 and 1", res.Failed, res.Skipped)
+	}
+	if got := readBuilt(t, opts.Destination, "stable.php"); got != marker {
+		t.Errorf("stable.php was rebuilt: %q", got)
+	}
+	if got := readBuilt(t, opts.Destination, "version.php"); got != "<?php\n$v = '7.1';\n" {
+		t.Errorf("version.php is %q", got)
+	}
+	// a file the last build did not have is never assumed to be stable
+	if got := readBuilt(t, opts.Destination, "new.php"); got != "<?php\n" {
+		t.Errorf("new.php is %q", got)
+	}
+}
+
+func TestManifestVersionStable(t *testing.T) {
+	m := NewManifest("ent", "7.0")
+	m.Files["a.php"] = ManifestEntry{VersionStable: true}
+	m.Files["b.php"] = ManifestEntry{}
+	m.Files["c/d.js"] = ManifestEntry{VersionStable: true}
+	stable := m.VersionStable()
+	if len(stable) != 2 || !stable["a.php"] || !stable["c/d.js"] {
+		t.Errorf("VersionStable() = %v, want a.php and c/d.js", stable)
+	}
+	if got := NewManifest("ent", "7.0").VersionStable(); len(got) != 0 {
+		t.Errorf("an empty manifest has stable files %v", got)
+	}
+}
@@ -0,0 +1,44 @@
+package build
+
+import "testing"
+
+func TestRunMarkupTags(t *testing.T) {
+	src := writeTree(t, map[string]string{
+		"modules/view.tpl": "<div>\n{*BEGIN SUGARCRM flav=ent*}\n<span>{$APP.LBL_ENT}</span>\n{*END SUGARCRM flav=ent*}\n" +
+			"{* BEGIN SUGARCRM flav=pro ONLY *}\n<span>@_SUGAR_FLAV</span>\n{* END SUGARCRM flav=pro ONLY *}\n</div>\n",
+		"index.html": "<html>\n<!-- BEGIN SUGARCRM flav=ent ONLY -->\n<p>ent</p>\n<!-- END SUGARCRM flav=ent ONLY -->\n<p>@_SUGAR_VERSION</p>\n</html>\n",
+		"config.xml": "<config>\n<!-- BEGIN SUGARCRM flav!=ent ONLY -->\n<community/>\n<!-- END SUGARCRM flav!=ent ONLY -->\n<version>@_SUGAR_VERSION</version>\n</config>\n",
+		"ent.tpl":    "{*FILE SUGARCRM flav=ent*}\n<p>ent only</p>\n",
+	})
+	tests := []struct {
+		flavor string
+		want   map[string]string
+	}{
+		{"ent", map[string]string{
+			"modules/view.tpl": "<div>\n<span>{$APP.LBL_ENT}</span>\n<span>ent</span>\n</div>\n",
+			"index.html":       "<html>\n<p>ent</p>\n<p>7.0</p>\n</html>\n",
+			"config.xml":       "<config>\n<version>7.0</version>\n</config>\n",
+			"ent.tpl":          "<p>ent only</p>\n",
+		}},
+		{"pro", map[string]string{
+			"modules/view.tpl": "<div>\n<span>pro</span>\n</div>\n",
+			"index.html":       "<html>\n<p>7.0</p>\n</html>\n",
+			"config.xml":       "<config>\n<community/>\n<version>7.0</version>\n</config>\n",
+			"ent.tpl":          "<missing>",
+		}},
+	}
+	for _, tt := range tests {
+		for _, threshold := range []int64{0, 1} {
+			opts := testOptions(t, src, tt.flavor)
+			opts.StreamThreshold = threshold
+			if res := runBuild(t, opts); res.Failed != 0 {
+				t.Fatalf("%s, stream threshold %d: %v", tt.flavor, threshold, res.Errors)
+			}
+			for rel, want := range tt.want {
+				if got := readBuilt(t, opts.Destination, rel); got != want {
+					t.Errorf("%s, stream threshold %d: %s is %q, want %q", tt.flavor, threshold, rel, got, want)
+				}
+			}
+		}
+	}
+}
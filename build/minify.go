@@ -0,0 +1,385 @@
+package build
+
+import (
+	"bytes"
+	"errors"
+	"path"
+	"strings"
+)
+
+// Minifier returns the processor that minifies .js and .css files, files that are already
+// minified, ending in .min.js or .min.css, are left alone. JavaScript gets the conservative
+// treatment of JSMin: comments go and whitespace is cut down without renaming anything, a line
+// break is kept wherever it could end a statement. CSS loses its comments, except /*! ones,
+// and the whitespace that does not change what it means.
+func Minifier() Processor {
+	return minifier{}
+}
+
+type minifier struct{}
+
+func (minifier) Name() string {
+	return "minify"
+}
+
+func (minifier) Match(rel string) bool {
+	name := strings.ToLower(path.Base(rel))
+	switch path.Ext(name) {
+	case ".js", ".css":
+		return !strings.HasSuffix(strings.TrimSuffix(name, path.Ext(name)), ".min")
+	}
+	return false
+}
+
+func (minifier) Process(rel string, content []byte) ([]byte, error) {
+	if strings.EqualFold(path.Ext(rel), ".css") {
+		return minifyCSS(content)
+	}
+	return minifyJS(content)
+}
+
+// jsMinifier is JSMin: a holds the character being looked at, b the one after it
+type jsMinifier struct {
+	in   []byte
+	pos  int
+	out  bytes.Buffer
+	a, b int
+	// peeked is the character after b once it was looked at, -1 when it was not
+	peeked int
+}
+
+// jsEOF is what get returns at the end of the input
+const jsEOF = -1
+
+var (
+	errUnterminatedString  = errors.New("unterminated string literal")
+	errUnterminatedComment = errors.New("unterminated comment")
+	errUnterminatedRegexp  = errors.New("unterminated regular expression")
+)
+
+func minifyJS(content []byte) ([]byte, error) {
+	content = bytes.TrimPrefix(content, utf8BOM)
+	m := &jsMinifier{in: content, peeked: jsEOF - 1}
+	if err := m.run(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimLeft(m.out.Bytes(), "\n"), nil
+}
+
+// isAlnum is whether c can be part of an identifier, a number or a non ASCII character
+func isAlnum(c int) bool {
+	return (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || (c >= 'A' && c <= 'Z') ||
+		c == '_' || c == '$' || c == '\\' || c > 126
+}
+
+// get returns the next character, carriage returns become line breaks and other control
+// characters spaces
+func (m *jsMinifier) get() int {
+	c := m.peek()
+	m.peeked = jsEOF - 1
+	if c != jsEOF {
+		m.pos++
+	}
+	if c >= ' ' || c == '\n' || c == jsEOF {
+		return c
+	}
+	if c == '\r' {
+		return '\n'
+	}
+	return ' '
+}
+
+// nextSolid is the first character after b that is not a space or a tab, it does not move on
+func (m *jsMinifier) nextSolid() int {
+	for i := m.pos; i < len(m.in); i++ {
+		if m.in[i] != ' ' && m.in[i] != '\t' {
+			return int(m.in[i])
+		}
+	}
+	return jsEOF
+}
+
+func (m *jsMinifier) peek() int {
+	if m.peeked == jsEOF-1 {
+		m.peeked = jsEOF
+		if m.pos < len(m.in) {
+			m.peeked = int(m.in[m.pos])
+		}
+	}
+	return m.peeked
+}
+
+// next returns the next character with comments turned into whitespace
+func (m *jsMinifier) next() (int, error) {
+	c := m.get()
+	if c != '/' {
+		return c, nil
+	}
+	switch m.peek() {
+	case '/':
+		for {
+			c = m.get()
+			if c == '\n' || c == jsEOF {
+				return c, nil
+			}
+		}
+	case '*':
+		m.get()
+		for {
+			switch m.get() {
+			case '*':
+				if m.peek() == '/' {
+					m.get()
+					return ' ', nil
+				}
+			case jsEOF:
+				return 0, errUnterminatedComment
+			}
+		}
+	}
+	return c, nil
+}
+
+// action writes a and moves on: 1 writes a, 2 drops it and 3 drops b. Strings and regular
+// expressions are copied as they are.
+func (m *jsMinifier) action(d int) error {
+	var err error
+	if d <= 1 {
+		m.out.WriteByte(byte(m.a))
+	}
+	if d <= 2 {
+		m.a = m.b
+		if m.a == '\'' || m.a == '"' || m.a == '`' {
+			for {
+				m.out.WriteByte(byte(m.a))
+				m.a = m.get()
+				if m.a == m.b {
+					break
+				}
+				if m.a == jsEOF || (m.a == '\n' && m.b != '`') {
+					return errUnterminatedString
+				}
+				if m.a == '\\' {
+					m.out.WriteByte(byte(m.a))
+					m.a = m.get()
+					if m.a == jsEOF {
+						return errUnterminatedString
+					}
+				}
+			}
+		}
+	}
+	if m.b, err = m.next(); err != nil {
+		return err
+	}
+	if m.b == '/' && m.regexpCanStart() {
+		return m.regexp()
+	}
+	return nil
+}
+
+// jsRegexpKeywords are the words a regular expression can come right after, after any other
+// word a slash divides
+var jsRegexpKeywords = map[string]bool{
+	"await": true, "case": true, "delete": true, "do": true, "else": true, "in": true,
+	"instanceof": true, "new": true, "return": true, "throw": true, "typeof": true,
+	"void": true, "yield": true,
+}
+
+// regexpCanStart is whether a slash in b after a starts a regular expression. That is after
+// an operator or punctuation that can not end an expression, or after a keyword. The keyword
+// is either written out already, when a is the space after it, or a is its last letter.
+// ++ and -- end an expression and a keyword after a . is just the name of a property.
+func (m *jsMinifier) regexpCanStart() bool {
+	out := m.out.Bytes()
+	if (m.a == '+' || m.a == '-') && len(out) > 0 && int(out[len(out)-1]) == m.a {
+		return false
+	}
+	if strings.ContainsRune("(,=:[!&|?+-~*{};\n", rune(m.a)) {
+		return true
+	}
+	if m.a != ' ' && !isAlnum(m.a) {
+		return false
+	}
+	start := len(out)
+	for start > 0 && isAlnum(int(out[start-1])) {
+		start--
+	}
+	if start > 0 && out[start-1] == '.' {
+		return false
+	}
+	word := string(out[start:])
+	if m.a != ' ' {
+		word += string([]byte{byte(m.a)})
+	}
+	return jsRegexpKeywords[word]
+}
+
+// regexp copies a regular expression literal, b is its opening slash
+func (m *jsMinifier) regexp() error {
+	m.out.WriteByte(byte(m.a))
+	if m.a == '/' || m.a == '*' {
+		m.out.WriteByte(' ')
+	}
+	m.out.WriteByte(byte(m.b))
+	for {
+		m.a = m.get()
+		switch {
+		case m.a == '[':
+			// a slash in a character class does not end the expression
+			for {
+				m.out.WriteByte(byte(m.a))
+				m.a = m.get()
+				if m.a == ']' {
+					break
+				}
+				if m.a == '\\' {
+					m.out.WriteByte(byte(m.a))
+					m.a = m.get()
+				}
+				if m.a == jsEOF || m.a == '\n' {
+					return errUnterminatedRegexp
+				}
+			}
+		case m.a == '/':
+			// the closing slash is left in a to be written like any other character
+			var err error
+			m.b, err = m.next()
+			return err
+		case m.a == '\\':
+			m.out.WriteByte(byte(m.a))
+			m.a = m.get()
+		}
+		if m.a == jsEOF || m.a == '\n' {
+			return errUnterminatedRegexp
+		}
+		m.out.WriteByte(byte(m.a))
+	}
+}
+
+func (m *jsMinifier) run() error {
+	m.a = '\n'
+	if err := m.action(3); err != nil {
+		return err
+	}
+	for m.a != jsEOF {
+		var d int
+		switch m.a {
+		case ' ':
+			d = 2
+			// a - -b and a + +b must not become a--b or a++b
+			if isAlnum(m.b) || ((m.b == '+' || m.b == '-') && lastByte(m.out.Bytes()) == byte(m.b)) {
+				d = 1
+			}
+		case '\n':
+			switch m.b {
+			case '{', '[', '(', '+', '-', '!', '~':
+				d = 1
+			case ' ':
+				d = 3
+			default:
+				d = 2
+				if isAlnum(m.b) {
+					d = 1
+				}
+			}
+		default:
+			switch m.b {
+			case ' ':
+				d = 3
+				if isAlnum(m.a) || ((m.a == '+' || m.a == '-') && m.nextSolid() == m.a) {
+					d = 1
+				}
+			case '\n':
+				switch m.a {
+				case '}', ']', ')', '+', '-', '"', '\'', '`':
+					d = 1
+				default:
+					d = 3
+					if isAlnum(m.a) {
+						d = 1
+					}
+				}
+			default:
+				d = 1
+			}
+		}
+		if err := m.action(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// minifyCSS drops comments, except /*! ones, and whitespace that does not change what the CSS
+// means. Strings are copied as they are.
+func minifyCSS(content []byte) ([]byte, error) {
+	content = bytes.TrimPrefix(content, utf8BOM)
+	var out bytes.Buffer
+	// space is set when whitespace was skipped and may have to be written as a single space
+	space := false
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == '/' && i+1 < len(content) && content[i+1] == '*':
+			end := bytes.Index(content[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, errUnterminatedComment
+			}
+			comment := content[i : i+2+end+2]
+			if bytes.HasPrefix(comment, []byte("/*!")) {
+				out.Write(comment)
+			} else {
+				space = true
+			}
+			i += len(comment) - 1
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			space = true
+			continue
+		case c == '"' || c == '\'':
+			start := i
+			for i++; i < len(content) && content[i] != c; i++ {
+				if content[i] == '\\' {
+					i++
+				}
+				if i < len(content) && content[i] == '\n' {
+					return nil, errUnterminatedString
+				}
+			}
+			if i >= len(content) {
+				return nil, errUnterminatedString
+			}
+			if space && !cssTight(lastByte(out.Bytes())) && lastByte(out.Bytes()) != ':' {
+				out.WriteByte(' ')
+			}
+			space = false
+			out.Write(content[start : i+1])
+			continue
+		}
+		if c == '}' && lastByte(out.Bytes()) == ';' {
+			// the last declaration of a block does not need its semicolon
+			out.Truncate(out.Len() - 1)
+		}
+		// the space before a colon can start a pseudo class, the one after it never matters
+		if space && out.Len() > 0 && !cssTight(lastByte(out.Bytes())) && lastByte(out.Bytes()) != ':' && !cssTight(c) {
+			out.WriteByte(' ')
+		}
+		space = false
+		out.WriteByte(c)
+	}
+	return out.Bytes(), nil
+}
+
+// cssTight is whether whitespace next to c never matters
+func cssTight(c byte) bool {
+	return c == '{' || c == '}' || c == ';' || c == ','
+}
+
+// lastByte is the last byte of b, zero when it is empty
+func lastByte(b []byte) byte {
+	if len(b) == 0 {
+		return 0
+	}
+	return b[len(b)-1]
+}
@@ -0,0 +1,114 @@
+package build
+
+import "testing"
+
+func TestMinifyJS(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"regexp after return", "function f(s){ return /\\/\\//.test(s); // c\n}", "function f(s){return /\\/\\//.test(s);}"},
+		{"regexp right after return", "return/a\\/b/g.test(s)", "return/a\\/b/g.test(s)"},
+		{"regexp after typeof", "x = typeof /re/", "x=typeof /re/"},
+		{"regexp after case", "switch (x) { case /a/: break }", "switch(x){case /a/:break}"},
+		{"regexp after assignment", "var re = /[/]+\\//g;", "var re=/[/]+\\//g;"},
+		{"regexp after paren", "s.replace(/ +/g, ' ')", "s.replace(/ +/g,' ')"},
+		{"regexp after newline", "x\n/ab/.test(y)", "x\n/ab/.test(y)"},
+		{"division", "var x = a / b / c;", "var x=a/b/c;"},
+		{"division after paren", "var x = (a + b) / 2;", "var x=(a+b)/2;"},
+		{"division by a word", "var ratio = total / count", "var ratio=total/count"},
+		{"division after a word ending like a keyword", "var x = rein / 2", "var x=rein/2"},
+		{"division after ++", "x = i++ / 2;", "x=i++/2;"},
+		{"division after --", "x = a-- / b / c;", "x=a--/b/c;"},
+		{"division after a property named like a keyword", "x = obj.return / 2 / y;", "x=obj.return/2/y;"},
+		{"division after a property named in", "x = a.in/b/c", "x=a.in/b/c"},
+		{"regexp after plus", "x = a + /re/.source", "x=a+/re/.source"},
+		{"line comment", "a = 1; // one\nb = 2;", "a=1;b=2;"},
+		{"block comment", "a /* one */ = 1;", "a=1;"},
+		{"comment markers in strings", "s = \"// not a comment\" + '/* nor this */'", "s=\"// not a comment\"+'/* nor this */'"},
+		{"escaped quote", "s = 'it\\'s // fine'", "s='it\\'s // fine'"},
+		{"template literal", "s = `a\n  // b`", "s=`a\n  // b`"},
+		{"plus and minus", "x = a - -b + +c; i++; j--;", "x=a- -b+ +c;i++;j--;"},
+		{"plus and minus around a comment", "x = a - /* c */ -b + c", "x=a- -b+c"},
+		{"kept line break", "a = 1\nb = 2", "a=1\nb=2"},
+		{"byte order mark", "\ufeffvar a = 1;", "var a=1;"},
+	}
+	for _, tt := range tests {
+		got, err := minifyJS([]byte(tt.in))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: minifyJS(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMinifyJSErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		want error
+	}{
+		{"s = 'open", errUnterminatedString},
+		{"s = \"line\nbreak\"", errUnterminatedString},
+		{"a = 1; /* open", errUnterminatedComment},
+		{"re = /open", errUnterminatedRegexp},
+		{"return /open\n", errUnterminatedRegexp},
+		{"re = /[/", errUnterminatedRegexp},
+	}
+	for _, tt := range tests {
+		if _, err := minifyJS([]byte(tt.in)); err != tt.want {
+			t.Errorf("minifyJS(%q) = %v, want %v", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestMinifyCSS(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		// the space before a colon could start a pseudo class, so it is kept
+		{"whitespace", "a {\n  color : red ;\n  margin: 0 auto;\n}\n", "a{color :red;margin:0 auto}"},
+		{"selectors", "a:hover,\n b > c  .d { x: 1 }", "a:hover,b > c .d{x:1}"},
+		{"comments", "/* gone */a{/* gone */x:1}", "a{x:1}"},
+		{"kept comment", "/*! license */\na{x:1}", "/*! license */ a{x:1}"},
+		{"strings", "a{content: \"  /* kept */  \"; font: 'a  b'}", "a{content:\"  /* kept */  \";font:'a  b'}"},
+		{"space before a string", "a{font: 12px \"x\"}", "a{font:12px \"x\"}"},
+	}
+	for _, tt := range tests {
+		got, err := minifyCSS([]byte(tt.in))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: minifyCSS(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+	for _, in := range []string{"a{x:1} /* open", "a{content:'open}"} {
+		if _, err := minifyCSS([]byte(in)); err == nil {
+			t.Errorf("minifyCSS(%q) did not fail", in)
+		}
+	}
+}
+
+func TestMinifierMatch(t *testing.T) {
+	tests := map[string]bool{
+		"include/app.js":      true,
+		"styles/site.CSS":     true,
+		"include/app.min.js":  false,
+		"styles/site.min.css": false,
+		"modules/x.php":       false,
+		"minified.js":         true,
+	}
+	m := Minifier()
+	for rel, want := range tests {
+		if got := m.Match(rel); got != want {
+			t.Errorf("Match(%q) = %v, want %v", rel, got, want)
+		}
+	}
+}
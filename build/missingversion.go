@@ -0,0 +1,31 @@
+package build
+
+import "fmt"
+
+// MissingVersionPolicy decides what happens to the version placeholder when no version is given
+type MissingVersionPolicy string
+
+const (
+	// MissingVersionError fails every file that uses the placeholder, it is the default
+	MissingVersionError MissingVersionPolicy = "error"
+	// MissingVersionLeave leaves the placeholder in the built file as it is
+	MissingVersionLeave MissingVersionPolicy = "leave"
+	// MissingVersionEmpty replaces the placeholder with nothing
+	MissingVersionEmpty MissingVersionPolicy = "empty"
+)
+
+// MissingVersionPolicies lists every valid missing version policy
+var MissingVersionPolicies = []MissingVersionPolicy{MissingVersionLeave, MissingVersionEmpty, MissingVersionError}
+
+// ParseMissingVersionPolicy validates the name of a missing version policy, an empty name means error
+func ParseMissingVersionPolicy(name string) (MissingVersionPolicy, error) {
+	if name == "" {
+		return MissingVersionError, nil
+	}
+	for _, p := range MissingVersionPolicies {
+		if string(p) == name {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown missing version policy %q, must be one of %v", name, MissingVersionPolicies)
+}
@@ -0,0 +1,72 @@
+package build
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseMissingVersionPolicy(t *testing.T) {
+	for name, want := range map[string]MissingVersionPolicy{"": MissingVersionError, "error": MissingVersionError, "leave": MissingVersionLeave, "empty": MissingVersionEmpty} {
+		if got, err := ParseMissingVersionPolicy(name); err != nil || got != want {
+			t.Errorf("ParseMissingVersionPolicy(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseMissingVersionPolicy("ignore"); err == nil {
+		t.Error("ParseMissingVersionPolicy(\"ignore\") did not fail")
+	}
+}
+
+func TestRunOnMissingVersion(t *testing.T) {
+	src := writeTree(t, map[string]string{
+		"version.php": "<?php\n$v = '@_SUGAR_VERSION';\n",
+		"flavor.php":  "<?php\n$f = '@_SUGAR_FLAV';\n",
+	})
+	tests := []struct {
+		policy  MissingVersionPolicy
+		version string
+		// want is what version.php is built to, empty when it fails
+		want string
+	}{
+		{"", "", ""},
+		{MissingVersionError, "", ""},
+		{MissingVersionLeave, "", "<?php\n$v = '@_SUGAR_VERSION';\n"},
+		{MissingVersionEmpty, "", "<?php\n$v = '';\n"},
+		// with a version the policy does not matter
+		{MissingVersionError, "7.1", "<?php\n$v = '7.1';\n"},
+		{MissingVersionLeave, "7.1", "<?php\n$v = '7.1';\n"},
+	}
+	for _, tt := range tests {
+		for _, threshold := range []int64{0, 1} {
+			opts := testOptions(t, src, "ent")
+			opts.Version = tt.version
+			opts.OnMissingVersion = tt.policy
+			opts.StreamThreshold = threshold
+			res := runBuild(t, opts)
+
+			if tt.want == "" {
+				if res.Failed != 1 || !errors.Is(res.Failures[0].Err, ErrMissingVersion) {
+					t.Errorf("policy %q, stream threshold %d: failed %d with %v, want version.php to fail", tt.policy, threshold, res.Failed, res.Errors)
+				}
+				if got := readBuilt(t, opts.Destination, "version.php"); got != "<missing>" {
+					t.Errorf("policy %q, stream threshold %d: version.php was built to %q", tt.policy, threshold, got)
+				}
+			} else if res.Failed != 0 {
+				t.Errorf("policy %q, version %q, stream threshold %d: %v", tt.policy, tt.version, threshold, res.Errors)
+			} else if got := readBuilt(t, opts.Destination, "version.php"); got != tt.want {
+				t.Errorf("policy %q, version %q, stream threshold %d: version.php is %q, want %q", tt.policy, tt.version, threshold, got, tt.want)
+			}
+			if got := readBuilt(t, opts.Destination, "flavor.php"); got != "<?php\n$f = 'ent';\n" {
+				t.Errorf("policy %q, stream threshold %d: flavor.php is %q", tt.policy, threshold, got)
+			}
+
+			// every policy reports the files that needed a version
+			wantMissing := 1
+			if tt.version != "" {
+				wantMissing = 0
+			}
+			if len(res.MissingVersion) != wantMissing || (wantMissing == 1 && res.MissingVersion[0] != "version.php") {
+				t.Errorf("policy %q, version %q, stream threshold %d: missing version is %v", tt.policy, tt.version, threshold, res.MissingVersion)
+			}
+		}
+	}
+}
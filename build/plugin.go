@@ -0,0 +1,27 @@
+package build
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// LoadPlugin opens the Go plugin at path and returns the Processor made by its NewProcessor
+// function. The plugin has to be built with go build -buildmode=plugin against the same version
+// of this package as rome, on a platform Go supports plugins on.
+//
+//	func NewProcessor() build.Processor
+func LoadPlugin(path string) (Processor, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup("NewProcessor")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	newProcessor, ok := sym.(func() Processor)
+	if !ok {
+		return nil, fmt.Errorf("%s: NewProcessor has to be a func() build.Processor, not a %T", path, sym)
+	}
+	return newProcessor(), nil
+}
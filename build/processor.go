@@ -0,0 +1,231 @@
+package build
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// Processor transforms the content of the files it matches, like stamping a header on them,
+// after the build tags, variables, line endings and byte order mark are dealt with. What it
+// returns is written to the destination as it is. A Processor is used by every file worker
+// at once, so Process has to be safe to call concurrently.
+type Processor interface {
+	// Name says which processor it is in errors
+	Name() string
+	// Match says if the file at rel, relative to its source, is handed to Process
+	Match(rel string) bool
+	Process(rel string, content []byte) ([]byte, error)
+}
+
+// CloseProcessors closes the processors that hold on to something, like the process of an
+// ExecProcessor, the first error is returned after every one of them is closed
+func CloseProcessors(processors []Processor) error {
+	var first error
+	for _, p := range processors {
+		if c, ok := p.(io.Closer); ok {
+			if err := c.Close(); err != nil && first == nil {
+				first = fmt.Errorf("processor %s: %w", p.Name(), err)
+			}
+		}
+	}
+	return first
+}
+
+// processorsFor returns the processors that match the file at rel, in the order of Options.Processors
+func (opts Options) processorsFor(rel string) []Processor {
+	var matched []Processor
+	for _, p := range opts.Processors {
+		if p.Match(filepath.ToSlash(rel)) {
+			matched = append(matched, p)
+		}
+	}
+	return matched
+}
+
+// process runs the processors of the file over what the build made of srcPath, one after the other
+func (fo fileOptions) process(srcPath string, content []byte) ([]byte, error) {
+	for _, p := range fo.processors {
+		out, err := p.Process(fo.rel, content)
+		if err != nil {
+			return nil, &ProcessError{Path: srcPath, Processor: p.Name(), Err: err}
+		}
+		content = out
+	}
+	return content, nil
+}
+
+// globMatcher matches relative paths against patterns written like those of Filter
+type globMatcher []filterPattern
+
+func newGlobMatcher(patterns []string) (globMatcher, error) {
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("at least one pattern is needed to match files")
+	}
+	compiled, err := compilePatterns(patterns, false)
+	return globMatcher(compiled), err
+}
+
+func (g globMatcher) match(rel string) bool {
+	for _, p := range g {
+		if p.match(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Globbed narrows p down to the files one of patterns matches as well, patterns are written
+// like those of Filter
+func Globbed(p Processor, patterns []string) (Processor, error) {
+	g, err := newGlobMatcher(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("processor %s: %w", p.Name(), err)
+	}
+	return &globbed{Processor: p, globs: g}, nil
+}
+
+type globbed struct {
+	Processor
+	globs globMatcher
+}
+
+func (g *globbed) Match(rel string) bool {
+	return g.globs.match(rel) && g.Processor.Match(rel)
+}
+
+// Close closes the processor it narrows down
+func (g *globbed) Close() error {
+	if c, ok := g.Processor.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// ExecProcessor hands the files it matches to an external program. The program is started
+// the first time a file matches and is kept running, every file is a single line of JSON
+// written to its stdin:
+//
+//	{"path": "modules/Accounts/Account.php", "content": "<base64>"}
+//
+// and it answers each one with a single line of JSON on its stdout, with the new content or
+// an error that fails the file:
+//
+//	{"content": "<base64>"}
+//	{"error": "why it failed"}
+//
+// Files are handed over one at a time. What the program writes to stderr goes to ours.
+type ExecProcessor struct {
+	name     string
+	globs    globMatcher
+	argv     []string
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	stdout   *bufio.Reader
+	startErr error
+}
+
+// execRequest and execResponse are the lines of JSON an ExecProcessor writes and reads
+type execRequest struct {
+	Path    string `json:"path"`
+	Content []byte `json:"content"`
+}
+type execResponse struct {
+	Content []byte `json:"content"`
+	Error   string `json:"error,omitempty"`
+}
+
+// NewExecProcessor returns a processor called name that runs argv for the files one of
+// patterns matches, nothing is started until then
+func NewExecProcessor(name string, patterns []string, argv []string) (*ExecProcessor, error) {
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("processor %s has no command to run", name)
+	}
+	g, err := newGlobMatcher(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("processor %s: %w", name, err)
+	}
+	return &ExecProcessor{name: name, globs: g, argv: argv}, nil
+}
+
+func (e *ExecProcessor) Name() string {
+	return e.name
+}
+
+func (e *ExecProcessor) Match(rel string) bool {
+	return e.globs.match(rel)
+}
+
+func (e *ExecProcessor) Process(rel string, content []byte) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.start(); err != nil {
+		return nil, err
+	}
+	line, err := json.Marshal(execRequest{Path: filepath.ToSlash(rel), Content: content})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := e.stdin.Write(append(line, '\n')); err != nil {
+		return nil, fmt.Errorf("writing to %s: %w", e.argv[0], err)
+	}
+	answer, err := e.stdout.ReadBytes('\n')
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = fmt.Errorf("%s stopped before answering", e.argv[0])
+		}
+		return nil, err
+	}
+	var resp execResponse
+	if err := json.Unmarshal(answer, &resp); err != nil {
+		return nil, fmt.Errorf("%s answered with something that is not JSON: %w", e.argv[0], err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return resp.Content, nil
+}
+
+// start runs the program the first time it is needed, a program that could not be started
+// fails every file after it as well
+func (e *ExecProcessor) start() error {
+	if e.cmd != nil || e.startErr != nil {
+		return e.startErr
+	}
+	cmd := exec.Command(e.argv[0], e.argv[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err == nil {
+		var stdout io.ReadCloser
+		if stdout, err = cmd.StdoutPipe(); err == nil {
+			e.stdout = bufio.NewReader(stdout)
+			err = cmd.Start()
+		}
+	}
+	if err != nil {
+		e.startErr = fmt.Errorf("starting %s: %w", e.argv[0], err)
+		return e.startErr
+	}
+	e.cmd, e.stdin = cmd, stdin
+	return nil
+}
+
+// Close tells the program there are no more files by closing its stdin and waits for it to exit
+func (e *ExecProcessor) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.cmd == nil {
+		return nil
+	}
+	e.stdin.Close()
+	err := e.cmd.Wait()
+	e.cmd = nil
+	return err
+}
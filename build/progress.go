@@ -0,0 +1,105 @@
+package build
+
+import (
+	"sync"
+	"time"
+)
+
+// Progress lets another goroutine look at a build while it runs, see Options.Progress
+type Progress struct {
+	mu      sync.Mutex
+	started time.Time
+	// workers holds what every worker is working on, indexed by worker id with the file
+	// workers first, empty when the worker is idle
+	workers []string
+	done    int
+	failed  int
+	bytes   int64
+	// total is how many results the build is expected to have, zero until SetTotal
+	total int
+}
+
+// ProgressSnapshot is what a build had done when Progress.Snapshot was called
+type ProgressSnapshot struct {
+	Done     int
+	Failed   int
+	InFlight int
+	Bytes    int64
+	Elapsed  time.Duration
+	// Total is how many files and symlinks the build is expected to go through, zero when
+	// it is not known
+	Total int
+	// Workers holds what each worker is working on, empty for idle workers
+	Workers []string
+}
+
+// Snapshot copies what the build has done so far
+func (p *Progress) Snapshot() ProgressSnapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := ProgressSnapshot{Done: p.done, Failed: p.failed, Bytes: p.bytes, Total: p.total, Workers: append([]string(nil), p.workers...)}
+	if !p.started.IsZero() {
+		s.Elapsed = time.Since(p.started)
+	}
+	for _, path := range p.workers {
+		if path != "" {
+			s.InFlight++
+		}
+	}
+	return s
+}
+
+// ETA guesses how long the rest of the build takes from how fast files have been done so
+// far, it is zero when there is nothing to go on
+func (s ProgressSnapshot) ETA() time.Duration {
+	if s.Total == 0 || s.Done == 0 || s.Done >= s.Total {
+		return 0
+	}
+	perFile := s.Elapsed / time.Duration(s.Done)
+	return perFile * time.Duration(s.Total-s.Done)
+}
+
+// SetTotal tells p how many files and symlinks the build is expected to go through, it can
+// be called at any time, e.g. once a scan of the sources that runs next to the build is done
+func (p *Progress) SetTotal(total int) {
+	p.mu.Lock()
+	p.total = total
+	p.mu.Unlock()
+}
+
+// begin resets the progress for a build with the given number of workers, but not the
+// total, a nil Progress ignores everything
+func (p *Progress) begin(workers int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.started = time.Now()
+	p.workers = make([]string, workers)
+	p.done, p.failed, p.bytes = 0, 0, 0
+}
+
+// working records what worker id is working on, an empty path when it is done with it
+func (p *Progress) working(id int, path string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.workers[id] = path
+	p.mu.Unlock()
+}
+
+// finished counts a single file result
+func (p *Progress) finished(fr FileResult) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	p.bytes += fr.Bytes
+	if fr.Err != nil {
+		p.failed++
+	}
+}
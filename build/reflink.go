@@ -0,0 +1,80 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+)
+
+// ReflinkMode decides if files that need no processing are cloned instead of copied, a clone
+// shares the blocks of its source until either of them is changed
+type ReflinkMode string
+
+const (
+	// ReflinkAuto clones when the filesystem can and copies when it can not
+	ReflinkAuto ReflinkMode = "auto"
+	// ReflinkAlways fails a file that can not be cloned
+	ReflinkAlways ReflinkMode = "always"
+	// ReflinkNever always copies
+	ReflinkNever ReflinkMode = "never"
+)
+
+// ReflinkModes lists every valid reflink mode
+var ReflinkModes = []ReflinkMode{ReflinkAuto, ReflinkAlways, ReflinkNever}
+
+// errReflinkUnsupported is returned by cloneFile on platforms without a way to clone files
+var errReflinkUnsupported = errors.New("cloning files is not supported on this platform")
+
+// ParseReflinkMode validates the name of a reflink mode, an empty name means never
+func ParseReflinkMode(name string) (ReflinkMode, error) {
+	if name == "" {
+		return ReflinkNever, nil
+	}
+	for _, m := range ReflinkModes {
+		if string(m) == name {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unknown reflink mode %q, must be one of %v", name, ReflinkModes)
+}
+
+// canClone is whether a file that needs no processing can be cloned from its source
+func (fo fileOptions) canClone() bool {
+	return (fo.reflink == ReflinkAuto || fo.reflink == ReflinkAlways) && fo.keepsBytes()
+}
+
+// reflinkFile clones srcPath to destPath when the build would write the file exactly as it is,
+// a file with build tags or variables returns false so it is built instead. So does a file
+// the filesystem can not clone, unless the reflink mode is always.
+func reflinkFile(srcPath string, destPath string, fo fileOptions) (bool, error) {
+	ok, processed := asIs(srcPath, destPath, fo)
+	if !ok {
+		return false, nil
+	}
+	if err := fo.canceled(); err != nil {
+		return false, err
+	}
+
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return false, nil
+	}
+	if err := MakeDirs(path.Dir(destPath), fo.dirMode); err != nil {
+		return false, &DirError{Path: destPath, Dir: path.Dir(destPath), Err: err}
+	}
+	mode := fo.fileMode
+	if fo.preserveMode {
+		mode = info.Mode().Perm()
+	}
+	if err := cloneAtomic(srcPath, destPath, mode); err != nil {
+		if fo.reflink == ReflinkAlways {
+			return false, &WriteError{Path: destPath, Err: fmt.Errorf("can not be cloned from %s: %w", srcPath, err)}
+		}
+		return false, nil
+	}
+	if err := fo.keepTimes(srcPath, destPath); err != nil {
+		return false, &WriteError{Path: destPath, Err: err}
+	}
+	return true, fo.copiedAsIs(srcPath, info.Size(), processed)
+}
@@ -0,0 +1,30 @@
+package build
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// sysClonefileat is the clonefileat system call, it copies a file on APFS by sharing its blocks
+const sysClonefileat = 462
+
+// atFDCWD makes clonefileat resolve relative paths from the working directory
+const atFDCWD = -2
+
+// cloneFile creates dest as a clone of src, dest must not exist
+func cloneFile(src string, dest string) error {
+	srcPtr, err := syscall.BytePtrFromString(src)
+	if err != nil {
+		return err
+	}
+	destPtr, err := syscall.BytePtrFromString(dest)
+	if err != nil {
+		return err
+	}
+	fd := atFDCWD
+	_, _, errno := syscall.Syscall6(sysClonefileat, uintptr(fd), uintptr(unsafe.Pointer(srcPtr)), uintptr(fd), uintptr(unsafe.Pointer(destPtr)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package build
+
+import (
+	"os"
+	"syscall"
+)
+
+// ficlone is the FICLONE ioctl, it makes the destination file share the blocks of the source
+// on filesystems like btrfs and XFS
+const ficlone = 0x40049409
+
+// cloneFile creates dest as a clone of src, dest must not exist
+func cloneFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, out.Fd(), ficlone, in.Fd())
+	closeErr := out.Close()
+	if errno != 0 {
+		os.Remove(dest)
+		return errno
+	}
+	if closeErr != nil {
+		os.Remove(dest)
+	}
+	return closeErr
+}
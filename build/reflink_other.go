@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package build
+
+// cloneFile always fails, there is no way to clone files on this platform
+func cloneFile(src string, dest string) error {
+	return errReflinkUnsupported
+}
@@ -0,0 +1,122 @@
+package build
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RenameRule rewrites the relative path of a file before it is joined to the destination
+type RenameRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+	// Rule is the rule as it was given, for reporting
+	Rule string
+}
+
+// ParseRenameRule parses a rule in the form pattern=>replacement. Patterns starting with re: are
+// regular expressions and the replacement can use $1 style groups, anything else is a glob
+// where every * or ** in the replacement takes what the matching wildcard in the pattern matched.
+func ParseRenameRule(rule string) (RenameRule, error) {
+	parts := strings.SplitN(rule, "=>", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return RenameRule{}, fmt.Errorf("rename rule %q must look like pattern=>replacement", rule)
+	}
+	pattern, replacement := parts[0], parts[1]
+
+	var re *regexp.Regexp
+	var err error
+	if strings.HasPrefix(pattern, "re:") {
+		re, err = regexp.Compile(strings.TrimPrefix(pattern, "re:"))
+	} else {
+		var wildcards int
+		re, wildcards, err = globToRegexp(pattern)
+		if err == nil {
+			replacement, err = globReplacement(replacement, wildcards)
+		}
+	}
+	if err != nil {
+		return RenameRule{}, fmt.Errorf("rename rule %q is not valid: %v", rule, err)
+	}
+
+	if filepath.IsAbs(replacement) || escapesRoot(replacement) {
+		return RenameRule{}, fmt.Errorf("rename rule %q would put files outside of the destination", rule)
+	}
+	return RenameRule{Pattern: re, Replacement: replacement, Rule: rule}, nil
+}
+
+// rename applies the first rule that matches rel, the rule is returned so it can be reported
+func rename(rules []RenameRule, rel string) (string, *RenameRule, error) {
+	slashed := filepath.ToSlash(rel)
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.Pattern.MatchString(slashed) {
+			continue
+		}
+		renamed := filepath.FromSlash(rule.Pattern.ReplaceAllString(slashed, rule.Replacement))
+		if renamed == "" || filepath.IsAbs(renamed) || escapesRoot(renamed) {
+			return "", rule, fmt.Errorf("rename rule %q moves %s outside of the destination", rule.Rule, rel)
+		}
+		return filepath.Clean(renamed), rule, nil
+	}
+	return rel, nil, nil
+}
+
+// escapesRoot checks if a relative path climbs above where it starts
+func escapesRoot(rel string) bool {
+	clean := filepath.Clean(filepath.FromSlash(rel))
+	return clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator))
+}
+
+// globToRegexp turns a glob into an anchored regular expression with a group for every wildcard
+func globToRegexp(glob string) (*regexp.Regexp, int, error) {
+	var expr strings.Builder
+	var wildcards int
+	expr.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			wildcards++
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				expr.WriteString("(.*)")
+			} else {
+				expr.WriteString("([^/]*)")
+			}
+		case '?':
+			wildcards++
+			expr.WriteString("([^/])")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	re, err := regexp.Compile(expr.String())
+	return re, wildcards, err
+}
+
+// globReplacement swaps the wildcards in a glob replacement for the groups of its pattern
+func globReplacement(replacement string, wildcards int) (string, error) {
+	var out strings.Builder
+	var used int
+	for i := 0; i < len(replacement); i++ {
+		switch c := replacement[i]; c {
+		case '*', '?':
+			if c == '*' && i+1 < len(replacement) && replacement[i+1] == '*' {
+				i++
+			}
+			used++
+			if used > wildcards {
+				return "", fmt.Errorf("replacement has more wildcards than the pattern")
+			}
+			out.WriteString("${" + strconv.Itoa(used) + "}")
+		case '$':
+			out.WriteString("$$")
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String(), nil
+}
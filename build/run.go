@@ -0,0 +1,1339 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jwhitcraft/rome/utils"
+	"github.com/jwhitcraft/rome/workerpool"
+)
+
+// Options controls how Run builds a source tree
+type Options struct {
+	// Sources are walked in order and all built into the same destination
+	Sources     []string
+	Destination string
+	Flavor      string
+	Version     string
+
+	// FileWorkers and LinkWorkers default to one per CPU, a buffer size of zero means
+	// every file is handed straight to a worker
+	FileWorkers    int
+	FileBufferSize int
+	LinkWorkers    int
+	LinkBufferSize int
+
+	// WalkWorkers is how many folders at the top of a source are walked at once, it defaults
+	// to one per CPU. Everything found is still handed on one at a time.
+	WalkWorkers int
+
+	// AutoWorkers sizes the file and symlink workers from the CPUs instead and ramps them up or
+	// down during the build as files get done faster or slower. Only pools whose workers are
+	// left at zero are tuned, a number that is set is kept.
+	AutoWorkers bool
+
+	// MaxInflightBytes caps how much file data all the file workers hold in memory at once,
+	// a worker waits before reading a file until its size fits under the cap. Files larger
+	// than the cap are streamed one at a time. This is independent of FileWorkers, which only
+	// caps how many files are worked on; zero means no cap.
+	MaxInflightBytes int64
+
+	// MaxOpenFiles caps how many files the workers have open at once, a file being built
+	// counts as two, one for its source and one for its destination; zero means no cap
+	MaxOpenFiles int
+
+	// StreamThreshold is the size in bytes above which a file is streamed instead of read
+	// into memory in one go, zero means files are only streamed to fit MaxInflightBytes
+	StreamThreshold int64
+
+	// VerifyAfter reads every built file back from the destination and compares its hash
+	// to the hash of what was written
+	VerifyAfter bool
+
+	// LintPHP is the php binary every built PHP file whose build tags or variables changed it
+	// is checked with, php -l has to find it valid or the file fails; empty lints nothing
+	LintPHP string
+
+	// Hash hashes every built file as it is written, see FileResult.SHA256
+	Hash bool
+
+	// LineEndings rewrites the line endings of every built file that is not binary
+	LineEndings LineEndings
+
+	// StripBOM removes the UTF-8 byte order mark from the start of every built file that is
+	// not binary, otherwise it is kept
+	StripBOM bool
+
+	// FileMode and DirMode, when set, are the exact permissions of every file and folder
+	// created in the destination, the umask of the process does not apply. Otherwise files
+	// keep the permissions of the file they replace and folders are created with 0775.
+	FileMode os.FileMode
+	DirMode  os.FileMode
+
+	// PreserveMode gives every built file the permissions of its source, like the executable
+	// bit of a script, it can not be used with FileMode. PreserveTimes gives every built file
+	// the modification time of its source.
+	PreserveMode  bool
+	PreserveTimes bool
+
+	// LinkIdentical hardlinks a file to its source instead of copying it when it has no build
+	// tags or variables, or is not processed at all. The file and its source are then the same
+	// file. Files are still copied when line endings are changed or byte order marks stripped,
+	// and it can not be used with FileMode.
+	LinkIdentical bool
+
+	// Reflink clones files that would be copied as they are from their sources, on filesystems
+	// like APFS, btrfs and XFS that can share the blocks until one of them changes. Hardlinks
+	// come first when LinkIdentical is set as well. It defaults to never.
+	Reflink ReflinkMode
+
+	// Processors transform the files they match once they are built, in order, see Processor.
+	// Files a processor matches are read into memory and never linked or cloned.
+	Processors []Processor
+
+	// ResolveIncludes replaces every include directive in a kept block with the built content
+	// of the file it points to, see IncludeRegex
+	ResolveIncludes bool
+
+	// GzipExtensions lists the extensions of built files that also get a gzip compressed
+	// .gz sibling for web servers that serve precompressed assets
+	GzipExtensions []string
+	// GzipMinSize is the smallest built file, in bytes, that gets a .gz sibling
+	GzipMinSize int64
+
+	// Sink, when set, gets every built file instead of the Destination folder, which then only
+	// names the root the sink's paths are relative to. Anything that needs to look at the
+	// destination on the disk, like verifying or recreating symlinks, can not be used with it.
+	Sink Sink
+
+	// Filter picks which files are built, nil builds everything
+	Filter *Filter
+
+	// IgnoreFiles reads the IgnoreFile in the root of every source and leaves out what it
+	// matches under that source on top of Filter
+	IgnoreFiles bool
+	// sourceFilters holds Filter with the IgnoreFile of every source that has one
+	sourceFilters map[string]*Filter
+
+	// MaxDepth, when above zero, keeps the walk out of folders whose files would be more than
+	// MaxDepth levels below a source root, so 1 only builds the files in the root
+	MaxDepth int
+
+	// MaxFileSize leaves out files of more than this many bytes and MinFileSize files of
+	// fewer, zero turns either off. Symlinks are never left out by their size.
+	MaxFileSize int64
+	MinFileSize int64
+
+	// Symlinks decides if symlinks are recreated, built or copied as real files or skipped,
+	// defaults to preserve
+	Symlinks SymlinkMode
+
+	// OnlySymlinks builds nothing but symlinks and NoSymlinks nothing but regular files, the
+	// workers for what is left out are never started. They can not be used together.
+	OnlySymlinks bool
+	NoSymlinks   bool
+
+	// Flatten writes every file straight into the destination under its base name. Files with
+	// the same base name are resolved by OnConflict like any other existing destination and
+	// each collision is a warning. It can not be used with Rename.
+	Flatten bool
+
+	// Rename rules rewrite where files end up under the destination, the first match wins
+	Rename []RenameRule
+
+	// Paths, when set, are the relative paths of the only files that are built. They are looked
+	// up in every source instead of walking them, a path in none of them is a warning.
+	Paths []string
+
+	// Completed holds the relative paths of files finished by an earlier build, they are left
+	// out of the build without being looked at, see LoadCheckpoint
+	Completed map[string]bool
+
+	// Cache, when set, is the cache of the last build, see ReadCache. Sources it has that did
+	// not change are left out when it was built with the same flavor, version and settings and
+	// what they were built to is still in the destination. Result.Cache is the cache of this
+	// build, NewCache starts caching without leaving anything out.
+	Cache *Cache
+
+	// VersionStable holds the paths, relative to the destination and with / separators, of
+	// files that do not use the version variable. They are left as they are in the
+	// destination, which is only right when nothing but the version changed since they were
+	// built, see ManifestEntry.VersionStable.
+	VersionStable map[string]bool
+
+	// WarnEmpty warns about every zero byte source file, they are still built
+	WarnEmpty bool
+
+	// FailCaseClashes fails a file whose destination only differs in case from the
+	// destination of another file, otherwise it is a warning
+	FailCaseClashes bool
+
+	// FailUnreadable fails files that can not be read because of their permissions, otherwise
+	// they are left out of the build with a warning
+	FailUnreadable bool
+
+	// OnMissingVersion decides what happens to files that use the version placeholder when
+	// Version is empty, defaults to error
+	OnMissingVersion MissingVersionPolicy
+
+	// DryRun builds every file in memory and compares it to the destination instead of writing
+	// it, see FileResult.Changed. Symlinks are skipped.
+	DryRun bool
+
+	// FileTimeout fails a file that takes longer than this to build, the worker moves on to the
+	// next file. Files are stopped between reads and between lines, zero lets them take as long
+	// as they need.
+	FileTimeout time.Duration
+
+	// MaxFailures stops the build once this many files failed, Run then returns
+	// ErrTooManyFailures; zero keeps going no matter how many fail
+	MaxFailures int
+
+	// OnConflict decides what to do when a destination file already exists, defaults to overwrite
+	OnConflict ConflictPolicy
+
+	// Explainf, when set, is told about every file or folder that is skipped and why
+	Explainf func(format string, args ...interface{})
+
+	// Warnf, when set, is told about every warning as it is found
+	Warnf func(format string, args ...interface{})
+
+	// Debugf, when set, is given verbose messages about decisions made during the build
+	Debugf func(format string, args ...interface{})
+
+	// Progress, when set, is kept up to date with what the build has done and what every
+	// worker is working on, so it can be looked at while the build runs
+	Progress *Progress
+
+	// OnStart, when set, is called with the source of every file and symlink as a worker
+	// starts on it. Unlike OnResult it is called from the workers, so calls are concurrent.
+	OnStart func(source string)
+
+	// OnResult is called once for every file and symlink that was processed, calls are never concurrent
+	OnResult func(FileResult)
+}
+
+// FileResult is the outcome of building a single file or symlink
+type FileResult struct {
+	// Path is relative to the source folder
+	Path        string
+	Source      string
+	Destination string
+	Link        bool
+	Skipped     bool
+	Err         error
+	Started     time.Time
+	Duration    time.Duration
+	// Size is the size of the source, Bytes is how much was written to the destination
+	Size  int64
+	Bytes int64
+	// SHA256 is the hex hash of what was written, it is only set when Options.Hash is
+	SHA256 string
+	// VersionSensitive is set when the file uses the version variable
+	VersionSensitive bool
+	// NoOp is set when the file was processed but has no build tags or variables, so a plain
+	// copy would have given the same file
+	NoOp bool
+	// Processed is set on a dry run for a file whose build tags and variables are looked at,
+	// any other file is copied as it is
+	Processed bool
+	// Changed and Content are only set on a dry run, Changed when Content, what would have
+	// been written, is not what is in the destination
+	Changed bool
+	Content []byte
+
+	// cached is the cache entry of the source, when caching
+	cached *CacheEntry
+}
+
+// Result is the outcome of a call to Run
+type Result struct {
+	Built     int32
+	Failed    int32
+	Skipped   int32
+	Resumed   int32 // files left out because they are in Options.Completed
+	Cached    int32 // files left out because Options.Cache has them unchanged
+	Conflicts ConflictCounts
+	Errors    []error
+	Elapsed   time.Duration
+
+	// Failures has every file and symlink that failed, with what was being done at the time
+	Failures []Failure
+
+	// BytesWritten is the total written to the destination by every file worker
+	BytesWritten int64
+	// BytesSkipped is the size of the source files that were not built because they were
+	// already in the destination, either from a checkpoint or the conflict policy
+	BytesSkipped int64
+
+	// LinksSkipped counts the symlinks left out because the symlink mode is skip or NoSymlinks
+	LinksSkipped int32
+	// FilesSkipped counts the regular files left out because of OnlySymlinks
+	FilesSkipped int32
+
+	// Empty counts the zero byte source files, they are built as empty files
+	Empty int32
+
+	// SkippedBySize counts the files left out by MaxFileSize or MinFileSize
+	SkippedBySize int32
+
+	// PrunedByDepth counts the folders that were not walked into because of MaxDepth
+	PrunedByDepth int32
+
+	// MissingVersion lists the files that use the version placeholder when no version was given
+	MissingVersion []string
+
+	// Cache is the build cache to read next time, it is only set when Options.Cache is
+	Cache *Cache
+
+	// FailedDirs counts the files and symlinks left out of each destination folder that could
+	// not be created
+	FailedDirs map[string]int
+
+	// Warnings lists everything suspicious found during the build, see Warning
+	Warnings []Warning
+}
+
+type file struct {
+	Root string
+	Path string
+	Info os.FileInfo
+	// verbatim files are copied without looking at their build tags or variables
+	verbatim bool
+}
+type link struct {
+	Root   string
+	Link   string
+	Target string
+}
+
+// Run walks the source tree and builds every file and symlink into the destination. When
+// ctx is done no new files are started, the files being built are finished and the result
+// so far is returned along with the context's error.
+func Run(ctx context.Context, opts Options) (*Result, error) {
+	b, err := newBuildRun(opts)
+	if err != nil {
+		return nil, err
+	}
+	b.start(ctx)
+	walkBuilds([]*buildRun{b})
+	return b.finish()
+}
+
+// RunEach runs a build for every one of opts at once, like Run. They may only differ in what
+// is built and where to, like the flavor and the destination: the sources are walked a single
+// time with the sources, filters and walk settings of the first build, and everything found is
+// handed to the workers of every build. The results and errors are in the order of opts, when
+// any of opts is not valid nothing is built and only the errors are returned.
+func RunEach(ctx context.Context, opts []Options) ([]*Result, []error) {
+	results := make([]*Result, len(opts))
+	errs := make([]error, len(opts))
+	builds := make([]*buildRun, len(opts))
+	var invalid bool
+	for i, o := range opts {
+		builds[i], errs[i] = newBuildRun(o)
+		invalid = invalid || errs[i] != nil
+	}
+	if invalid || len(builds) == 0 {
+		return results, errs
+	}
+	for _, b := range builds {
+		b.start(ctx)
+	}
+	walkBuilds(builds)
+	for i, b := range builds {
+		results[i], errs[i] = b.finish()
+	}
+	return results, errs
+}
+
+// buildRun is a single build of Run or RunEach, from the checked options to the result
+type buildRun struct {
+	opts    Options
+	ctx     context.Context
+	cancel  context.CancelFunc
+	r       *runner
+	result  *Result
+	started time.Time
+
+	// autoFiles and autoLinks are how many workers an autotuned pool starts with
+	autoFiles int
+	autoLinks int
+
+	files     *workerpool.Pool[file]
+	links     *workerpool.Pool[link]
+	fileTuner *autotuner
+	linkTuner *autotuner
+	collected chan bool
+
+	// running out of space fails every file after it, so the build is stopped when it happens
+	outOfSpace      bool
+	tooManyFailures bool
+
+	builtFiles       utils.Counter
+	failedFiles      utils.Counter
+	skippedFiles     utils.Counter
+	resumedFiles     utils.Counter
+	skippedLinks     utils.Counter
+	emptyFiles       utils.Counter
+	sizeSkippedFiles utils.Counter
+	depthPrunedDirs  utils.Counter
+	skippedRegular   utils.Counter
+	cachedFiles      utils.Counter
+
+	// the cache of the last build only counts when it was built the same way, a partial build
+	// keeps what it has for everything it does not look at
+	lastCache *Cache
+	carried   map[string]CacheEntry
+}
+
+// newBuildRun checks opts and fills in the defaults, nothing is started yet
+func newBuildRun(opts Options) (*buildRun, error) {
+	opts.Sources = append([]string(nil), opts.Sources...)
+	for i, source := range opts.Sources {
+		opts.Sources[i] = filepath.Clean(source)
+		if _, err := os.Stat(opts.Sources[i]); err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("%s: %w", source, ErrSourceMissing)
+			}
+			return nil, err
+		}
+	}
+	if err := opts.loadIgnoreFiles(); err != nil {
+		return nil, err
+	}
+	opts.Destination = filepath.Clean(opts.Destination)
+	if opts.Flatten && len(opts.Rename) > 0 {
+		return nil, fmt.Errorf("flatten and rename rules can not be used together")
+	}
+	if opts.PreserveMode && opts.FileMode != 0 {
+		return nil, fmt.Errorf("a file mode and preserving the mode of the sources can not be used together")
+	}
+	if opts.LinkIdentical && opts.FileMode != 0 {
+		return nil, fmt.Errorf("a file mode can not be used when linking files to their sources, it would change the sources too")
+	}
+	if opts.Sink != nil {
+		if err := opts.checkSink(); err != nil {
+			return nil, err
+		}
+	}
+	b := &buildRun{}
+	// autotuned pools start every worker they can ramp up to and let only some of them work
+	if opts.AutoWorkers && opts.FileWorkers <= 0 {
+		b.autoFiles, opts.FileWorkers = autoWorkers(2, 16)
+	}
+	if opts.AutoWorkers && opts.LinkWorkers <= 0 {
+		b.autoLinks, opts.LinkWorkers = autoWorkers(1, 4)
+	}
+	// zero workers would never pick anything up, so it means one per CPU instead
+	if opts.FileWorkers <= 0 {
+		opts.FileWorkers = runtime.NumCPU()
+	}
+	if opts.LinkWorkers <= 0 {
+		opts.LinkWorkers = runtime.NumCPU()
+	}
+	if opts.OnlySymlinks && opts.NoSymlinks {
+		return nil, fmt.Errorf("only symlinks and no symlinks can not be used together")
+	}
+	if opts.Cache != nil && opts.ResolveIncludes {
+		return nil, fmt.Errorf("the build cache can not be used when resolving includes, changes to included files would go unnoticed")
+	}
+	// nothing is ever handed to the workers for what is left out
+	if opts.OnlySymlinks {
+		opts.FileWorkers = 0
+	}
+	if opts.NoSymlinks {
+		opts.LinkWorkers = 0
+	}
+	if opts.FileBufferSize < 0 {
+		opts.FileBufferSize = 0
+	}
+	if opts.LinkBufferSize < 0 {
+		opts.LinkBufferSize = 0
+	}
+
+	b.opts = opts
+	return b, nil
+}
+
+// start starts the workers and the collector of their results
+func (b *buildRun) start(ctx context.Context) {
+	opts := b.opts
+	b.ctx, b.cancel = context.WithCancel(ctx)
+	b.started = time.Now()
+	r := &runner{
+		opts:    opts,
+		claims:  newConflicts(opts.OnConflict),
+		results: make(chan FileResult, opts.FileBufferSize),
+	}
+	b.r = r
+	r.claims.remote = opts.Sink != nil
+	if opts.MaxInflightBytes > 0 {
+		r.inflight = newWeighted(opts.MaxInflightBytes)
+	}
+	if opts.MaxOpenFiles > 0 {
+		r.openFiles = newWeighted(int64(opts.MaxOpenFiles))
+	}
+
+	result := &Result{}
+	b.result = result
+	b.carried = make(map[string]CacheEntry)
+	if opts.Cache != nil {
+		result.Cache = &Cache{Flavor: opts.Flavor, Version: opts.Version, Settings: opts.cacheSettings(), Files: make(map[string]CacheEntry)}
+		if opts.Cache.Flavor == opts.Flavor && opts.Cache.Version == opts.Version && opts.Cache.Settings == result.Cache.Settings {
+			b.lastCache = opts.Cache
+		}
+		if b.lastCache != nil && opts.Paths != nil {
+			for rel, entry := range b.lastCache.Files {
+				b.carried[rel] = entry
+			}
+		}
+	}
+	b.collected = make(chan bool)
+	go func() {
+		for fr := range r.results {
+			if fr.Err != nil {
+				b.failedFiles.Increment()
+				result.Failures = append(result.Failures, Failure{Path: fr.Path, Operation: failedOperation(fr.Err, fr.Link), Err: fr.Err})
+				if !b.outOfSpace && isNoSpace(fr.Err) {
+					b.outOfSpace = true
+					b.cancel()
+				}
+				if opts.MaxFailures > 0 && !b.tooManyFailures && b.failedFiles.Get() >= int32(opts.MaxFailures) {
+					b.tooManyFailures = true
+					b.cancel()
+				}
+			}
+			var dirErr *DirError
+			if errors.As(fr.Err, &dirErr) {
+				if result.FailedDirs == nil {
+					result.FailedDirs = make(map[string]int)
+				}
+				result.FailedDirs[dirErr.Dir]++
+			}
+			if opts.Version == "" && (fr.VersionSensitive || errors.Is(fr.Err, ErrMissingVersion)) {
+				result.MissingVersion = append(result.MissingVersion, fr.Path)
+			}
+			if result.Cache != nil && fr.cached != nil && fr.Err == nil && !fr.Skipped {
+				result.Cache.Files[fr.Path] = *fr.cached
+			}
+			if fr.Err == nil && !fr.Skipped && !fr.Link && fr.Size == 0 {
+				b.emptyFiles.Increment()
+			}
+			if fr.Skipped {
+				b.skippedFiles.Increment()
+				if fr.Link {
+					b.skippedLinks.Increment()
+				}
+			}
+			opts.Progress.finished(fr)
+			if opts.OnResult != nil {
+				opts.OnResult(fr)
+			}
+		}
+		close(b.collected)
+	}()
+
+	progress := opts.Progress
+	progress.begin(opts.FileWorkers + opts.LinkWorkers)
+	b.files = workerpool.NewWithID(b.ctx, opts.FileWorkers, opts.FileBufferSize, func(id int, f file) error {
+		progress.working(id, f.Path)
+		defer progress.working(id, "")
+		defer b.fileTuner.observe(time.Now())
+		return r.buildFile(f)
+	})
+	b.links = workerpool.NewWithID(b.ctx, opts.LinkWorkers, opts.LinkBufferSize, func(id int, l link) error {
+		progress.working(opts.FileWorkers+id, l.Link)
+		defer progress.working(opts.FileWorkers+id, "")
+		defer b.linkTuner.observe(time.Now())
+		return r.buildLink(l)
+	})
+	if b.autoFiles > 0 && opts.FileWorkers > 0 {
+		b.fileTuner = newAutotuner("file", b.files, b.autoFiles, opts.FileWorkers, opts.Debugf)
+		go b.fileTuner.run(b.ctx, autotuneInterval)
+	}
+	if b.autoLinks > 0 && opts.LinkWorkers > 0 {
+		b.linkTuner = newAutotuner("symlink", b.links, b.autoLinks, opts.LinkWorkers, opts.Debugf)
+		go b.linkTuner.run(b.ctx, autotuneInterval)
+	}
+}
+
+// submit queues a file or symlink found under root unless something leaves it out
+func (b *buildRun) submit(root string, path string, f os.FileInfo) error {
+	opts, r := b.opts, b.r
+	isLink := f.Mode()&os.ModeSymlink != 0
+	if isLink && opts.NoSymlinks {
+		b.skippedLinks.Increment()
+		opts.explainSkip(path, "symlinks are left out")
+		return nil
+	}
+	if !isLink && opts.OnlySymlinks {
+		b.skippedRegular.Increment()
+		opts.explainSkip(path, "only symlinks are built")
+		return nil
+	}
+	if ok, reason := opts.filterFor(root).allows(relativePath(root, path)); !ok {
+		opts.explainSkip(path, reason)
+		return nil
+	}
+	if !isLink {
+		if ok, reason := opts.sizeAllows(f.Size()); !ok {
+			b.sizeSkippedFiles.Increment()
+			opts.explainSkip(path, reason)
+			return nil
+		}
+	}
+	if opts.Completed[relativePath(root, path)] {
+		b.resumedFiles.Increment()
+		r.bytesSkipped.Add(f.Size())
+		if entry, ok := b.lastCache.entry(relativePath(root, path)); ok {
+			b.carried[relativePath(root, path)] = entry
+		}
+		return nil
+	}
+	if b.lastCache != nil && !isLink {
+		if entry, ok := r.cachedSource(b.lastCache, root, path, f); ok {
+			b.carried[relativePath(root, path)] = entry
+			b.cachedFiles.Increment()
+			r.bytesSkipped.Add(f.Size())
+			opts.explainSkip(path, "it did not change since the last build")
+			return nil
+		}
+	}
+	// handle symlinks differently than normal files
+	var queued bool
+	if isLink {
+		originFile, _ := os.Readlink(path)
+		queued = b.links.Submit(link{Root: root, Link: path, Target: originFile})
+	} else {
+		queued = b.files.Submit(file{Root: root, Path: path, Info: f})
+	}
+	if !queued {
+		// the build was cancelled, stop walking
+		return b.ctx.Err()
+	}
+	b.builtFiles.Increment()
+	return nil
+}
+
+// walkBuilds walks the sources of the first build, or looks up its Paths, and hands everything
+// found to every build. The walk stops once every build is cancelled.
+func walkBuilds(builds []*buildRun) {
+	first := builds[0]
+	opts := first.opts
+	stopped := func() bool {
+		for _, b := range builds {
+			if b.ctx.Err() == nil {
+				return false
+			}
+		}
+		return true
+	}
+	submit := func(root string, path string, f os.FileInfo) error {
+		var err error
+		for _, b := range builds {
+			if submitErr := b.submit(root, path, f); submitErr != nil {
+				err = submitErr
+			}
+		}
+		if err != nil && stopped() {
+			return err
+		}
+		return nil
+	}
+
+	found := make(map[string]bool)
+	for _, root := range opts.Sources {
+		if stopped() {
+			break
+		}
+		if opts.Paths != nil {
+			first.r.lookupPaths(root, found, submit)
+			continue
+		}
+		opts.walkSource(root, first.r.warn, &first.depthPrunedDirs, submit)
+	}
+	for _, rel := range opts.Paths {
+		if !found[rel] && !stopped() {
+			first.r.warn(Warning{Path: rel, Msg: "is not in any of the sources"})
+		}
+	}
+	for _, b := range builds[1:] {
+		b.depthPrunedDirs = first.depthPrunedDirs
+	}
+}
+
+// finish waits for the workers to be done with everything that was submitted and returns
+// the result of the build
+func (b *buildRun) finish() (*Result, error) {
+	defer b.cancel()
+	opts, r, result := b.opts, b.r, b.result
+	// end of tasks, wait for all workers to shut down properly
+	result.Errors = append(b.files.Wait(), b.links.Wait()...)
+	close(r.results)
+	<-b.collected
+
+	result.Built = b.builtFiles.Get()
+	result.Failed = b.failedFiles.Get()
+	result.Skipped = b.skippedFiles.Get()
+	result.Resumed = b.resumedFiles.Get()
+	result.Cached = b.cachedFiles.Get()
+	if result.Cache != nil {
+		for rel, entry := range b.carried {
+			if _, ok := result.Cache.Files[rel]; !ok {
+				result.Cache.Files[rel] = entry
+			}
+		}
+	}
+	result.LinksSkipped = b.skippedLinks.Get()
+	result.Empty = b.emptyFiles.Get()
+	result.SkippedBySize = b.sizeSkippedFiles.Get()
+	result.PrunedByDepth = b.depthPrunedDirs.Get()
+	result.FilesSkipped = b.skippedRegular.Get()
+	result.BytesWritten = r.bytesWritten.Get()
+	result.BytesSkipped = r.bytesSkipped.Get()
+	result.Conflicts = r.claims.Counts()
+	result.Warnings = r.warnings
+	result.Elapsed = time.Since(b.started)
+	if b.outOfSpace {
+		return result, fmt.Errorf("%s: %w", opts.Destination, ErrNoSpace)
+	}
+	if b.tooManyFailures {
+		return result, fmt.Errorf("aborted after %d failures: %w", opts.MaxFailures, ErrTooManyFailures)
+	}
+	return result, b.ctx.Err()
+}
+
+// runner holds what the file and link workers share during a build
+type runner struct {
+	opts     Options
+	claims   *conflicts
+	inflight *weighted
+	results  chan FileResult
+
+	// openFiles holds two for every file being built, when there is a cap on open files
+	openFiles *weighted
+
+	bytesWritten utils.ByteCounter
+	bytesSkipped utils.ByteCounter
+
+	warnMu   sync.Mutex
+	warnings []Warning
+
+	// flattened remembers the first source of every destination when flattening
+	flattenMu sync.Mutex
+	flattened map[string]string
+
+	// cased remembers the first destination and source of every lower case destination
+	casedMu sync.Mutex
+	cased   map[string]casedFile
+}
+
+type casedFile struct {
+	dest string
+	src  string
+}
+
+// cachedSource checks if c has src unchanged and what it was built to is still in the
+// destination, the file is reported as skipped when it is
+func (r *runner) cachedSource(c *Cache, root string, src string, f os.FileInfo) (CacheEntry, bool) {
+	rel, dest, err := destinationPath(r.opts, root, src)
+	if err != nil {
+		return CacheEntry{}, false
+	}
+	if _, err := os.Stat(dest); err != nil {
+		return CacheEntry{}, false
+	}
+	entry, ok := c.unchanged(rel, src, f)
+	if ok {
+		r.claims.kept(dest, f.ModTime())
+		r.results <- FileResult{Path: rel, Source: src, Destination: dest, Skipped: true, Size: f.Size()}
+	}
+	return entry, ok
+}
+
+// checkFlattened warns when src flattens onto the same destination as an earlier source
+func (r *runner) checkFlattened(src string, dest string) {
+	r.flattenMu.Lock()
+	defer r.flattenMu.Unlock()
+	if r.flattened == nil {
+		r.flattened = make(map[string]string)
+	}
+	if first, ok := r.flattened[dest]; ok {
+		r.warn(Warning{Path: src, Msg: fmt.Sprintf("flattens to %s just like %s, the conflict policy %s decides which is kept", dest, first, r.claims.policy)})
+		return
+	}
+	r.flattened[dest] = src
+}
+
+// warn records a warning and passes it on to Warnf
+func (r *runner) warn(w Warning) {
+	r.warnMu.Lock()
+	r.warnings = append(r.warnings, w)
+	r.warnMu.Unlock()
+	if r.opts.Warnf != nil {
+		r.opts.Warnf("%s", w)
+	}
+}
+
+// checkCase warns when dest only differs in case from the destination of an earlier source,
+// on a case insensitive file system one would overwrite the other. With
+// Options.FailCaseClashes it fails instead.
+func (r *runner) checkCase(src string, dest string) error {
+	key := strings.ToLower(dest)
+	r.casedMu.Lock()
+	defer r.casedMu.Unlock()
+	if r.cased == nil {
+		r.cased = make(map[string]casedFile)
+	}
+	first, ok := r.cased[key]
+	if !ok {
+		r.cased[key] = casedFile{dest: dest, src: src}
+		return nil
+	}
+	if first.dest == dest {
+		// the same destination is up to the conflict policy
+		return nil
+	}
+	if r.opts.FailCaseClashes {
+		return fmt.Errorf("%s and %s (built from %s): %w", dest, first.dest, first.src, ErrCaseCollision)
+	}
+	r.warn(Warning{Path: src, Msg: fmt.Sprintf("builds to %s which only differs in case from %s built from %s, on a case insensitive file system one overwrites the other", dest, first.dest, first.src)})
+	return nil
+}
+
+// buildFile is the file worker, it builds a single file and reports the result
+func (r *runner) buildFile(f file) (err error) {
+	defer r.recoverPanic(f.Root, f.Path, false, &err)
+	opts := r.opts
+	if opts.OnStart != nil {
+		opts.OnStart(f.Path)
+	}
+	shortPath, finalDestination, err := destinationPath(opts, f.Root, f.Path)
+	if err != nil {
+		r.results <- FileResult{Path: shortPath, Source: f.Path, Err: err}
+		return err
+	}
+	if opts.Flatten {
+		r.checkFlattened(f.Path, finalDestination)
+	}
+	if err := r.checkCase(f.Path, finalDestination); err != nil {
+		r.results <- FileResult{Path: shortPath, Source: f.Path, Destination: finalDestination, Err: err}
+		return err
+	}
+	if opts.DryRun {
+		r.previewFile(f, shortPath, finalDestination)
+		return nil
+	}
+	if opts.VersionStable != nil {
+		if rel, relErr := filepath.Rel(opts.Destination, finalDestination); relErr == nil && opts.VersionStable[filepath.ToSlash(rel)] {
+			r.claims.kept(finalDestination, f.Info.ModTime())
+			r.bytesSkipped.Add(f.Info.Size())
+			opts.explainSkip(f.Path, "it does not use the version and was already built")
+			r.results <- FileResult{Path: shortPath, Source: f.Path, Destination: finalDestination, Skipped: true, Size: f.Info.Size()}
+			return nil
+		}
+	}
+	if opts.WarnEmpty && f.Info.Size() == 0 {
+		r.warn(Warning{Path: f.Path, Msg: "is empty"})
+	}
+	start := time.Now()
+	built := false
+	var written int64
+	var out fileOutput
+	var cached *CacheEntry
+	ok, release, err := r.claims.acquire(finalDestination, f.Info.ModTime())
+	if ok {
+		func() {
+			// a panic while building must not leave the destination claimed
+			defer func() { release(built) }()
+			r.acquireFiles()
+			defer r.releaseFiles()
+			// hashed before it is read, so a change while building is noticed next time
+			if opts.Cache != nil {
+				if sum, hashErr := hashSource(f.Path); hashErr == nil {
+					cached = &CacheEntry{SHA256: sum, Size: f.Info.Size(), ModTime: f.Info.ModTime()}
+				}
+			}
+			built, err = r.buildTimed(f, finalDestination, &written, &out)
+			if err == nil && built && opts.shouldGzip(finalDestination, written) {
+				var gzipped int64
+				mode := opts.FileMode
+				if opts.PreserveMode {
+					mode = f.Info.Mode().Perm()
+				}
+				gzipped, err = gzipFile(finalDestination, mode)
+				written += gzipped
+				if err == nil {
+					if timesErr := opts.fileOptions().keepTimes(f.Path, finalDestination+".gz"); timesErr != nil {
+						err = &WriteError{Path: finalDestination + ".gz", Err: timesErr}
+					}
+				}
+			}
+		}()
+		r.bytesWritten.Add(written)
+		if err != nil && !opts.FailUnreadable && isUnreadable(err) {
+			r.warn(Warning{Path: f.Path, Msg: "can not be read, permission denied"})
+			opts.explainSkip(f.Path, "it can not be read")
+			err = nil
+		} else if err == nil && !built {
+			opts.explainSkip(f.Path, "a FILE tag excludes the "+opts.Flavor+" flavor")
+		}
+	} else if err == nil {
+		r.bytesSkipped.Add(f.Info.Size())
+		opts.explainSkip(f.Path, fmt.Sprintf("%s already exists and the conflict policy is %s", finalDestination, r.claims.policy))
+	}
+	r.results <- FileResult{Path: shortPath, Source: f.Path, Destination: finalDestination, Skipped: !built && err == nil, Err: err, Started: start, Duration: time.Since(start), Size: f.Info.Size(), Bytes: written, SHA256: out.sha256, VersionSensitive: out.versioned, NoOp: out.noOp, cached: cached}
+	return err
+}
+
+// acquireFiles waits until the source and destination of a file can be opened under the cap
+func (r *runner) acquireFiles() {
+	if r.openFiles != nil {
+		r.openFiles.acquire(2)
+	}
+}
+
+func (r *runner) releaseFiles() {
+	if r.openFiles != nil {
+		r.openFiles.release(2)
+	}
+}
+
+// recoverPanic turns a panic in a worker into a failed result for the file it was building,
+// so one bad file does not take the whole build down. It has to be deferred.
+func (r *runner) recoverPanic(root string, src string, isLink bool, err *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	panicErr := &PanicError{Path: src, Value: v, Stack: debug.Stack()}
+	r.opts.debugf("%v\n%s", panicErr, panicErr.Stack)
+	r.results <- FileResult{Path: relativePath(root, src), Source: src, Link: isLink, Err: panicErr}
+	*err = panicErr
+}
+
+// lookupPaths finds every one of Options.Paths that is in root and hands it to submit without
+// walking root, found is told which paths were there
+func (r *runner) lookupPaths(root string, found map[string]bool, submit func(string, string, os.FileInfo) error) {
+	rootInfo, err := os.Lstat(root)
+	if err != nil {
+		r.warn(Warning{Path: root, Msg: fmt.Sprintf("could not be read: %v", err)})
+		return
+	}
+	for _, rel := range r.opts.Paths {
+		path := filepath.Join(root, rel)
+		if !rootInfo.IsDir() {
+			// a source that is a single file is only found by its name
+			if rel != filepath.Base(root) {
+				continue
+			}
+			path = root
+		}
+		f, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		found[rel] = true
+		if err != nil {
+			r.warn(Warning{Path: path, Msg: fmt.Sprintf("could not be read: %v", err)})
+			continue
+		}
+		if f.IsDir() {
+			r.opts.explainSkip(path, "it is a folder")
+			continue
+		}
+		if submit(root, path, f) != nil {
+			return
+		}
+	}
+}
+
+// sizeAllows checks a file of size bytes against MaxFileSize and MinFileSize,
+// the reason says why it is left out
+func (opts Options) sizeAllows(size int64) (bool, string) {
+	if opts.MaxFileSize > 0 && size > opts.MaxFileSize {
+		return false, fmt.Sprintf("it is %d bytes, larger than %d", size, opts.MaxFileSize)
+	}
+	if opts.MinFileSize > 0 && size < opts.MinFileSize {
+		return false, fmt.Sprintf("it is %d bytes, smaller than %d", size, opts.MinFileSize)
+	}
+	return true, ""
+}
+
+// fileOptions returns how every file in the build is built
+func (opts Options) fileOptions() fileOptions {
+	fo := fileOptions{flavor: opts.Flavor, version: opts.Version, lineEndings: opts.LineEndings, stripBOM: opts.StripBOM, resolveIncludes: opts.ResolveIncludes, fileMode: opts.FileMode, dirMode: opts.DirMode, preserveMode: opts.PreserveMode, preserveTimes: opts.PreserveTimes, linkIdentical: opts.LinkIdentical, reflink: opts.Reflink}
+	if opts.Version == "" {
+		switch opts.OnMissingVersion {
+		case MissingVersionLeave:
+			// substituting the placeholder with itself leaves it as it is
+			fo.version = versionVar
+		case MissingVersionEmpty:
+		default:
+			fo.failMissingVersion = true
+		}
+	}
+	return fo
+}
+
+// fileOutput is what buildWithin learns about a file while building it
+type fileOutput struct {
+	sha256    string
+	versioned bool
+	noOp      bool
+}
+
+// buildTimed builds a file with buildWithin under Options.FileTimeout
+func (r *runner) buildTimed(f file, dest string, written *int64, out *fileOutput) (bool, error) {
+	ctx := context.Background()
+	if r.opts.FileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.opts.FileTimeout)
+		defer cancel()
+	}
+	built, err := r.buildWithin(ctx, f, dest, written, out)
+	return built, r.timedOut(ctx, f.Path, err)
+}
+
+// timedOut replaces err with ErrTimedOut when the file failed because ctx ran out of time
+func (r *runner) timedOut(ctx context.Context, src string, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s: %w after %s", src, ErrTimedOut, r.opts.FileTimeout)
+	}
+	return err
+}
+
+// buildWithin builds a file once its size fits in the in flight semaphore, files too big
+// for the semaphore get all of it and are streamed instead of read into memory, just like
+// anything over the stream threshold
+func (r *runner) buildWithin(ctx context.Context, f file, dest string, written *int64, out *fileOutput) (bool, error) {
+	opts, inflight := r.opts, r.inflight
+	fo := opts.fileOptions()
+	fo.ctx = ctx
+	fo.written = written
+	fo.verbatim = f.verbatim
+	if opts.Sink != nil {
+		rel, err := filepath.Rel(opts.Destination, dest)
+		if err != nil {
+			return false, err
+		}
+		fo.sink, fo.sinkName = opts.Sink, filepath.ToSlash(rel)
+	}
+	fo.warn = func(line int, msg string) {
+		r.warn(Warning{Path: f.Path, Line: line, Msg: msg})
+	}
+	if opts.VerifyAfter || (opts.Hash && out != nil) {
+		fo.hash = sha256.New()
+	}
+	if out != nil {
+		fo.versioned = &out.versioned
+		fo.noOp = &out.noOp
+	} else {
+		fo.noOp = new(bool)
+	}
+
+	size := f.Info.Size()
+	buildFn := buildFile
+	if opts.StreamThreshold > 0 && size > opts.StreamThreshold {
+		buildFn = streamFile
+	}
+	if inflight != nil {
+		inflight.acquire(size)
+		defer inflight.release(size)
+		if size > inflight.size {
+			buildFn = streamFile
+		}
+	}
+	fo.rel = relativePath(f.Root, f.Path)
+	if fo.processors = opts.processorsFor(fo.rel); len(fo.processors) > 0 {
+		// processors work on the whole file
+		buildFn = buildFile
+	}
+
+	var built bool
+	var err error
+	if fo.canLink() {
+		built, err = linkFile(f.Path, dest, fo)
+	}
+	if !built && err == nil && fo.canClone() {
+		built, err = reflinkFile(f.Path, dest, fo)
+	}
+	if !built && err == nil {
+		built, err = buildFn(f.Path, dest, fo)
+	}
+	if err == nil && built && opts.lints(dest, *fo.noOp) {
+		err = lintPHP(ctx, opts.LintPHP, f.Path, dest)
+	}
+	if err != nil || !built || fo.hash == nil {
+		return built, err
+	}
+	if out != nil {
+		out.sha256 = hex.EncodeToString(fo.hash.Sum(nil))
+	}
+	if !opts.VerifyAfter {
+		return built, nil
+	}
+	return built, verifyFile(dest, fo.hash.Sum(nil))
+}
+
+// verifyFile reads dest back from the disk and checks it hashes to sum
+func verifyFile(dest string, sum []byte) error {
+	fr, err := os.Open(dest)
+	if err != nil {
+		return &VerifyError{Path: dest, Expected: hex.EncodeToString(sum), Err: err}
+	}
+	defer fr.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, fr); err != nil {
+		return &VerifyError{Path: dest, Expected: hex.EncodeToString(sum), Err: err}
+	}
+	if actual := h.Sum(nil); !bytes.Equal(actual, sum) {
+		return &VerifyError{Path: dest, Expected: hex.EncodeToString(sum), Actual: hex.EncodeToString(actual)}
+	}
+	return nil
+}
+
+// buildLink is the symlink worker, it recreates, copies or skips a single symlink depending
+// on the symlink mode and reports the result
+func (r *runner) buildLink(l link) (err error) {
+	defer r.recoverPanic(l.Root, l.Link, true, &err)
+	opts := r.opts
+	if opts.OnStart != nil {
+		opts.OnStart(l.Link)
+	}
+	shortPath, finalDestination, err := destinationPath(opts, l.Root, l.Link)
+	if err != nil {
+		r.results <- FileResult{Path: shortPath, Source: l.Link, Link: true, Err: err}
+		return err
+	}
+	if err := r.checkCase(l.Link, finalDestination); err != nil {
+		r.results <- FileResult{Path: shortPath, Source: l.Link, Destination: finalDestination, Link: true, Err: err}
+		return err
+	}
+	if opts.DryRun {
+		r.previewLink(l, shortPath, finalDestination)
+		return nil
+	}
+	start := time.Now()
+	built := true
+	var written int64
+	switch opts.Symlinks {
+	case SymlinksSkip:
+		built = false
+		opts.explainSkip(l.Link, "symlinks are skipped")
+	case SymlinksDereference, SymlinksCopy:
+		built, err = r.copyLink(l, finalDestination, &written)
+		r.bytesWritten.Add(written)
+	default:
+		if _, statErr := os.Stat(l.Link); statErr != nil {
+			r.warn(Warning{Path: l.Link, Msg: "symlink points to " + l.Target + " which does not exist"})
+		}
+		var target string
+		if target, err = r.linkTarget(l, finalDestination); err != nil {
+			break
+		}
+		if target != l.Target {
+			opts.debugf("symlink %s points to %s, recreated pointing to %s", l.Link, l.Target, target)
+		}
+		if mkdirErr := MakeDirs(path.Dir(finalDestination), r.opts.DirMode); mkdirErr != nil {
+			err = &DirError{Path: finalDestination, Dir: path.Dir(finalDestination), Err: mkdirErr}
+			break
+		}
+		err = recreateLink(target, finalDestination)
+	}
+	r.results <- FileResult{Path: shortPath, Source: l.Link, Destination: finalDestination, Link: true, Skipped: !built && err == nil, Err: err, Started: start, Duration: time.Since(start), Bytes: written}
+	return err
+}
+
+// destinationPath returns the path of src relative to its source root and where it should be built to
+func destinationPath(opts Options, root string, src string) (string, string, error) {
+	rel := relativePath(root, src)
+	if opts.Flatten {
+		return rel, filepath.Join(opts.Destination, filepath.Base(rel)), nil
+	}
+	renamed, rule, err := rename(opts.Rename, rel)
+	if err != nil {
+		return rel, "", err
+	}
+	if rule != nil {
+		opts.debugf("rename rule %s moved %s to %s", rule.Rule, rel, renamed)
+	}
+	return rel, filepath.Join(opts.Destination, renamed), nil
+}
+
+// walkSource walks root like a build does, leaving out the root node_modules and the folders
+// the filter prunes or that are deeper than MaxDepth, visit is called for everything else that
+// is not a folder. The folders at the top of root are walked by up to WalkWorkers at once.
+func (opts Options) walkSource(root string, warn func(Warning), prunedByDepth *utils.Counter, visit func(root string, path string, f os.FileInfo) error) error {
+	workers := opts.WalkWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	info, err := os.Lstat(root)
+	if workers == 1 || err != nil || !info.IsDir() {
+		return filepath.Walk(root, opts.sourceWalker(root, warn, prunedByDepth, visit))
+	}
+
+	// reading the folders is what takes the time, so only that happens at once, the walker
+	// itself is called for one path at a time and visit does not need to lock anything
+	var mu sync.Mutex
+	var walkErr error
+	walker := opts.sourceWalker(root, warn, prunedByDepth, visit)
+	locked := func(path string, f os.FileInfo, err error) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if walkErr != nil {
+			return walkErr
+		}
+		err = walker(path, f, err)
+		if err != nil && err != filepath.SkipDir {
+			walkErr = err
+		}
+		return err
+	}
+	if err := locked(root, info, nil); err != nil {
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		if err := locked(root, info, err); err != nil && err != filepath.SkipDir {
+			return err
+		}
+		return nil
+	}
+
+	walkers := newWeighted(int64(workers))
+	var wg sync.WaitGroup
+	for _, entry := range entries {
+		path := filepath.Join(root, entry.Name())
+		if !entry.IsDir() {
+			locked(path, entry, nil)
+			continue
+		}
+		walkers.acquire(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer walkers.release(1)
+			filepath.Walk(path, locked)
+		}()
+	}
+	wg.Wait()
+	return walkErr
+}
+
+// sourceWalker returns the filepath.WalkFunc of walkSource for the walk of root, or of a folder in it
+func (opts Options) sourceWalker(root string, warn func(Warning), prunedByDepth *utils.Counter, visit func(root string, path string, f os.FileInfo) error) filepath.WalkFunc {
+	// when a ! pattern can include something under the root node_modules it is walked, but
+	// only what the pattern includes is built
+	var nodeModules string
+	filter := opts.filterFor(root)
+	return func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			warn(Warning{Path: path, Msg: fmt.Sprintf("could not be read: %v", err)})
+			opts.explainSkip(path, fmt.Sprintf("could not be read: %v", err))
+			return nil
+		}
+		// ignore the node_modules dir in the root, but lead sidecar
+		if nodeModules != "" && strings.HasPrefix(path, nodeModules+string(filepath.Separator)) {
+			rel := relativePath(root, path)
+			if !filter.negated(rel) && (!f.IsDir() || !filter.negatesUnder(rel)) {
+				opts.explainSkip(path, "node_modules in the root are pruned")
+				if f.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		} else if f.Name() == "node_modules" && strings.Contains(path, "sugarcrm/node_modules") {
+			if !filter.negatesUnder(relativePath(root, path)) {
+				opts.explainSkip(path, "node_modules in the root are pruned")
+				return filepath.SkipDir
+			}
+			nodeModules = path
+		}
+		if f.IsDir() && path != root {
+			if opts.MaxDepth > 0 && pathDepth(relativePath(root, path)) >= opts.MaxDepth {
+				prunedByDepth.Increment()
+				opts.explainSkip(path, fmt.Sprintf("it is deeper than the max depth of %d", opts.MaxDepth-1))
+				return filepath.SkipDir
+			}
+			if pruned, reason := filter.prunes(relativePath(root, path)); pruned {
+				opts.explainSkip(path, reason)
+				return filepath.SkipDir
+			}
+		}
+		if !f.IsDir() {
+			return visit(root, path, f)
+		}
+		return nil
+	}
+}
+
+// loadIgnoreFiles reads the IgnoreFile of every source when IgnoreFiles is set
+func (opts *Options) loadIgnoreFiles() error {
+	if !opts.IgnoreFiles {
+		return nil
+	}
+	opts.sourceFilters = make(map[string]*Filter)
+	for _, root := range opts.Sources {
+		filter, err := opts.Filter.withIgnoreFile(root)
+		if err != nil {
+			return err
+		}
+		opts.sourceFilters[root] = filter
+	}
+	return nil
+}
+
+// filterFor returns the filter for the files under the source root
+func (opts Options) filterFor(root string) *Filter {
+	if filter, ok := opts.sourceFilters[root]; ok {
+		return filter
+	}
+	return opts.Filter
+}
+
+// pathDepth is how many folders and files make up the relative path rel
+func pathDepth(rel string) int {
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// relativePath returns the path of src relative to its source root
+func relativePath(root string, src string) string {
+	rel, err := filepath.Rel(root, src)
+	if err != nil || rel == "." {
+		// a source that is a single file is built straight into the destination
+		return filepath.Base(src)
+	}
+	return rel
+}
+
+func (opts Options) explainSkip(path string, reason string) {
+	if opts.Explainf != nil {
+		opts.Explainf("skipped %s: %s", path, reason)
+	}
+}
+
+func (opts Options) debugf(format string, args ...interface{}) {
+	if opts.Debugf != nil {
+		opts.Debugf(format, args...)
+	}
+}
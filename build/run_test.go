@@ -0,0 +1,318 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeTree writes files, by path relative to the root, into a new temporary folder and
+// returns it
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for rel, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// testOptions builds src into a new temporary destination with a few workers
+func testOptions(t *testing.T, src string, flavor string) Options {
+	return Options{
+		Sources:        []string{src},
+		Destination:    t.TempDir(),
+		Flavor:         flavor,
+		Version:        "7.0",
+		FileWorkers:    4,
+		FileBufferSize: 16,
+		LinkWorkers:    1,
+		LinkBufferSize: 16,
+	}
+}
+
+// runBuild runs opts and fails the test if the build as a whole could not run
+func runBuild(t *testing.T, opts Options) *Result {
+	t.Helper()
+	res, err := Run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	return res
+}
+
+// readBuilt returns what the build wrote to rel in dest, or "<missing>"
+func readBuilt(t *testing.T, dest string, rel string) string {
+	t.Helper()
+	content, err := ioutil.ReadFile(filepath.Join(dest, filepath.FromSlash(rel)))
+	if os.IsNotExist(err) {
+		return "<missing>"
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestRunStreamThreshold(t *testing.T) {
+	source := "<?php\n// BEGIN SUGARCRM flav=ent ONLY\n" + longLine + "\n// END SUGARCRM flav=ent ONLY\n$v = '@_SUGAR_VERSION';\n"
+	src := writeTree(t, map[string]string{"big.php": source, "small.php": "<?php // @_SUGAR_FLAV\n"})
+	for _, threshold := range []int64{0, 1024} {
+		opts := testOptions(t, src, "ent")
+		opts.StreamThreshold = threshold
+		res := runBuild(t, opts)
+		if res.Failed != 0 || res.Built != 2 {
+			t.Fatalf("threshold %d: built %d and failed %d: %v", threshold, res.Built, res.Failed, res.Errors)
+		}
+		if got, want := readBuilt(t, opts.Destination, "big.php"), "<?php\n"+longLine+"\n$v = '7.0';\n"; got != want {
+			t.Errorf("threshold %d: big.php is %d bytes, want %d", threshold, len(got), len(want))
+		}
+		if got := readBuilt(t, opts.Destination, "small.php"); got != "<?php // ent\n" {
+			t.Errorf("threshold %d: small.php is %q", threshold, got)
+		}
+	}
+}
+
+func TestRelativePath(t *testing.T) {
+	root := filepath.Join("work", "src")
+	tests := []struct {
+		root string
+		src  string
+		want string
+	}{
+		{root, filepath.Join(root, "a.php"), "a.php"},
+		{root + "/", filepath.Join(root, "a.php"), "a.php"},
+		{root + "///", filepath.Join(root, "modules", "a.php"), filepath.Join("modules", "a.php")},
+		{root + "/", root, "src"},
+		{filepath.Join(root, "a.php"), filepath.Join(root, "a.php"), "a.php"},
+	}
+	for _, tt := range tests {
+		if got := relativePath(tt.root, tt.src); got != tt.want {
+			t.Errorf("relativePath(%q, %q) = %q, want %q", tt.root, tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestRunSourceWithTrailingSlashes(t *testing.T) {
+	src := writeTree(t, map[string]string{"a.php": "<?php\n", "modules/b.php": "<?php\n"})
+	for _, suffix := range []string{"", "/", "///"} {
+		opts := testOptions(t, src+suffix, "ent")
+		var dests []string
+		opts.OnResult = func(fr FileResult) {
+			dests = append(dests, fr.Destination)
+			if fr.Path == "" || filepath.IsAbs(fr.Path) {
+				t.Errorf("source %q: relative path %q", opts.Sources[0], fr.Path)
+			}
+		}
+		res := runBuild(t, opts)
+		if res.Failed != 0 {
+			t.Fatalf("source %q: %v", opts.Sources[0], res.Errors)
+		}
+		for _, dest := range dests {
+			if filepath.Clean(dest) != dest {
+				t.Errorf("source %q: destination %q is not clean", opts.Sources[0], dest)
+			}
+		}
+		for _, rel := range []string{"a.php", "modules/b.php"} {
+			if got := readBuilt(t, opts.Destination, rel); got != "<?php\n" {
+				t.Errorf("source %q: %s is %q", opts.Sources[0], rel, got)
+			}
+		}
+	}
+}
+
+// panicProcessor panics on the files named boom
+type panicProcessor struct{}
+
+func (panicProcessor) Name() string          { return "panic" }
+func (panicProcessor) Match(rel string) bool { return true }
+func (panicProcessor) Process(rel string, content []byte) ([]byte, error) {
+	if strings.HasPrefix(filepath.Base(rel), "boom") {
+		var m map[string]int
+		m["boom"]++
+	}
+	return content, nil
+}
+
+func TestRunRecoversFromPanics(t *testing.T) {
+	files := map[string]string{"boom.php": "<?php\n"}
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("ok%d.php", i)] = "<?php\n"
+	}
+	src := writeTree(t, files)
+	opts := testOptions(t, src, "ent")
+	opts.Processors = []Processor{panicProcessor{}}
+	var mu sync.Mutex
+	var debug []string
+	opts.Debugf = func(format string, args ...interface{}) {
+		mu.Lock()
+		debug = append(debug, fmt.Sprintf(format, args...))
+		mu.Unlock()
+	}
+	res := runBuild(t, opts)
+
+	if res.Failed != 1 || len(res.Failures) != 1 {
+		t.Fatalf("%d files failed, want only boom.php: %+v", res.Failed, res.Failures)
+	}
+	var panicErr *PanicError
+	if !errors.As(res.Failures[0].Err, &panicErr) {
+		t.Fatalf("boom.php failed with %T %v, want a *PanicError", res.Failures[0].Err, res.Failures[0].Err)
+	}
+	if res.Failures[0].Path != "boom.php" || len(panicErr.Stack) == 0 {
+		t.Errorf("failure is for %q with a %d byte stack", res.Failures[0].Path, len(panicErr.Stack))
+	}
+	for i := 0; i < 20; i++ {
+		rel := fmt.Sprintf("ok%d.php", i)
+		if got := readBuilt(t, opts.Destination, rel); got != "<?php\n" {
+			t.Errorf("%s is %q after another file panicked", rel, got)
+		}
+	}
+	var logged bool
+	for _, msg := range debug {
+		logged = logged || (strings.Contains(msg, "panic while building") && strings.Contains(msg, "goroutine"))
+	}
+	if !logged {
+		t.Error("the stack of the panic was not given to Debugf")
+	}
+}
+
+func TestRunWorkerBoundaries(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i < 50; i++ {
+		files[fmt.Sprintf("dir%d/file%d.php", i%5, i)] = "<?php // @_SUGAR_FLAV\n"
+	}
+	src := writeTree(t, files)
+	if err := os.Symlink("file0.php", filepath.Join(src, "dir0", "link.php")); err != nil {
+		t.Skipf("symlinks are not supported: %v", err)
+	}
+	tests := []struct {
+		name                    string
+		fileWorkers, fileBuffer int
+		linkWorkers, linkBuffer int
+		walkWorkers             int
+	}{
+		{"zero workers mean one per CPU", 0, 16, 0, 16, 0},
+		{"negative workers mean one per CPU", -1, 16, -3, 16, -1},
+		{"unbuffered", 2, 0, 1, 0, 1},
+		{"negative buffers are unbuffered", 2, -1, 1, -5, 2},
+		{"a single worker", 1, 1, 1, 1, 1},
+		{"everything zero", 0, 0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		opts := testOptions(t, src, "ent")
+		opts.FileWorkers, opts.FileBufferSize = tt.fileWorkers, tt.fileBuffer
+		opts.LinkWorkers, opts.LinkBufferSize = tt.linkWorkers, tt.linkBuffer
+		opts.WalkWorkers = tt.walkWorkers
+
+		done := make(chan *Result, 1)
+		go func() {
+			res, err := Run(context.Background(), opts)
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			done <- res
+		}()
+		select {
+		case res := <-done:
+			if res == nil {
+				continue
+			}
+			if res.Built != 51 || res.Failed != 0 {
+				t.Errorf("%s: built %d and failed %d, want 51 and 0", tt.name, res.Built, res.Failed)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: the build hung", tt.name)
+		}
+		if got := readBuilt(t, opts.Destination, "dir4/file49.php"); got != "<?php // ent\n" {
+			t.Errorf("%s: dir4/file49.php is %q", tt.name, got)
+		}
+		if target, err := os.Readlink(filepath.Join(opts.Destination, "dir0", "link.php")); err != nil || target != "file0.php" {
+			t.Errorf("%s: the symlink was not recreated: %q %v", tt.name, target, err)
+		}
+	}
+}
+
+func TestRunEachWalksOnce(t *testing.T) {
+	src := writeTree(t, map[string]string{
+		"a.php":         "<?php\n// BEGIN SUGARCRM flav=ent ONLY\n$e = 1;\n// END SUGARCRM flav=ent ONLY\n$f = '@_SUGAR_FLAV';\n",
+		"skip/b.php":    "<?php $b = 1;\n",
+		"deep/er/c.php": "<?php $c = 1;\n",
+	})
+	other := writeTree(t, map[string]string{"other.php": "<?php $o = 1;\n"})
+	filter, err := NewFilter(nil, []string{"skip/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ent := testOptions(t, src, "ent")
+	ent.Filter = filter
+	ent.MaxDepth = 2
+	// only what the first build walks is built, the sources, filter and depth of the others
+	// are not looked at
+	pro := testOptions(t, other, "pro")
+
+	var mu sync.Mutex
+	started := make(map[string]int)
+	count := func(source string) {
+		mu.Lock()
+		started[source]++
+		mu.Unlock()
+	}
+	ent.OnStart, pro.OnStart = count, count
+
+	results, errs := RunEach(context.Background(), []Options{ent, pro})
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("build %d: %v", i, err)
+		}
+	}
+	for i, res := range results {
+		if res.Built != 1 || res.Failed != 0 || res.PrunedByDepth != 1 {
+			t.Errorf("build %d: built %d, failed %d, pruned %d", i, res.Built, res.Failed, res.PrunedByDepth)
+		}
+	}
+	if got, want := readBuilt(t, ent.Destination, "a.php"), "<?php\n$e = 1;\n$f = 'ent';\n"; got != want {
+		t.Errorf("ent built %q, want %q", got, want)
+	}
+	if got, want := readBuilt(t, pro.Destination, "a.php"), "<?php\n$f = 'pro';\n"; got != want {
+		t.Errorf("pro built %q, want %q", got, want)
+	}
+	for _, rel := range []string{"other.php", "skip/b.php", "deep/er/c.php"} {
+		if got := readBuilt(t, pro.Destination, rel); got != "<missing>" {
+			t.Errorf("pro built %s", rel)
+		}
+	}
+	// every file found is handed to each build once
+	if want := map[string]int{filepath.Join(src, "a.php"): 2}; !reflect.DeepEqual(started, want) {
+		t.Errorf("started %v, want %v", started, want)
+	}
+}
+
+func TestRunEachInvalid(t *testing.T) {
+	src := writeTree(t, map[string]string{"a.php": "<?php $a = 1;\n"})
+	good := testOptions(t, src, "ent")
+	bad := testOptions(t, filepath.Join(src, "missing"), "pro")
+	results, errs := RunEach(context.Background(), []Options{good, bad})
+	if errs[0] != nil || !errors.Is(errs[1], ErrSourceMissing) {
+		t.Fatalf("errors %v", errs)
+	}
+	if results[0] != nil || results[1] != nil {
+		t.Errorf("results %v, nothing should have been built", results)
+	}
+	if got := readBuilt(t, good.Destination, "a.php"); got != "<missing>" {
+		t.Error("the valid build ran")
+	}
+}
@@ -0,0 +1,425 @@
+package build
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3Sink uploads built files to a bucket, a file larger than partSize goes up in parts with a
+// multipart upload. Credentials are looked up like the AWS SDKs do, see awsEnv.provider, and
+// AWS_ENDPOINT_URL points it at something other than AWS, like MinIO. Requests that fail
+// because of the network, throttling or a server error are tried again.
+type s3Sink struct {
+	bucket   string
+	prefix   string
+	region   string
+	endpoint *url.URL
+	creds    *s3Provider
+	client   *http.Client
+
+	// uploads caps how many files are sent at once
+	uploads *weighted
+
+	// partSize is the size of the parts of a multipart upload, it grows for files that would
+	// need more than s3MaxParts
+	partSize int64
+	// attempts is how often a request is tried, retryDelay the wait before the second try
+	// which doubles for every try after it
+	attempts   int
+	retryDelay time.Duration
+}
+
+const (
+	// s3PartSize is the default size of the parts of a multipart upload
+	s3PartSize = 16 << 20
+	// s3MaxParts is the most parts a multipart upload can have
+	s3MaxParts = 10000
+)
+
+func newS3Sink(bucket string, prefix string, maxUploads int) (*s3Sink, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("s3 destination needs a bucket, e.g. s3://bucket/prefix")
+	}
+	env, err := loadAWSEnv(os.Getenv)
+	if err != nil {
+		return nil, err
+	}
+	s := &s3Sink{
+		bucket:     bucket,
+		prefix:     prefix,
+		region:     env.region(),
+		creds:      env.provider(),
+		client:     &http.Client{Timeout: 5 * time.Minute},
+		uploads:    newWeighted(int64(maxUploads)),
+		partSize:   s3PartSize,
+		attempts:   5,
+		retryDelay: 200 * time.Millisecond,
+	}
+	if s.creds == nil {
+		return nil, fmt.Errorf("s3 destination needs AWS credentials: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, use a profile in ~/.aws/credentials or run with a role")
+	}
+	// fail before anything is built when there are no credentials to be had
+	if _, err := s.creds.get(); err != nil {
+		return nil, err
+	}
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("AWS_ENDPOINT_URL %s is not a valid URL", endpoint)
+		}
+		s.endpoint = u
+	}
+	return s, nil
+}
+
+// Create buffers the file in a temporary file, it is uploaded on Commit
+func (s *s3Sink) Create(name string) (SinkFile, error) {
+	tmp, err := ioutil.TempFile("", "rome-s3-")
+	if err != nil {
+		return nil, err
+	}
+	key := path.Join(s.prefix, name)
+	return &s3File{sink: s, key: key, tmp: tmp, hash: sha256.New()}, nil
+}
+
+// objectURL is where key is put, in the bucket's virtual host on AWS and under the bucket in
+// the path anywhere else
+func (s *s3Sink) objectURL(key string, query url.Values) *url.URL {
+	u := &url.URL{Scheme: "https", Host: s.bucket + ".s3." + s.region + ".amazonaws.com", Path: "/" + key}
+	if s.endpoint != nil {
+		u = &url.URL{Scheme: s.endpoint.Scheme, Host: s.endpoint.Host, Path: "/" + s.bucket + "/" + key}
+	}
+	// what is sent has to be escaped exactly like what is signed
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3CanonicalQuery(query)
+	return u
+}
+
+// s3Request is a request to S3, body is opened again for every try
+type s3Request struct {
+	method      string
+	key         string
+	query       url.Values
+	header      http.Header
+	body        func() io.Reader
+	size        int64
+	payloadHash string
+}
+
+// do sends req until it succeeds, fails for good or runs out of tries, the body of the
+// response is returned
+func (s *s3Sink) do(req s3Request) (http.Header, []byte, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		var header http.Header
+		var body []byte
+		var retry bool
+		header, body, retry, err = s.try(req)
+		if err == nil || !retry || attempt >= s.attempts {
+			return header, body, err
+		}
+		// back off exponentially, with some jitter so uploads that failed together do not
+		// all come back at the same moment
+		delay := s.retryDelay << (attempt - 1)
+		time.Sleep(delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)))
+	}
+}
+
+// try sends req once, retry is set when it failed in a way that can go away
+func (s *s3Sink) try(req s3Request) (http.Header, []byte, bool, error) {
+	creds, err := s.creds.get()
+	if err != nil {
+		return nil, nil, false, err
+	}
+	u := s.objectURL(req.key, req.query)
+	var body io.Reader
+	if req.body != nil {
+		body = req.body()
+	}
+	httpReq, err := http.NewRequest(req.method, u.String(), body)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	httpReq.ContentLength = req.size
+	for name, values := range req.header {
+		httpReq.Header[name] = values
+	}
+	headers := map[string]string{
+		"host":                 u.Host,
+		"x-amz-content-sha256": req.payloadHash,
+		"x-amz-date":           time.Now().UTC().Format(amzDateFormat),
+	}
+	if creds.sessionToken != "" {
+		headers["x-amz-security-token"] = creds.sessionToken
+	}
+	for name, value := range headers {
+		if name != "host" {
+			httpReq.Header.Set(name, value)
+		}
+	}
+	httpReq.Header.Set("Authorization", signV4(creds, s.region, "s3", req.method, u.RawPath, u.RawQuery, headers, req.payloadHash))
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return nil, nil, true, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, nil, true, err
+	}
+	if resp.StatusCode/100 != 2 {
+		msg := respBody
+		if len(msg) > 1024 {
+			msg = msg[:1024]
+		}
+		err := fmt.Errorf("%s s3://%s/%s: %s %s", req.method, s.bucket, req.key, resp.Status, strings.TrimSpace(string(msg)))
+		return nil, nil, s3Retryable(resp.StatusCode, respBody), err
+	}
+	// S3 can fail a request that already started with a 200 and the error in the body
+	if req.method == http.MethodPost && bytes.Contains(respBody, []byte("<Error>")) {
+		return nil, nil, true, fmt.Errorf("%s s3://%s/%s: %s", req.method, s.bucket, req.key, strings.TrimSpace(string(respBody)))
+	}
+	return resp.Header, respBody, false, nil
+}
+
+// s3Retryable checks if a request that failed with status and body can succeed when it is
+// tried again, like after a server error or throttling
+func s3Retryable(status int, body []byte) bool {
+	if status >= 500 || status == http.StatusTooManyRequests {
+		return true
+	}
+	for _, code := range []string{"RequestTimeout", "SlowDown", "Throttling", "ThrottlingException", "RequestTimeTooSkewed"} {
+		if bytes.Contains(body, []byte("<Code>"+code+"</Code>")) {
+			return true
+		}
+	}
+	return false
+}
+
+// put uploads size bytes of body to key, in parts when it is larger than a part
+func (s *s3Sink) put(key string, body io.ReaderAt, size int64, payloadHash string) error {
+	s.uploads.acquire(1)
+	defer s.uploads.release(1)
+
+	header := http.Header{}
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if size > s.partSize {
+		return s.putMultipart(key, body, size, header)
+	}
+	_, _, err := s.do(s3Request{
+		method:      http.MethodPut,
+		key:         key,
+		header:      header,
+		body:        func() io.Reader { return io.NewSectionReader(body, 0, size) },
+		size:        size,
+		payloadHash: payloadHash,
+	})
+	return err
+}
+
+// putMultipart uploads body to key a part at a time, the upload is aborted when a part fails
+// so no parts are left behind in the bucket
+func (s *s3Sink) putMultipart(key string, body io.ReaderAt, size int64, header http.Header) error {
+	partSize := s.partSize
+	if parts := (size + partSize - 1) / partSize; parts > s3MaxParts {
+		partSize = (size + s3MaxParts - 1) / s3MaxParts
+	}
+	_, created, err := s.do(s3Request{method: http.MethodPost, key: key, query: url.Values{"uploads": {""}}, header: header, payloadHash: emptyPayloadHash})
+	if err != nil {
+		return err
+	}
+	var upload struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(created, &upload); err != nil || upload.UploadID == "" {
+		return fmt.Errorf("starting the upload of s3://%s/%s: no upload id in %s", s.bucket, key, created)
+	}
+
+	complete := s3CompleteUpload{}
+	for part, offset := 1, int64(0); offset < size; part, offset = part+1, offset+partSize {
+		length := partSize
+		if offset+length > size {
+			length = size - offset
+		}
+		section := io.NewSectionReader(body, offset, length)
+		hash := sha256.New()
+		if _, err = io.Copy(hash, section); err != nil {
+			break
+		}
+		var respHeader http.Header
+		respHeader, _, err = s.do(s3Request{
+			method:      http.MethodPut,
+			key:         key,
+			query:       url.Values{"partNumber": {strconv.Itoa(part)}, "uploadId": {upload.UploadID}},
+			body:        func() io.Reader { return io.NewSectionReader(section, 0, length) },
+			size:        length,
+			payloadHash: hex.EncodeToString(hash.Sum(nil)),
+		})
+		if err != nil {
+			break
+		}
+		complete.Parts = append(complete.Parts, s3CompletedPart{Number: part, ETag: respHeader.Get("ETag")})
+	}
+	if err == nil {
+		var completeBody []byte
+		if completeBody, err = xml.Marshal(complete); err == nil {
+			completeHash := sha256.Sum256(completeBody)
+			_, _, err = s.do(s3Request{
+				method:      http.MethodPost,
+				key:         key,
+				query:       url.Values{"uploadId": {upload.UploadID}},
+				body:        func() io.Reader { return bytes.NewReader(completeBody) },
+				size:        int64(len(completeBody)),
+				payloadHash: hex.EncodeToString(completeHash[:]),
+			})
+		}
+	}
+	if err != nil {
+		s.do(s3Request{method: http.MethodDelete, key: key, query: url.Values{"uploadId": {upload.UploadID}}, payloadHash: emptyPayloadHash})
+		return err
+	}
+	return nil
+}
+
+// s3CompleteUpload is the body that finishes a multipart upload
+type s3CompleteUpload struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletedPart `xml:"Part"`
+}
+
+type s3CompletedPart struct {
+	Number int    `xml:"PartNumber"`
+	ETag   string `xml:"ETag"`
+}
+
+// emptyPayloadHash is the hex sha256 of an empty body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3File is a file being written to an s3Sink
+type s3File struct {
+	sink *s3Sink
+	key  string
+	tmp  *os.File
+	hash hash.Hash
+	size int64
+	done bool
+}
+
+func (f *s3File) Write(p []byte) (int, error) {
+	n, err := f.tmp.Write(p)
+	f.hash.Write(p[:n])
+	f.size += int64(n)
+	return n, err
+}
+
+// Commit uploads everything written to the bucket
+func (f *s3File) Commit() error {
+	f.done = true
+	defer os.Remove(f.tmp.Name())
+	defer f.tmp.Close()
+	return f.sink.put(f.key, f.tmp, f.size, hex.EncodeToString(f.hash.Sum(nil)))
+}
+
+// Abort throws the file away without uploading it
+func (f *s3File) Abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+	f.tmp.Close()
+	os.Remove(f.tmp.Name())
+}
+
+const amzDateFormat = "20060102T150405Z"
+
+// signV4 returns the Authorization header for a request signed with AWS Signature Version 4.
+// headers are the lower case names and values of every signed header, x-amz-date included.
+func signV4(creds s3Credentials, region string, service string, method string, escapedPath string, query string, headers map[string]string, payloadHash string) string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{method, escapedPath, query, canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+
+	amzDate := headers["x-amz-date"]
+	scope := amzDate[:8] + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + creds.secretKey)
+	for _, part := range []string{amzDate[:8], region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	return fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", creds.accessKey, scope, signedHeaders, signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3EscapePath escapes every byte of p but the unreserved characters and /, the way S3
+// expects the path of a signed request
+func s3EscapePath(p string) string {
+	return awsEscape(p, "-._~/")
+}
+
+// s3CanonicalQuery encodes query sorted by name and escaped the way it is signed
+func s3CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, awsEscape(name, "-._~")+"="+awsEscape(value, "-._~"))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape escapes every byte of s but letters, digits and the bytes in keep
+func awsEscape(s string, keep string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte(keep, c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,354 @@
+package build
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// s3Credentials sign the requests to S3, expires is zero for credentials that never expire
+type s3Credentials struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	expires      time.Time
+}
+
+// s3CredentialsRefresh is how long before they expire temporary credentials are fetched again
+const s3CredentialsRefresh = 5 * time.Minute
+
+// s3Provider hands out the credentials of a source and fetches them again before they expire
+type s3Provider struct {
+	// source says where the credentials come from, for errors
+	source string
+	fetch  func() (s3Credentials, error)
+
+	mu    sync.Mutex
+	creds s3Credentials
+}
+
+func (p *s3Provider) get() (s3Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.creds.accessKey != "" && (p.creds.expires.IsZero() || time.Until(p.creds.expires) > s3CredentialsRefresh) {
+		return p.creds, nil
+	}
+	creds, err := p.fetch()
+	if err != nil {
+		return s3Credentials{}, fmt.Errorf("getting AWS credentials from %s: %v", p.source, err)
+	}
+	p.creds = creds
+	return creds, nil
+}
+
+// awsEnv holds what the credential chain reads from the environment and the AWS config files,
+// see loadAWSEnv
+type awsEnv struct {
+	getenv func(string) string
+	// profile is the section of the config files that is used, explicit when AWS_PROFILE is set
+	profile         string
+	explicitProfile bool
+	credentials     map[string]string
+	config          map[string]string
+	client          *http.Client
+}
+
+// loadAWSEnv reads the profile from the shared credentials file, AWS_SHARED_CREDENTIALS_FILE or
+// ~/.aws/credentials, and the shared config file, AWS_CONFIG_FILE or ~/.aws/config. The
+// profile is AWS_PROFILE or default.
+func loadAWSEnv(getenv func(string) string) (*awsEnv, error) {
+	env := &awsEnv{getenv: getenv, profile: getenv("AWS_PROFILE"), client: &http.Client{Timeout: 5 * time.Second}}
+	env.explicitProfile = env.profile != ""
+	if env.profile == "" {
+		env.profile = "default"
+	}
+	home := getenv("HOME")
+	credentialsFile := getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if credentialsFile == "" && home != "" {
+		credentialsFile = filepath.Join(home, ".aws", "credentials")
+	}
+	configFile := getenv("AWS_CONFIG_FILE")
+	if configFile == "" && home != "" {
+		configFile = filepath.Join(home, ".aws", "config")
+	}
+	var err error
+	if env.credentials, err = readAWSProfile(credentialsFile, env.profile); err != nil {
+		return nil, err
+	}
+	// every profile but default is under [profile name] in the config file
+	section := env.profile
+	if section != "default" {
+		section = "profile " + section
+	}
+	if env.config, err = readAWSProfile(configFile, section); err != nil {
+		return nil, err
+	}
+	if env.explicitProfile && env.credentials == nil && env.config == nil {
+		return nil, fmt.Errorf("AWS_PROFILE %s is not in %s or %s", env.profile, credentialsFile, configFile)
+	}
+	return env, nil
+}
+
+// readAWSProfile returns the keys of a section of an AWS config file, nil when the file or
+// the section does not exist
+func readAWSProfile(path string, section string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var values map[string]string
+	var inSection bool
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.Join(strings.Fields(line[1:len(line)-1]), " ")
+			inSection = name == section
+			if inSection && values == nil {
+				values = make(map[string]string)
+			}
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok && inSection {
+			values[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// lookup returns the first of the environment variable or the key in the profile of the
+// credentials file or the config file that is set
+func (env *awsEnv) lookup(variable string, key string) string {
+	if value := env.getenv(variable); value != "" {
+		return value
+	}
+	if value := env.credentials[key]; value != "" {
+		return value
+	}
+	return env.config[key]
+}
+
+// region is AWS_REGION, AWS_DEFAULT_REGION or the region of the profile, us-east-1 otherwise
+func (env *awsEnv) region() string {
+	if region := env.getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	if region := env.lookup("AWS_DEFAULT_REGION", "region"); region != "" {
+		return region
+	}
+	return "us-east-1"
+}
+
+// provider picks where credentials come from, in the same order as the AWS SDKs:
+//   - AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+//   - a web identity token, AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN as set for IRSA on EKS
+//   - the keys of the profile in the shared credentials or config file
+//   - the container credentials of ECS, AWS_CONTAINER_CREDENTIALS_RELATIVE_URI or _FULL_URI
+//   - the role of the EC2 instance, unless AWS_EC2_METADATA_DISABLED is true
+func (env *awsEnv) provider() *s3Provider {
+	if key, secret := env.getenv("AWS_ACCESS_KEY_ID"), env.getenv("AWS_SECRET_ACCESS_KEY"); key != "" && secret != "" {
+		creds := s3Credentials{accessKey: key, secretKey: secret, sessionToken: env.getenv("AWS_SESSION_TOKEN")}
+		return &s3Provider{source: "the environment", fetch: func() (s3Credentials, error) { return creds, nil }}
+	}
+	if tokenFile, role := env.lookup("AWS_WEB_IDENTITY_TOKEN_FILE", "web_identity_token_file"), env.lookup("AWS_ROLE_ARN", "role_arn"); tokenFile != "" && role != "" {
+		return &s3Provider{source: "the web identity token " + tokenFile, fetch: func() (s3Credentials, error) {
+			return env.assumeRoleWithWebIdentity(tokenFile, role)
+		}}
+	}
+	if key, secret := env.credentials["aws_access_key_id"], env.credentials["aws_secret_access_key"]; key != "" && secret != "" {
+		creds := s3Credentials{accessKey: key, secretKey: secret, sessionToken: env.credentials["aws_session_token"]}
+		return &s3Provider{source: "the profile " + env.profile, fetch: func() (s3Credentials, error) { return creds, nil }}
+	}
+	if key, secret := env.config["aws_access_key_id"], env.config["aws_secret_access_key"]; key != "" && secret != "" {
+		creds := s3Credentials{accessKey: key, secretKey: secret, sessionToken: env.config["aws_session_token"]}
+		return &s3Provider{source: "the profile " + env.profile, fetch: func() (s3Credentials, error) { return creds, nil }}
+	}
+	if uri := env.containerCredentialsURI(); uri != "" {
+		return &s3Provider{source: "the container credentials", fetch: func() (s3Credentials, error) {
+			return env.containerCredentials(uri)
+		}}
+	}
+	if strings.EqualFold(env.getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return nil
+	}
+	return &s3Provider{source: "the EC2 instance role", fetch: env.instanceCredentials}
+}
+
+// awsTemporaryCredentials is how ECS and EC2 hand out credentials
+type awsTemporaryCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+func (c awsTemporaryCredentials) credentials() (s3Credentials, error) {
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return s3Credentials{}, fmt.Errorf("the response has no access key")
+	}
+	return s3Credentials{accessKey: c.AccessKeyID, secretKey: c.SecretAccessKey, sessionToken: c.Token, expires: c.Expiration}, nil
+}
+
+// containerCredentialsURI is where ECS serves the credentials of the task, empty outside ECS
+func (env *awsEnv) containerCredentialsURI() string {
+	if relative := env.getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		return "http://169.254.170.2" + relative
+	}
+	return env.getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+}
+
+func (env *awsEnv) containerCredentials(uri string) (s3Credentials, error) {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return s3Credentials{}, err
+	}
+	if token := env.getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	var creds awsTemporaryCredentials
+	if err := env.getJSON(req, &creds); err != nil {
+		return s3Credentials{}, err
+	}
+	return creds.credentials()
+}
+
+// instanceCredentials asks the instance metadata service for the credentials of the role of
+// the instance, with a session token as IMDSv2 needs. AWS_EC2_METADATA_SERVICE_ENDPOINT
+// points it elsewhere.
+func (env *awsEnv) instanceCredentials() (s3Credentials, error) {
+	endpoint := strings.TrimSuffix(env.getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"), "/")
+	if endpoint == "" {
+		endpoint = "http://169.254.169.254"
+	}
+	req, err := http.NewRequest(http.MethodPut, endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return s3Credentials{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	token, err := env.getString(req)
+	if err != nil {
+		return s3Credentials{}, err
+	}
+	get := func(path string) (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet, endpoint+"/latest/meta-data/iam/security-credentials/"+path, nil)
+		if err == nil {
+			req.Header.Set("X-aws-ec2-metadata-token", token)
+		}
+		return req, err
+	}
+	req, err = get("")
+	if err != nil {
+		return s3Credentials{}, err
+	}
+	roles, err := env.getString(req)
+	if err != nil {
+		return s3Credentials{}, err
+	}
+	role := strings.TrimSpace(strings.SplitN(roles, "\n", 2)[0])
+	if role == "" {
+		return s3Credentials{}, fmt.Errorf("the instance has no role")
+	}
+	if req, err = get(url.PathEscape(role)); err != nil {
+		return s3Credentials{}, err
+	}
+	var creds awsTemporaryCredentials
+	if err := env.getJSON(req, &creds); err != nil {
+		return s3Credentials{}, err
+	}
+	return creds.credentials()
+}
+
+// assumeRoleWithWebIdentity trades the token in tokenFile for credentials of role with STS.
+// The call is not signed, the token is the proof. AWS_ENDPOINT_URL_STS points it elsewhere.
+func (env *awsEnv) assumeRoleWithWebIdentity(tokenFile string, role string) (s3Credentials, error) {
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return s3Credentials{}, err
+	}
+	session := env.lookup("AWS_ROLE_SESSION_NAME", "role_session_name")
+	if session == "" {
+		session = fmt.Sprintf("rome-%d", time.Now().UnixNano())
+	}
+	endpoint := env.getenv("AWS_ENDPOINT_URL_STS")
+	if endpoint == "" {
+		endpoint = "https://sts." + env.region() + ".amazonaws.com"
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {role},
+		"RoleSessionName":  {session},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return s3Credentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := env.getString(req)
+	if err != nil {
+		return s3Credentials{}, err
+	}
+	var resp struct {
+		Credentials struct {
+			AccessKeyID     string `xml:"AccessKeyId"`
+			SecretAccessKey string
+			SessionToken    string
+			Expiration      time.Time
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal([]byte(body), &resp); err != nil {
+		return s3Credentials{}, fmt.Errorf("reading the response of STS: %v", err)
+	}
+	c := resp.Credentials
+	return awsTemporaryCredentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, Token: c.SessionToken, Expiration: c.Expiration}.credentials()
+}
+
+// getString does req and returns the body of a 2xx response
+func (env *awsEnv) getString(req *http.Request) (string, error) {
+	resp, err := env.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("%s %s: %s %s", req.Method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+	}
+	return string(body), nil
+}
+
+func (env *awsEnv) getJSON(req *http.Request, v interface{}) error {
+	body, err := env.getString(req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(body), v)
+}
@@ -0,0 +1,40 @@
+package build
+
+import "sync"
+
+// weighted is a semaphore where every acquire can take a different share of the total size
+type weighted struct {
+	size int64
+	cur  int64
+	mu   sync.Mutex
+	cond *sync.Cond
+}
+
+func newWeighted(size int64) *weighted {
+	w := &weighted{size: size}
+	w.cond = sync.NewCond(&w.mu)
+	return w
+}
+
+// acquire blocks until n can be taken, anything larger than the semaphore waits for all of it
+func (w *weighted) acquire(n int64) {
+	if n > w.size {
+		n = w.size
+	}
+	w.mu.Lock()
+	for w.cur+n > w.size {
+		w.cond.Wait()
+	}
+	w.cur += n
+	w.mu.Unlock()
+}
+
+func (w *weighted) release(n int64) {
+	if n > w.size {
+		n = w.size
+	}
+	w.mu.Lock()
+	w.cur -= n
+	w.mu.Unlock()
+	w.cond.Broadcast()
+}
@@ -0,0 +1,93 @@
+package build
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// Sink is where built files end up when they do not go to a folder on the local disk, see
+// Options.Sink. Tags and variables are still handled locally, only the finished file is
+// handed to the sink.
+type Sink interface {
+	// Create starts writing the file at name, a slash separated path relative to the root of
+	// the sink. Nothing shows up in the sink until the file is committed.
+	Create(name string) (SinkFile, error)
+}
+
+// SinkFile is a file being written to a Sink
+type SinkFile interface {
+	io.Writer
+	// Commit finishes the file and makes it show up in the sink
+	Commit() error
+	// Abort throws away what was written unless the file was committed
+	Abort()
+}
+
+// IsRemote checks if a destination is a URL for a sink instead of a local folder
+func IsRemote(dest string) bool {
+	u, err := url.Parse(dest)
+	// a single letter scheme is a windows drive
+	return err == nil && len(u.Scheme) > 1 && u.Host != ""
+}
+
+// OpenSink opens the sink a remote destination points to, only s3://bucket/prefix is
+// supported. At most maxUploads files are sent at once, zero means one at a time.
+func OpenSink(dest string, maxUploads int) (Sink, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, fmt.Errorf("destination %s is not a valid URL: %v", dest, err)
+	}
+	if maxUploads <= 0 {
+		maxUploads = 1
+	}
+	switch u.Scheme {
+	case "s3":
+		return newS3Sink(u.Host, strings.Trim(u.Path, "/"), maxUploads)
+	case "sftp":
+		return nil, fmt.Errorf("destination %s: sftp destinations are not supported yet, only s3", dest)
+	default:
+		return nil, fmt.Errorf("destination %s: unknown scheme %s, only s3 is supported", dest, u.Scheme)
+	}
+}
+
+// checkSink makes sure nothing in opts needs to look at or change the destination on the
+// local disk when a sink is used
+func (opts Options) checkSink() error {
+	var unsupported []string
+	if opts.DryRun {
+		unsupported = append(unsupported, "dry runs")
+	}
+	if opts.VerifyAfter {
+		unsupported = append(unsupported, "verifying files after they are written")
+	}
+	if opts.LintPHP != "" {
+		unsupported = append(unsupported, "linting PHP files")
+	}
+	if len(opts.GzipExtensions) > 0 {
+		unsupported = append(unsupported, "gzip siblings")
+	}
+	if len(opts.VersionStable) > 0 {
+		unsupported = append(unsupported, "leaving version stable files in place")
+	}
+	if opts.Symlinks == "" || opts.Symlinks == SymlinksPreserve {
+		unsupported = append(unsupported, "recreating symlinks")
+	}
+	if opts.FileMode != 0 || opts.DirMode != 0 {
+		unsupported = append(unsupported, "setting permissions")
+	}
+	if opts.LinkIdentical || opts.Reflink == ReflinkAlways {
+		unsupported = append(unsupported, "linking or cloning files from their sources")
+	}
+	if opts.PreserveMode || opts.PreserveTimes {
+		unsupported = append(unsupported, "preserving permissions and times")
+	}
+	if opts.OnConflict != "" && opts.OnConflict != ConflictOverwrite {
+		unsupported = append(unsupported, "the "+string(opts.OnConflict)+" conflict policy")
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("a remote destination does not support %s", strings.Join(unsupported, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package build
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StampFile is the name of the file written into a destination after a successful build
+const StampFile = ".rome-build.json"
+
+// Stamp records what was last built into a destination
+type Stamp struct {
+	Flavor  string    `json:"flavor"`
+	Version string    `json:"version"`
+	Sources []string  `json:"sources"`
+	Built   time.Time `json:"built"`
+}
+
+// ReadStamp reads the stamp in dest, a destination that has never been built into has no
+// stamp and returns nil without an error
+func ReadStamp(dest string) (*Stamp, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dest, StampFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	stamp := &Stamp{}
+	if err := json.Unmarshal(content, stamp); err != nil {
+		return nil, err
+	}
+	return stamp, nil
+}
+
+// WriteStamp saves stamp into dest
+func WriteStamp(dest string, stamp Stamp) error {
+	content, err := json.MarshalIndent(stamp, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dest, StampFile), append(content, '\n'), 0644)
+}
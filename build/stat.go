@@ -0,0 +1,98 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jwhitcraft/rome/utils"
+)
+
+// TreeStats describes what a build of the sources would go through, see Stat
+type TreeStats struct {
+	Files    int   `json:"files"`
+	Bytes    int64 `json:"bytes"`
+	Symlinks int   `json:"symlinks"`
+	// Extensions breaks the files down by lower case extension without the dot, files
+	// without one are under ""
+	Extensions map[string]*ExtensionStats `json:"extensions"`
+	// MaxDepth is how many folders and files make up the deepest relative path
+	MaxDepth    int        `json:"max_depth"`
+	DeepestPath string     `json:"deepest_path,omitempty"`
+	Largest     []FileSize `json:"largest"`
+	Warnings    []Warning  `json:"-"`
+}
+
+// ExtensionStats counts the files with one extension
+type ExtensionStats struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// FileSize is a file and how big it is
+type FileSize struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Stat walks opts.Sources the same way Run does, honoring Filter, IgnoreFiles and MaxDepth, and tallies
+// what it finds without reading or building anything. The largest files are kept, biggest first.
+func Stat(opts Options, largest int) (*TreeStats, error) {
+	stats := &TreeStats{Extensions: make(map[string]*ExtensionStats)}
+	warn := func(w Warning) {
+		stats.Warnings = append(stats.Warnings, w)
+		if opts.Warnf != nil {
+			opts.Warnf("%s", w)
+		}
+	}
+	if err := opts.loadIgnoreFiles(); err != nil {
+		return nil, err
+	}
+	var prunedByDepth utils.Counter
+	for _, root := range opts.Sources {
+		if _, err := os.Lstat(root); err != nil {
+			return nil, err
+		}
+		opts.walkSource(root, warn, &prunedByDepth, func(root string, path string, f os.FileInfo) error {
+			rel := relativePath(root, path)
+			if ok, _ := opts.filterFor(root).allows(rel); !ok {
+				return nil
+			}
+			if depth := pathDepth(rel); depth > stats.MaxDepth {
+				stats.MaxDepth = depth
+				stats.DeepestPath = path
+			}
+			if f.Mode()&os.ModeSymlink != 0 {
+				stats.Symlinks++
+				return nil
+			}
+			stats.Files++
+			stats.Bytes += f.Size()
+			ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+			if stats.Extensions[ext] == nil {
+				stats.Extensions[ext] = &ExtensionStats{}
+			}
+			stats.Extensions[ext].Files++
+			stats.Extensions[ext].Bytes += f.Size()
+			stats.Largest = keepLargest(stats.Largest, FileSize{Path: path, Bytes: f.Size()}, largest)
+			return nil
+		})
+	}
+	return stats, nil
+}
+
+// keepLargest adds f to the sorted list of the n largest files if it is big enough
+func keepLargest(files []FileSize, f FileSize, n int) []FileSize {
+	if n <= 0 || (len(files) == n && f.Bytes <= files[n-1].Bytes) {
+		return files
+	}
+	i := sort.Search(len(files), func(i int) bool { return files[i].Bytes < f.Bytes })
+	files = append(files, FileSize{})
+	copy(files[i+1:], files[i:])
+	files[i] = f
+	if len(files) > n {
+		files = files[:n]
+	}
+	return files
+}
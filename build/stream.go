@@ -0,0 +1,181 @@
+package build
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// StreamFile builds the same output as BuildFile but never holds more than a line of the
+// source in memory, the source is read twice instead, once to find the build tags and once to write.
+func StreamFile(srcPath string, destPath string, buildFlavor string, buildVersion string) (bool, error) {
+	return streamFile(srcPath, destPath, fileOptions{flavor: buildFlavor, version: buildVersion})
+}
+
+func streamFile(srcPath string, destPath string, fo fileOptions) (bool, error) {
+	buildFlavor, buildVersion := fo.flavor, fo.version
+	var shouldProcess bool = false
+
+	var canProcess bool = !fo.verbatim && canProcessFile(destPath)
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, fmt.Errorf("%s: %w", srcPath, ErrSourceMissing)
+		}
+		return false, &ReadError{Path: srcPath, Err: err}
+	}
+	defer src.Close()
+
+	// binaries are never touched, so look at the start of the file before deciding
+	head := make([]byte, binarySniffLen)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, fmt.Errorf("reading %s: %w", srcPath, err)
+	}
+	binary := isBinary(head[:n])
+	normalize := !binary && (fo.lineEndings == LineEndingsLF || fo.lineEndings == LineEndingsCRLF)
+
+	// a byte order mark is skipped over while reading so it can not get in the way of a tag on
+	// the first line, it is written back first unless it is being stripped
+	var start int64
+	bom := !binary && hasBOM(head[:n])
+	if bom {
+		start = int64(len(utf8BOM))
+	}
+	if _, err := src.Seek(start, io.SeekStart); err != nil {
+		return false, fmt.Errorf("reading %s: %w", srcPath, err)
+	}
+
+	if canProcess {
+		// the first tag decides if the file has to be processed, just like BuildFile
+		scanner := newLineScanner(fo.reader(src))
+		var lineNum int
+		for scanner.Scan() {
+			lineNum++
+			if fo.resolveIncludes && IncludeRegex.MatchString(scanner.Text()) {
+				shouldProcess = true
+			}
+			if matches := TagRegex.FindStringSubmatch(scanner.Text()); matches != nil {
+				shouldProcess = true
+				tagOk, err := fileTagAllows(matches, srcPath, lineNum, buildFlavor)
+				if err != nil || !tagOk {
+					return false, err
+				}
+				break
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return false, fmt.Errorf("reading %s: %w", srcPath, err)
+		}
+		if _, err := src.Seek(start, io.SeekStart); err != nil {
+			return false, fmt.Errorf("reading %s: %w", srcPath, err)
+		}
+	}
+
+	fw, err := fo.create(srcPath, destPath)
+	if err != nil {
+		return false, err
+	}
+	defer fw.Abort()
+	buffered := bufio.NewWriter(fo.destWriter(fw))
+	if bom && !fo.stripBOM {
+		buffered.Write(utf8BOM)
+	}
+	var writer io.Writer = buffered
+	var endings *lineEndingWriter
+	if normalize {
+		endings = newLineEndingWriter(buffered, fo.lineEndings)
+		writer = endings
+	}
+
+	vars := &varReader{r: bufio.NewReader(fo.reader(src)), flavor: buildFlavor, version: buildVersion, failVersion: fo.failMissingVersion}
+	switch {
+	case shouldProcess:
+		var inc *includer
+		if fo.resolveIncludes {
+			inc = newIncluder(srcPath, buildFlavor, buildVersion)
+		}
+		if err := processLines(fo.context(), newLineScanner(vars), writer, srcPath, buildFlavor, fo.warn, inc); err != nil {
+			return false, err
+		}
+	case canProcess:
+		if _, err := io.Copy(writer, vars); err != nil {
+			if errors.Is(err, ErrMissingVersion) {
+				return false, fmt.Errorf("%s: %w", srcPath, err)
+			}
+			return false, &WriteError{Path: destPath, Err: err}
+		}
+	default:
+		if _, err := io.Copy(writer, fo.reader(src)); err != nil {
+			return false, &WriteError{Path: destPath, Err: err}
+		}
+	}
+
+	if fo.versioned != nil {
+		*fo.versioned = vars.versioned
+	}
+	if fo.noOp != nil {
+		*fo.noOp = canProcess && !shouldProcess && !vars.substituted
+	}
+
+	// write the file to the disk
+	if endings != nil {
+		if err := endings.Flush(); err != nil {
+			return false, &WriteError{Path: destPath, Err: err}
+		}
+	}
+	if err := buffered.Flush(); err != nil {
+		return false, &WriteError{Path: destPath, Err: err}
+	}
+	if err := fo.canceled(); err != nil {
+		return false, err
+	}
+	if err := fw.Commit(); err != nil {
+		return false, &WriteError{Path: destPath, Err: err}
+	}
+	if err := fo.keepTimes(srcPath, destPath); err != nil {
+		return false, &WriteError{Path: destPath, Err: err}
+	}
+	return true, nil
+}
+
+// varReader substitutes the flavor and version variables one line at a time
+type varReader struct {
+	r       *bufio.Reader
+	flavor  string
+	version string
+	pending []byte
+	err     error
+	// versioned is set once a line with the version variable has been read
+	versioned bool
+	// substituted is set once a line with any variable has been read
+	substituted bool
+	// failVersion makes a line with the version variable an error
+	failVersion bool
+}
+
+func (v *varReader) Read(p []byte) (int, error) {
+	for len(v.pending) == 0 {
+		if v.err != nil {
+			return 0, v.err
+		}
+		var line string
+		line, v.err = v.r.ReadString('\n')
+		if strings.Contains(line, versionVar) {
+			v.versioned = true
+			if v.failVersion {
+				v.err = ErrMissingVersion
+				return 0, v.err
+			}
+		}
+		v.substituted = v.substituted || VarRegex.MatchString(line)
+		v.pending = []byte(replaceVars(line, v.flavor, v.version))
+	}
+	n := copy(p, v.pending)
+	v.pending = v.pending[n:]
+	return n, nil
+}
@@ -0,0 +1,50 @@
+package build
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// longLine is longer than the 64 KB a bufio.Scanner takes by default
+var longLine = "var data = '" + strings.Repeat("x", 200*1024) + "';"
+
+func TestBuildLongLines(t *testing.T) {
+	source := "<?php\n" +
+		"// BEGIN SUGARCRM flav=ent ONLY\n" +
+		longLine + "\n" +
+		"// END SUGARCRM flav=ent ONLY\n" +
+		longLine + " // @_SUGAR_FLAV\n"
+	tests := []struct {
+		flavor string
+		want   string
+	}{
+		{"ent", "<?php\n" + longLine + "\n" + longLine + " // ent\n"},
+		{"pro", "<?php\n" + longLine + " // pro\n"},
+	}
+	builders := map[string]func(string, string, string, string) (bool, error){
+		"BuildFile":  BuildFile,
+		"StreamFile": StreamFile,
+	}
+	for name, buildFn := range builders {
+		for _, tt := range tests {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "long.php")
+			dest := filepath.Join(dir, "out", "long.php")
+			if err := ioutil.WriteFile(src, []byte(source), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := buildFn(src, dest, tt.flavor, "7.0"); err != nil {
+				t.Fatalf("%s %s: %v", name, tt.flavor, err)
+			}
+			got, err := ioutil.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("%s %s: built %d bytes, want %d", name, tt.flavor, len(got), len(tt.want))
+			}
+		}
+	}
+}
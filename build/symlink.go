@@ -0,0 +1,142 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkMode decides what happens to symlinks found in the source
+type SymlinkMode string
+
+const (
+	// SymlinksPreserve recreates the symlink in the destination, see linkTarget for where it
+	// points to
+	SymlinksPreserve SymlinkMode = "preserve"
+	// SymlinksDereference builds whatever the symlink points to as real files in the
+	// destination, build tags and variables included
+	SymlinksDereference SymlinkMode = "dereference"
+	// SymlinksCopy copies whatever the symlink points to as real files in the destination
+	// without looking at build tags or variables
+	SymlinksCopy SymlinkMode = "copy"
+	// SymlinksSkip leaves symlinks out of the build
+	SymlinksSkip SymlinkMode = "skip"
+)
+
+// SymlinkModes lists every valid symlink mode
+var SymlinkModes = []SymlinkMode{SymlinksPreserve, SymlinksDereference, SymlinksCopy, SymlinksSkip}
+
+// ParseSymlinkMode validates the name of a symlink mode, an empty name and link, what
+// preserve used to be called, mean preserve
+func ParseSymlinkMode(name string) (SymlinkMode, error) {
+	if name == "" || name == "link" {
+		return SymlinksPreserve, nil
+	}
+	for _, m := range SymlinkModes {
+		if string(m) == name {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unknown symlink mode %q, must be one of %v", name, SymlinkModes)
+}
+
+// recreateLink points dest at target, replacing whatever link an earlier build left there
+func recreateLink(target string, dest string) error {
+	if info, err := os.Lstat(dest); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		os.Remove(dest)
+	}
+	if err := os.Symlink(target, dest); err != nil {
+		return &WriteError{Path: dest, Err: err}
+	}
+	return nil
+}
+
+// linkTarget is where the symlink l is pointed at when it is recreated at dest. A target in one
+// of the sources is pointed at the place it is built to in the destination, relative when the
+// target was relative, so the link keeps working there. A relative target outside the sources
+// is made absolute and any other target is kept as it is.
+func (r *runner) linkTarget(l link, dest string) (string, error) {
+	pointsAt := l.Target
+	if !filepath.IsAbs(pointsAt) {
+		pointsAt = filepath.Join(filepath.Dir(l.Link), pointsAt)
+	}
+	pointsAt, err := filepath.Abs(pointsAt)
+	if err != nil {
+		return l.Target, nil
+	}
+	for _, root := range r.opts.Sources {
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(absRoot, pointsAt)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		targetDest := r.opts.Destination
+		if rel != "." {
+			if _, targetDest, err = destinationPath(r.opts, root, filepath.Join(root, rel)); err != nil {
+				return "", err
+			}
+		}
+		if filepath.IsAbs(l.Target) {
+			return filepath.Abs(targetDest)
+		}
+		return filepath.Rel(filepath.Dir(dest), targetDest)
+	}
+	if !filepath.IsAbs(l.Target) {
+		return pointsAt, nil
+	}
+	return l.Target, nil
+}
+
+// copyLink builds what l points to into dest as real files, a link to a folder has every
+// file under it built. In copy mode the files are copied as they are. The bytes written are
+// added to written.
+func (r *runner) copyLink(l link, dest string, written *int64) (bool, error) {
+	info, err := os.Stat(l.Link)
+	if err != nil {
+		return false, fmt.Errorf("symlink %s points to %s which can not be read: %w", l.Link, l.Target, err)
+	}
+	if !info.IsDir() {
+		return r.copyLinkedFile(file{Root: l.Root, Path: l.Link, Info: info, verbatim: r.opts.Symlinks == SymlinksCopy}, dest, written)
+	}
+
+	// walk through the link itself so the files keep their place under it
+	var built bool
+	err = filepath.Walk(l.Link+string(filepath.Separator), func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.IsDir() {
+			return nil
+		}
+		if f.Mode()&os.ModeSymlink != 0 {
+			r.opts.explainSkip(path, "symlinks inside a copied symlink are not followed")
+			return nil
+		}
+		rel, err := filepath.Rel(l.Link, path)
+		if err != nil {
+			return err
+		}
+		ok, err := r.copyLinkedFile(file{Root: l.Root, Path: path, Info: f, verbatim: r.opts.Symlinks == SymlinksCopy}, filepath.Join(dest, rel), written)
+		built = built || ok
+		return err
+	})
+	return built, err
+}
+
+// copyLinkedFile builds a single file reached through a symlink, following the conflict policy
+func (r *runner) copyLinkedFile(f file, dest string, written *int64) (bool, error) {
+	ok, release, err := r.claims.acquire(dest, f.Info.ModTime())
+	if !ok {
+		return false, err
+	}
+	built := false
+	defer func() { release(built) }()
+	r.acquireFiles()
+	defer r.releaseFiles()
+	built, err = r.buildTimed(f, dest, written, nil)
+	return built, err
+}
@@ -0,0 +1,91 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// endlessLines never runs out of lines, like a file that keeps a worker busy for good
+type endlessLines struct{}
+
+func (endlessLines) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+		if i%80 == 79 {
+			p[i] = '\n'
+		}
+	}
+	return len(p), nil
+}
+
+func TestProcessLinesStopsWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- processLines(ctx, newLineScanner(endlessLines{}), ioutil.Discard, "endless.php", "ent", nil, nil)
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("processLines() = %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("processLines kept going after its context was done")
+	}
+}
+
+func TestBuildContentStopsWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	source := "<?php\n// BEGIN SUGARCRM flav=ent ONLY\n" + strings.Repeat("$a = 1;\n", 1000) + "// END SUGARCRM flav=ent ONLY\n"
+	if _, err := buildContent(ctx, source, "a.php", "ent", "7.0", nil, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("buildContent() = %v, want %v", err, context.Canceled)
+	}
+}
+
+// slowProcessor takes longer than the file timeout of the test on every file
+type slowProcessor struct{ delay time.Duration }
+
+func (slowProcessor) Name() string          { return "slow" }
+func (slowProcessor) Match(rel string) bool { return strings.HasPrefix(rel, "slow") }
+func (p slowProcessor) Process(rel string, content []byte) ([]byte, error) {
+	time.Sleep(p.delay)
+	return content, nil
+}
+
+func TestRunFileTimeout(t *testing.T) {
+	src := writeTree(t, map[string]string{
+		"slow.php": "<?php echo '@_SUGAR_FLAV';\n",
+		"fast.php": "<?php echo '@_SUGAR_FLAV';\n",
+	})
+	opts := testOptions(t, src, "ent")
+	opts.FileTimeout = 20 * time.Millisecond
+	opts.Processors = []Processor{slowProcessor{delay: 200 * time.Millisecond}}
+
+	done := make(chan *Result, 1)
+	go func() { done <- runBuild(t, opts) }()
+	var res *Result
+	select {
+	case res = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the build hung on the slow file")
+	}
+
+	if res.Failed != 1 {
+		t.Fatalf("%d files failed, want 1", res.Failed)
+	}
+	if len(res.Failures) != 1 || res.Failures[0].Operation != "timeout" || !errors.Is(res.Failures[0].Err, ErrTimedOut) {
+		t.Fatalf("failures are %+v, want slow.php timed out", res.Failures)
+	}
+	if got := readBuilt(t, opts.Destination, "slow.php"); got != "<missing>" {
+		t.Errorf("the file that timed out was still written: %q", got)
+	}
+	if got := readBuilt(t, opts.Destination, "fast.php"); got != "<?php echo 'ent';\n" {
+		t.Errorf("fast.php is %q", got)
+	}
+}
@@ -0,0 +1,107 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// warned checks if a warning about a file named base says msg
+func warned(res *Result, base string, msg string) bool {
+	for _, w := range res.Warnings {
+		if filepath.Base(w.Path) == base && strings.Contains(w.Msg, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRunWarnEmpty(t *testing.T) {
+	src := writeTree(t, map[string]string{"empty.php": "", "empty.txt": "", "full.php": "<?php\n"})
+	for _, warnEmpty := range []bool{false, true} {
+		for _, threshold := range []int64{0, 1} {
+			opts := testOptions(t, src, "ent")
+			opts.WarnEmpty = warnEmpty
+			opts.StreamThreshold = threshold
+			res := runBuild(t, opts)
+			if res.Failed != 0 {
+				t.Fatalf("warn empty %v, stream threshold %d: %v", warnEmpty, threshold, res.Errors)
+			}
+			for _, rel := range []string{"empty.php", "empty.txt"} {
+				// empty files are still built
+				if got := readBuilt(t, opts.Destination, rel); got != "" {
+					t.Errorf("warn empty %v, stream threshold %d: %s is %q", warnEmpty, threshold, rel, got)
+				}
+				if warned(res, rel, "is empty") != warnEmpty {
+					t.Errorf("warn empty %v, stream threshold %d: %s warned is %v", warnEmpty, threshold, rel, !warnEmpty)
+				}
+			}
+			if warned(res, "full.php", "is empty") {
+				t.Errorf("warn empty %v, stream threshold %d: full.php is said to be empty", warnEmpty, threshold)
+			}
+		}
+	}
+}
+
+func TestIsUnreadable(t *testing.T) {
+	denied := &os.PathError{Op: "open", Path: "a.php", Err: syscall.EACCES}
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&ReadError{Path: "a.php", Err: denied}, true},
+		{fmt.Errorf("building: %w", &ReadError{Path: "a.php", Err: denied}), true},
+		{&ReadError{Path: "a.php", Err: &os.PathError{Op: "open", Path: "a.php", Err: syscall.EIO}}, false},
+		// only reading is forgiven, a destination that can not be written still fails
+		{&WriteError{Path: "a.php", Err: denied}, false},
+		{denied, false},
+		{errors.New("permission denied"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := isUnreadable(tt.err); got != tt.want {
+			t.Errorf("isUnreadable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRunUnreadable(t *testing.T) {
+	src := writeTree(t, map[string]string{"secret.php": "<?php\n", "open.php": "<?php\n"})
+	secret := filepath.Join(src, "secret.php")
+	if err := os.Chmod(secret, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(secret, 0644)
+	if f, err := os.Open(secret); err == nil {
+		f.Close()
+		t.Skip("files without permissions can still be read, probably running as root")
+	}
+
+	for _, failUnreadable := range []bool{false, true} {
+		for _, threshold := range []int64{0, 1} {
+			opts := testOptions(t, src, "ent")
+			opts.FailUnreadable = failUnreadable
+			opts.StreamThreshold = threshold
+			res := runBuild(t, opts)
+			if got := readBuilt(t, opts.Destination, "open.php"); got != "<?php\n" {
+				t.Errorf("fail unreadable %v, stream threshold %d: open.php is %q", failUnreadable, threshold, got)
+			}
+			if got := readBuilt(t, opts.Destination, "secret.php"); got != "<missing>" {
+				t.Errorf("fail unreadable %v, stream threshold %d: secret.php is %q", failUnreadable, threshold, got)
+			}
+			if failUnreadable {
+				if res.Failed != 1 || !isUnreadable(res.Failures[0].Err) {
+					t.Errorf("stream threshold %d: failed %d with %v, want secret.php to fail", threshold, res.Failed, res.Errors)
+				}
+				continue
+			}
+			if res.Failed != 0 || !warned(res, "secret.php", "permission denied") {
+				t.Errorf("stream threshold %d: failed %d with warnings %v, want only a warning", threshold, res.Failed, res.Warnings)
+			}
+		}
+	}
+}
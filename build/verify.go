@@ -0,0 +1,85 @@
+package build
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// VerifyStatus is how a file in the destination compares to what its source builds now
+type VerifyStatus string
+
+const (
+	// VerifyMissing is a file that is not in the destination at all
+	VerifyMissing VerifyStatus = "missing"
+	// VerifyStale is a file that is as the last build wrote it, but its source changed since
+	VerifyStale VerifyStatus = "stale"
+	// VerifyModified is a file that was changed in the destination after the last build
+	VerifyModified VerifyStatus = "modified"
+	// VerifyDiffers is a file that is different when there is no manifest to tell why
+	VerifyDiffers VerifyStatus = "differs"
+)
+
+// VerifiedFile is a file whose destination does not match what its source builds
+type VerifiedFile struct {
+	Path        string
+	Destination string
+	Status      VerifyStatus
+}
+
+// Verification is the outcome of Verify, Mismatched is sorted by path
+type Verification struct {
+	// Files counts the files that were checked, symlinks and files a FILE tag leaves out are not
+	Files      int
+	Mismatched []VerifiedFile
+	// Result is the dry run the files were checked with, it holds the files that failed to build
+	Result *Result
+}
+
+// Verify builds opts.Sources in memory like a dry run and compares every file to what is in
+// opts.Destination. The manifest the last build left in the destination tells a stale file,
+// whose source changed since, apart from one that was modified in the destination.
+func Verify(ctx context.Context, opts Options) (*Verification, error) {
+	manifest, err := ReadManifest(opts.Destination)
+	if err != nil {
+		return nil, err
+	}
+	v := &Verification{}
+	opts.DryRun = true
+	onResult := opts.OnResult
+	opts.OnResult = func(fr FileResult) {
+		if onResult != nil {
+			onResult(fr)
+		}
+		if fr.Err != nil || fr.Link || fr.Skipped {
+			return
+		}
+		v.Files++
+		if fr.Changed {
+			v.Mismatched = append(v.Mismatched, VerifiedFile{Path: fr.Path, Destination: fr.Destination, Status: verifyStatus(manifest, opts.Destination, fr.Destination)})
+		}
+	}
+	v.Result, err = Run(ctx, opts)
+	sort.Slice(v.Mismatched, func(i, j int) bool { return v.Mismatched[i].Path < v.Mismatched[j].Path })
+	return v, err
+}
+
+// verifyStatus works out why the file at dest under root is not what its source builds
+func verifyStatus(m *Manifest, root string, dest string) VerifyStatus {
+	if _, err := os.Stat(dest); os.IsNotExist(err) {
+		return VerifyMissing
+	}
+	rel, err := filepath.Rel(root, dest)
+	if m == nil || err != nil {
+		return VerifyDiffers
+	}
+	entry, ok := m.Files[filepath.ToSlash(rel)]
+	if !ok || entry.SHA256 == "" {
+		return VerifyDiffers
+	}
+	if sum, err := hashSource(dest); err != nil || sum != entry.SHA256 {
+		return VerifyModified
+	}
+	return VerifyStale
+}
@@ -0,0 +1,52 @@
+package build
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Warning is something found during a build that did not fail a file but is probably a
+// mistake in the source. These are the conditions that are warnings:
+//
+//   - a file or folder under a source could not be read, so it was left out
+//   - a symlink points to something that does not exist
+//   - a BEGIN or FILE tag with a condition on something other than flav or a define, its value
+//     is used as the flavor
+type Warning struct {
+	Path string
+	// Line is zero when the warning is not about a line in the file
+	Line int
+	Msg  string
+}
+
+func (w Warning) String() string {
+	if w.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", w.Path, w.Line, w.Msg)
+	}
+	return fmt.Sprintf("%s: %s", w.Path, w.Msg)
+}
+
+// warnFunc is told about warnings found on a line of a file, it may be nil
+type warnFunc func(line int, msg string)
+
+func (w warnFunc) warn(line int, msg string) {
+	if w != nil {
+		w(line, msg)
+	}
+}
+
+// tagWarning checks a build tag for things that are allowed but probably not meant
+func tagWarning(kind string, condition string) string {
+	switch kind {
+	case "ELSE", "END":
+		return ""
+	}
+	matches := conditionRegex.FindStringSubmatch(strings.TrimSpace(condition))
+	if matches == nil {
+		return ""
+	}
+	if _, defined := Defines[strings.ToLower(matches[1])]; !strings.EqualFold(matches[1], "flav") && !defined {
+		return fmt.Sprintf("%s tag has a condition on %s instead of flav, %q is used as the flavor", kind, matches[1], getTagFlavor(condition))
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/jwhitcraft/rome/build"
+	"github.com/spf13/cobra"
+)
+
+var shippingFlavors []string
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit [FLAGS] SOURCE-FOLDER",
+	Short: "Report build tags that no shipping flavor uses",
+	Long: `Scans every file in the source for build tags, counts how often each condition is used and
+lists the tag blocks that none of the shipping flavors would ever keep.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			fmt.Println("A single SOURCE-FOLDER is required")
+			os.Exit(1)
+		}
+		for _, f := range shippingFlavors {
+			if _, ok := build.Flavors[f]; !ok {
+				fmt.Printf("Unknown shipping flavor: %s\n", f)
+				os.Exit(1)
+			}
+		}
+
+		report, err := build.Audit(args[0], shippingFlavors)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		conditions := make([]string, 0, len(report.Conditions))
+		for condition := range report.Conditions {
+			conditions = append(conditions, condition)
+		}
+		sort.Strings(conditions)
+		fmt.Printf("Scanned %d files, build tag conditions used:\n", report.Files)
+		for _, condition := range conditions {
+			fmt.Printf("  %-30s %d\n", condition, report.Conditions[condition])
+		}
+
+		if len(report.Dead) == 0 {
+			fmt.Println("No dead build tags found")
+			return
+		}
+		fmt.Printf("\n%d build tags can never be selected by %v:\n", len(report.Dead), shippingFlavors)
+		for _, dead := range report.Dead {
+			fmt.Printf("  %s:%d %s SUGARCRM %s\n", dead.Path, dead.Line, dead.Kind, dead.Condition)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(auditCmd)
+
+	auditCmd.Flags().StringSliceVar(&shippingFlavors, "shipping", []string{"pro", "corp", "ent", "ult"}, "The flavors that are actually shipped")
+}
@@ -21,67 +21,524 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/spf13/cobra"
-	"sync"
-	"strings"
 	"os"
-	"path"
-	"time"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
 	"github.com/jwhitcraft/rome/utils"
 	"github.com/jwhitcraft/rome/build"
 )
 
 var (
-	flavor string
+	flavor string = "ent"
+	flavorMap []string
+	// defines are the key=value tags --define adds for the build tags to check
+	defines []string
+	// tagFlavor is the canonical flavor that flavor maps to, it is what the build tags see
+	tagFlavor string
 	version string
 	destination string
-	source string
+	sink build.Sink
+	maxUploads int
+	sources []string
 
 	clean bool = false
+	assumeYes bool
+	strict bool
+	warningsAsErrors bool
 
 	fileWorkers int = 40
 	fileBufferSize int = 4096
 
 	linkWorkers int = 5
 	linkBufferSize int = 2048
+
+	walkWorkers int
+	// workersMode is auto to let the build size and ramp the workers itself
+	workersMode string
+	symlinkModeName string
+	symlinkMode build.SymlinkMode
+
+	junitPath string
+	junitVerbose bool
+	summaryPath string
+	summaryDepth int
+	tracePath string
+	indexPath string
+	writeManifest bool
+	sinceVersion bool
+	provenancePath string
+	previousManifest *build.Manifest
+	useCache bool
+	buildCache *build.Cache
+	maxErrorsShown int
+	maxFailures int
+	failFast bool
+
+	maxInflightBytes int64
+	maxOpenFiles int
+	streamThreshold int64
+	verifyAfter bool
+	lineEndings string
+	lineEndingMode build.LineEndings
+	stripBOM bool
+	profile string
+	reportNoOp bool
+	warnEmpty bool
+	dryRun bool
+	showDiff bool
+	nameOnly bool
+	resolveIncludes bool
+	onMissingVersion string
+	missingVersionPolicy build.MissingVersionPolicy
+
+	gzipExtensions []string
+	gzipMinSize int64
+
+	explainSkips bool
+	useTUI bool
+	noProgress bool
+	noIgnoreFile bool
+	outputFormat string
+	// jsonStdout is the real stdout with --output json, os.Stdout points to stderr then
+	jsonStdout *os.File
+	checkpointPath string
+	resumePath string
+	resumeJournal bool
+	failuresOut string
+	retryFrom string
+	retryPaths []string
+	deadline time.Duration
+	fileTimeout time.Duration
+	toStdout bool
+
+	includes []string
+	excludes []string
+	includeFrom []string
+	excludeFrom []string
+	fileFilter *build.Filter
+	onlyChangedSince string
+	excludeLargerThan string
+	excludeSmallerThan string
+	maxFileSize int64
+	maxDepth int
+	onlySymlinks bool
+	noSymlinks bool
+	minFileSize int64
+
+	outputUmask string
+	fileModeFlag string
+	dirModeFlag string
+	fileMode os.FileMode
+	dirMode os.FileMode
+	preserveMode bool
+	preserveTimes bool
+	linkIdentical bool
+	minify bool
+	lintPHP string
+	reflink string
+	reflinkMode build.ReflinkMode
+
+	flatten bool
+	renames []string
+	renameRules []build.RenameRule
+
+	onConflict string
+	conflictPolicy build.ConflictPolicy
+
+	metricsAddr string
+	metricsLinger time.Duration
+
+	preBuildHook string
+	postBuildHook string
+	alwaysRunHooks bool
 )
 
-type File string
-type Link struct {
-	Link string
-	Target string
-}
+// exit codes used by the build command
+const (
+	exitBuildFailed = 1
+	exitHookFailed  = 3
+	exitTimedOut    = 4
+	exitNoSpace     = 5
+	exitInterrupted = 130
+)
 
 // buildCmd represents the build command
 var buildCmd = &cobra.Command{
-	Use:   "build [FLAGS] SOURCE-FOLDER",
+	Use:   "build [FLAGS] SOURCE-FOLDER...",
 	Short: "Build SugarCRM",
 	ValidArgs: []string{"source"},
 	Long: `This will take a source version of Sugar and substitute out all the necessary build tags and create an
-	installable copy of Sugar for you to use and dev on.`,
+	installable copy of Sugar for you to use and dev on.
+
+	Defaults for any flag, like flavor, version and destination, can be set in the build section of a
+	.rome.yaml in the first SOURCE-FOLDER or the home directory, flags on the command line still win.
+	The pre_build and post_build keys of its hooks section are the shell commands for --pre-build and
+	--post-build, they run with ROME_SOURCE, ROME_DESTINATION and ROME_FLAVOR set. Its processors
+	section lists programs or Go plugins that transform the files they match once they are built.
+	--minify minifies the .js and .css files before them.
+
+	A .romeignore in the root of a SOURCE-FOLDER lists what is never built from it, one gitignore
+	style pattern per line. --exclude and --include still apply on top of it.
+
+	Several flavors are built at once with --flavor ent,pro,ult or more than one -f. The destination
+	needs {flavor} in it, like /builds/{flavor}, and the sources are only walked once with every file
+	handed to the workers of each flavor, which each get their own --file-workers.
+
+	--define adds tags of your own for the build tags to check like flav: with --define feature=newdash
+	a BEGIN SUGARCRM feature=newdash ONLY block is kept, and feature in (newdash, olddash) or
+	feature!=newdash work too. Put them in the define list of the build section to always use them.
+
+	Besides @_SUGAR_VERSION and @_SUGAR_FLAV, @_SUGAR_BUILD_SHA, @_SUGAR_BUILD_DATE and @_SUGAR_BUILDER
+	are replaced with where the build comes from, see --build-sha, --build-date and --builder.`,
 	PreRun: func(cmd *cobra.Command, args[]string) {
 		// in the preRun, make sure that the source and destination exists
-		source = args[0]
+		if len(args) == 0 {
+			fmt.Println("At least one SOURCE-FOLDER is required")
+			os.Exit(1)
+		}
+
+		if err := readSourceConfig(args[0]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if profile != "" {
+			if err := applyProfile(cmd, profile); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		if err := applyConfigDefaults(cmd); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := applyHookConfig(cmd); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		switch outputFormat {
+		case "text":
+		case "json":
+			if toStdout || dryRun {
+				fmt.Println("--output json can not be used with --stdout or --dry-run")
+				os.Exit(1)
+			}
+			// everything else the build prints goes to stderr so stdout only has the result
+			jsonStdout = os.Stdout
+			os.Stdout = os.Stderr
+		default:
+			fmt.Printf("--output must be text or json, not %q\n", outputFormat)
+			os.Exit(1)
+		}
+
+		buildFlavors = nil
+		if names := splitFlavors(flavor); len(names) > 1 {
+			if err := checkFlavors(names); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			buildFlavors = names
+		}
+
+		var err error
+		if buildFlavors == nil {
+			tagFlavor, err = mapFlavor(flavor, flavorMap)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		if err := build.Define(defines); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		setBuildInfo(args[0])
+
+		if toStdout {
+			// only a single file is built and nothing touches the destination
+			if len(args) != 1 {
+				fmt.Println("--stdout only works with a single SOURCE file")
+				os.Exit(1)
+			}
+			info, err := os.Stat(args[0])
+			if err != nil {
+				fmt.Printf("\n\nSource Path (%s) does not exists!!\n\n", args[0])
+				os.Exit(401)
+			}
+			if !info.Mode().IsRegular() {
+				fmt.Printf("--stdout needs SOURCE (%s) to be a regular file\n", args[0])
+				os.Exit(1)
+			}
+			return
+		}
+
+		if err := validateWorkers(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if processors, err = loadProcessors(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if minify {
+			// minified first so what the processors of the config file add, like headers, is kept
+			processors = append([]build.Processor{build.Minifier()}, processors...)
+		}
+		switch workersMode {
+		case "":
+		case "auto":
+			// the pools that were not given a number are the ones that are tuned
+			if !cmd.Flags().Changed("file-workers") {
+				fileWorkers = 0
+			}
+			if !cmd.Flags().Changed("symlink-workers") {
+				linkWorkers = 0
+			}
+		default:
+			fmt.Printf("--workers only takes auto, not %q, use --file-workers and --symlink-workers for a fixed number\n", workersMode)
+			os.Exit(1)
+		}
+		if onlySymlinks && noSymlinks {
+			fmt.Println("--only-symlinks and --no-symlinks can not be used together")
+			os.Exit(1)
+		}
+		if maxFailures < 0 {
+			fmt.Println("--max-failures can not be negative")
+			os.Exit(1)
+		}
+		if failFast {
+			if maxFailures > 1 {
+				fmt.Println("--fail-fast stops on the first failure, it can not be used with --max-failures")
+				os.Exit(1)
+			}
+			maxFailures = 1
+		}
+		if maxOpenFiles < 0 {
+			fmt.Println("--max-open-files can not be negative")
+			os.Exit(1)
+		}
+		if maxOpenFiles == 0 {
+			maxOpenFiles = defaultMaxOpenFiles()
+		}
+		if fileMode, dirMode, err = outputModes(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 
+		if dryRun && clean {
+			fmt.Println("--dry-run compares against the destination, it can not be used with --clean")
+			os.Exit(1)
+		}
+		if lintPHP != "" {
+			if dryRun {
+				fmt.Println("--lint-php lints the files written to the destination, it can not be used with --dry-run")
+				os.Exit(1)
+			}
+			// a missing php would fail every PHP file, so it is looked for before building
+			if lintPHP, err = exec.LookPath(lintPHP); err != nil {
+				fmt.Printf("--lint-php: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		sink = nil
+		if build.IsRemote(destination) {
+			if clean || dryRun || sinceVersion || provenancePath != "" {
+				fmt.Println("--clean, --dry-run, --since-version and --provenance need a local destination")
+				os.Exit(1)
+			}
+			sink, err = build.OpenSink(destination, maxUploads)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			// there is no manifest or stamp to read from or write to a remote destination
+			writeManifest = false
+		}
 		destExists, err := exists(destination)
-		if err != nil || !destExists {
+		if (err != nil || !destExists) && !dryRun && sink == nil && buildFlavors == nil {
 			fmt.Printf("Destination Path (%s) does not exists, Creating Now\n", destination)
-			os.MkdirAll(destination, 0775)
+			build.MakeDirs(destination, dirMode)
 			// since we had to create the destination dir, set clean to false
 			clean = false
 		}
 
-		sourceExists, err := exists(source)
-		if err != nil || !sourceExists {
-			fmt.Printf("\n\nSource Path (%s) does not exists!!\n\n", source)
+		sources, err = expandSources(args)
+		if err != nil {
+			fmt.Printf("\n\n%v!!\n\n", err)
 			os.Exit(401)
 		}
+
+		fileFilter, err = buildFilter()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if maxFileSize, err = parseSizeFlag("exclude-larger-than", excludeLargerThan); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if minFileSize, err = parseSizeFlag("exclude-smaller-than", excludeSmallerThan); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if (resumePath != "" || resumeJournal) && clean {
+			fmt.Println("--resume and --resume-journal leave out files that are already built, they can not be used with --clean")
+			os.Exit(1)
+		}
+		if resumePath != "" && resumeJournal {
+			fmt.Println("--resume FILE and --resume-journal pick different files to resume from, use one of them")
+			os.Exit(1)
+		}
+		retryPaths = nil
+		if retryFrom != "" {
+			if clean {
+				fmt.Println("--retry-from only builds the files that failed, it can not be used with --clean")
+				os.Exit(1)
+			}
+			retryPaths, err = build.ReadFailures(retryFrom)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			if len(retryPaths) == 0 {
+				fmt.Printf("No failed files in %s, nothing to retry\n", retryFrom)
+				os.Exit(0)
+			}
+		}
+		if onlyChangedSince != "" {
+			if changed := changedFiles(sources, onlyChangedSince); changed != nil {
+				fileFilter = fileFilter.LimitTo(changed)
+			}
+		}
+
+		if flatten && len(renames) > 0 {
+			fmt.Println("--flatten and --rename can not be used together")
+			os.Exit(1)
+		}
+		renameRules = nil
+		for _, r := range renames {
+			rule, err := build.ParseRenameRule(r)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			renameRules = append(renameRules, rule)
+		}
+
+		conflictPolicy, err = build.ParseConflictPolicy(onConflict)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		lineEndingMode, err = build.ParseLineEndings(lineEndings)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		missingVersionPolicy, err = build.ParseMissingVersionPolicy(onMissingVersion)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		reflinkMode, err = build.ParseReflinkMode(reflink)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		symlinkMode, err = build.ParseSymlinkMode(symlinkModeName)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if !clean && sink == nil && buildFlavors == nil {
+			checkStamp(destination, flavor)
+		}
+		// both need the manifest, so they write one unless told not to
+		if (sinceVersion || provenancePath != "") && !cmd.Flags().Changed("manifest") {
+			writeManifest = true
+		}
+		if sinceVersion && (clean || !writeManifest) {
+			fmt.Println("--since-version needs the manifest of the last build, it can not be used with --clean or --manifest=false")
+			os.Exit(1)
+		}
+		if provenancePath != "" && !writeManifest {
+			fmt.Println("--provenance records the hash of the manifest, it can not be used with --manifest=false")
+			os.Exit(1)
+		}
+		buildCache = nil
+		if useCache {
+			if sink != nil || dryRun || resolveIncludes {
+				fmt.Println("--cache needs a local destination, it can not be used with --dry-run or --resolve-includes")
+				os.Exit(1)
+			}
+			buildCache, err = build.ReadCache(destination)
+			if err != nil {
+				fmt.Printf("Could Not Read Build Cache: %v\n", err)
+			}
+			if buildCache == nil || clean {
+				buildCache = build.NewCache()
+			}
+		}
+		// read before --clean gets a chance to delete it
+		previousManifest = nil
+		if writeManifest && buildFlavors == nil {
+			previousManifest, err = build.ReadManifest(destination)
+			if err != nil {
+				fmt.Printf("Could Not Read Previous Manifest: %v\n", err)
+			}
+		}
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		if toStdout {
+			built, err := build.BuildToWriter(args[0], os.Stdout, tagFlavor, version)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(exitBuildFailed)
+			}
+			if !built {
+				fmt.Fprintf(os.Stderr, "%s is excluded from the %s flavor by its FILE tag\n", args[0], flavor)
+			}
+			return
+		}
+		if dryRun {
+			runDryRun()
+			return
+		}
+		if buildFlavors != nil {
+			runFlavors()
+			return
+		}
+		if preBuildHook != "" {
+			if err := runHook("pre-build", preBuildHook, hookEnv(0)); err != nil {
+				fmt.Println(err)
+				os.Exit(exitHookFailed)
+			}
+		}
 		if clean {
+			ok, readErr := confirmDelete(destination, assumeYes)
+			if readErr != nil {
+				fmt.Printf("Could Not Read %s: %v\n", destination, readErr)
+				os.Exit(1)
+			}
+			if !ok {
+				fmt.Println("Not cleaning " + destination + ", aborting")
+				os.Exit(1)
+			}
 			fmt.Println("Cleaning " + destination)
 			err := build.CleanBuild(destination)
 			if err != nil {
@@ -89,71 +546,462 @@ var buildCmd = &cobra.Command{
 				os.Exit(1)
 			}
 		}
-		source = args[0]
-		fmt.Println("Starting Rome on " + source + "...")
-		defer utils.TimeTrack(time.Now())
-		var builtFiles utils.Counter
-		files := make(chan File, fileBufferSize)
-		links := make(chan Link, linkBufferSize)
-		quit := make(chan bool)
-		var wg sync.WaitGroup
-		var linkWg sync.WaitGroup
-
-		// spawn 5 workers
-		for i := 0; i < fileWorkers; i++ {
-			wg.Add(1)
-			go fileWorker(files, quit, &wg)
+		fmt.Println("Starting Rome on " + strings.Join(sources, ", ") + "...")
+		start := time.Now()
+		var handlers []func(build.FileResult)
+		var junit *junitReport
+		if junitPath != "" {
+			junit = newJunitReport(junitVerbose)
+			handlers = append(handlers, junit.Add)
 		}
-
-		for i := 0; i < linkWorkers; i++ {
-			linkWg.Add(1)
-			go linkWorker(links, quit, &linkWg)
+		var metrics *buildMetrics
+		stopMetrics := func() {}
+		if metricsAddr != "" {
+			metrics = newBuildMetrics()
+			handlers = append(handlers, metrics.Add)
+			metricsCtx, cancel := context.WithCancel(context.Background())
+			done := serveMetrics(metricsCtx, metricsAddr, metrics)
+			stopMetrics = func() {
+				cancel()
+				<-done
+			}
 		}
-
-		filepath.Walk(source, func(path string, f os.FileInfo, err error) error {
-			// ignore the node_modules dir in the root, but lead sidecar
-			if f.Name() == "node_modules" && strings.Contains(path, "sugarcrm/node_modules") {
-				return filepath.SkipDir
+		var modules *moduleSummaries
+		if summaryDepth > 0 {
+			modules = newModuleSummaries(summaryDepth)
+			handlers = append(handlers, modules.Add)
+		}
+		var noOps []string
+		if reportNoOp {
+			handlers = append(handlers, func(r build.FileResult) {
+				if r.NoOp && r.Err == nil {
+					noOps = append(noOps, r.Path)
+				}
+			})
+		}
+		var manifest *manifestRecorder
+		if writeManifest {
+			manifest = newManifestRecorder(destination, previousManifest)
+			handlers = append(handlers, manifest.Add)
+		}
+		var failures []string
+		if failuresOut != "" {
+			handlers = append(handlers, func(r build.FileResult) {
+				if r.Err != nil {
+					failures = append(failures, r.Path)
+				}
+			})
+		}
+		var index *buildIndex
+		if indexPath != "" {
+			index = &buildIndex{}
+			handlers = append(handlers, index.Add)
+		}
+		var trace *traceWriter
+		if tracePath != "" {
+			var traceErr error
+			trace, traceErr = newTraceWriter(tracePath, start)
+			if traceErr != nil {
+				fmt.Printf("Could Not Create Trace (%s): %v\n", tracePath, traceErr)
+				os.Exit(1)
+			}
+			handlers = append(handlers, trace.Add)
+		}
+		// a local build keeps a journal of the files it finished in the destination until it
+		// is done, so a build that dies part way through can be picked up with --resume-journal
+		var journal string
+		if sink == nil {
+			journal = filepath.Join(destination, build.JournalFile)
+		}
+		if resumeJournal {
+			if journal == "" {
+				fmt.Println("--resume-journal needs a local destination")
+				os.Exit(1)
+			}
+			resumePath = journal
+			if _, statErr := os.Stat(journal); os.IsNotExist(statErr) {
+				fmt.Printf("No journal in %s to resume from, building everything\n", destination)
+				resumePath = ""
+			}
+		}
+		var completed map[string]bool
+		if resumePath != "" {
+			var loadErr error
+			completed, loadErr = build.LoadCheckpoint(resumePath)
+			if loadErr != nil {
+				fmt.Printf("Could Not Read Checkpoint (%s): %v\n", resumePath, loadErr)
+				os.Exit(1)
+			}
+			fmt.Printf("Resuming from %s, %d files are already built\n", resumePath, len(completed))
+			if checkpointPath == "" {
+				// keep adding to the checkpoint that is being resumed
+				checkpointPath = resumePath
+			}
+		}
+		if checkpointPath == "" && journal != "" {
+			// a journal left behind by an older build says nothing about this one
+			os.Remove(journal)
+			checkpointPath = journal
+		}
+		var checkpoint *build.Checkpoint
+		if checkpointPath != "" {
+			var openErr error
+			checkpoint, openErr = build.OpenCheckpoint(checkpointPath)
+			if openErr != nil {
+				fmt.Printf("Could Not Open Checkpoint (%s): %v\n", checkpointPath, openErr)
+				os.Exit(1)
 			}
-			if !f.IsDir() {
-				builtFiles.Increment()
-				// handle symlinks differently than normal files
-				if f.Mode()&os.ModeSymlink != 0 {
-					originFile, _ := os.Readlink(path)
-					links <- Link{Link: path, Target: originFile}
-				} else {
-					files <- File(path)
+			handlers = append(handlers, checkpoint.Add)
+		}
+		// kill -USR1 prints what the build is up to
+		progress := &build.Progress{}
+		stopStats := dumpStatsOnSignal(progress)
+		var onStart func(string)
+		var tui *buildTUI
+		if useTUI && isTerminal(os.Stdout) {
+			tui = newBuildTUI(os.Stdout)
+			handlers = append(handlers, tui.Add)
+			onStart = tui.Started
+			tui.Start()
+		}
+		// the progress bar goes to stderr so it stays out of anything piping stdout
+		var bar *progressBar
+		if !noProgress && tui == nil && !verbose && isTerminal(os.Stderr) {
+			if retryPaths != nil {
+				progress.SetTotal(len(retryPaths))
+			} else {
+				scanTotal(progress, build.Options{Sources: sources, WalkWorkers: walkWorkers, Filter: fileFilter, IgnoreFiles: !noIgnoreFile, MaxDepth: maxDepth + 1, OnlySymlinks: onlySymlinks, NoSymlinks: noSymlinks})
+			}
+			bar = newProgressBar(os.Stderr, progress)
+			bar.Start()
+		}
+		var skippedFiles []string
+		if jsonStdout != nil {
+			handlers = append(handlers, func(r build.FileResult) {
+				if r.Skipped {
+					skippedFiles = append(skippedFiles, r.Path)
 				}
+			})
+		}
+		onResult := func(r build.FileResult) {
+			for _, handler := range handlers {
+				handler(r)
 			}
-			return nil
-		})
-
-		// end of tasks. the workers should quit afterwards
-		close(files)
-		close(links)
-		// use "close(quit)", if you do not want to wait for the remaining tasks
-
-		// wait for all workers to shut down properly
-		wg.Wait()
-		linkWg.Wait()
+		}
+		// Ctrl-C and the deadline both stop new files from starting, anything being written is finished
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if deadline > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, deadline)
+			defer cancel()
+		}
+		// a remote destination only names the sink, paths in it are relative to its root
+		buildDestination := destination
+		if sink != nil {
+			buildDestination = ""
+		}
+		opts := buildOptions()
+		opts.Destination = buildDestination
+		opts.Completed = completed
+		opts.Progress = progress
+		opts.OnStart = onStart
+		opts.OnResult = onResult
+		result, err := build.Run(ctx, opts)
+		if closeErr := build.CloseProcessors(processors); closeErr != nil {
+			fmt.Printf("Could Not Close Processors: %v\n", closeErr)
+		}
+		stopStats()
+		if tui != nil {
+			tui.Stop()
+		}
+		if bar != nil {
+			bar.Stop()
+		}
+		if trace != nil {
+			if closeErr := trace.Close(); closeErr != nil {
+				fmt.Printf("Could Not Write Trace (%s): %v\n", tracePath, closeErr)
+			}
+		}
+		if checkpoint != nil {
+			if closeErr := checkpoint.Close(); closeErr != nil {
+				fmt.Printf("Could Not Write Checkpoint (%s): %v\n", checkpointPath, closeErr)
+			}
+		}
+		// a cancelled build still has a result with how far it got
+		stopped := err != nil && result != nil
+		if err != nil && !stopped {
+			if errors.Is(err, build.ErrSourceMissing) {
+				fmt.Printf("\n\n%v!!\n\n", err)
+				os.Exit(401)
+			}
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if metrics != nil {
+			metrics.ObserveBuild(result.Elapsed)
+		}
 
-		fmt.Printf("Built %d files", builtFiles.Get())
+		fmt.Printf("Built %d files", result.Built)
+		utils.TimeTrack(start)
+		if result.Resumed > 0 {
+			fmt.Printf("Left out %d files that were already built\n", result.Resumed)
+		}
+		if result.Cached > 0 {
+			fmt.Printf("Left out %d files that did not change since the last build\n", result.Cached)
+		}
+		if result.LinksSkipped > 0 {
+			fmt.Printf("Skipped %d symlinks\n", result.LinksSkipped)
+		}
+		if onlySymlinks {
+			fmt.Printf("Left out %d regular files because of --only-symlinks\n", result.FilesSkipped)
+		}
+		if result.Empty > 0 {
+			fmt.Printf("Built %d empty files\n", result.Empty)
+		}
+		if result.SkippedBySize > 0 {
+			fmt.Printf("Skipped %d files because of their size\n", result.SkippedBySize)
+		}
+		if result.PrunedByDepth > 0 {
+			fmt.Printf("Did not walk into %d folders deeper than --max-depth %d\n", result.PrunedByDepth, maxDepth)
+		}
+		printMissingVersion(result.MissingVersion)
+		if modules != nil {
+			modules.Print()
+		}
+		if reportNoOp {
+			sort.Strings(noOps)
+			fmt.Printf("%d files had no build tags or variables, a plain copy would do:\n", len(noOps))
+			for _, path := range noOps {
+				fmt.Printf("  %s\n", path)
+			}
+		}
+		fmt.Printf("Wrote %d bytes, skipped %d bytes already in the destination\n", result.BytesWritten, result.BytesSkipped)
+		if c := result.Conflicts; c.Overwritten+c.Skipped+c.Errored > 0 {
+			fmt.Printf("Conflicts: %d overwritten, %d skipped, %d errored\n", c.Overwritten, c.Skipped, c.Errored)
+		}
+		if junit != nil {
+			if err := junit.Write(junitPath, result.Elapsed); err != nil {
+				fmt.Printf("Could Not Write JUnit Report (%s): %v\n", junitPath, err)
+			}
+		}
+		// with --warnings-as-errors any warning fails the build, but only once everything is reported
+		failed := result.Failed > 0 || (warningsAsErrors && len(result.Warnings) > 0)
+		if !failed && !stopped && sink == nil {
+			// absolute sources keep the stamp usable by rebuild from any folder
+			stampSources := make([]string, len(sources))
+			for i, source := range sources {
+				stampSources[i] = absPath(source)
+			}
+			stamp := build.Stamp{Flavor: flavor, Version: version, Sources: stampSources, Built: time.Now()}
+			if err := build.WriteStamp(destination, stamp); err != nil {
+				fmt.Printf("Could Not Write Build Stamp: %v\n", err)
+			}
+			// there is nothing left to resume
+			if checkpointPath == journal {
+				os.Remove(journal)
+			}
+		}
+		if result.Cache != nil && !stopped {
+			if err := build.WriteCache(destination, result.Cache); err != nil {
+				fmt.Printf("Could Not Write Build Cache: %v\n", err)
+			}
+		}
+		if manifest != nil && !stopped {
+			if err := build.WriteManifest(destination, manifest.current); err != nil {
+				fmt.Printf("Could Not Write Manifest: %v\n", err)
+			}
+			if previousManifest != nil {
+				diff := build.DiffManifests(previousManifest, manifest.current)
+				fmt.Printf("Since the last build: %d added, %d removed, %d changed\n", len(diff.Added), len(diff.Removed), len(diff.Changed))
+			}
+		}
+		if provenancePath != "" && !failed && !stopped {
+			if err := writeProvenance(provenancePath, start, manifest.current); err != nil {
+				fmt.Printf("Could Not Write Provenance (%s): %v\n", provenancePath, err)
+			}
+		}
+		// written even when the build was stopped so nothing that failed is lost
+		if failuresOut != "" {
+			if err := build.WriteFailures(failuresOut, failures); err != nil {
+				fmt.Printf("Could Not Write Failures (%s): %v\n", failuresOut, err)
+			}
+		}
+		if index != nil {
+			if err := index.Write(indexPath); err != nil {
+				fmt.Printf("Could Not Write Index (%s): %v\n", indexPath, err)
+			}
+		}
+		if summaryPath != "" {
+			if err := writeSummary(summaryPath, result, modules.Sorted()); err != nil {
+				fmt.Printf("Could Not Write Summary (%s): %v\n", summaryPath, err)
+			}
+		}
+		if jsonStdout != nil {
+			summary := newBuildSummary(flavor, version, result)
+			summary.Destination = destination
+			summary.Modules = modules.Sorted()
+			sort.Strings(skippedFiles)
+			summary.SkippedFiles = skippedFiles
+			if stopped {
+				summary.Stopped = err.Error()
+			}
+			if encodeErr := json.NewEncoder(jsonStdout).Encode(summary); encodeErr != nil {
+				fmt.Printf("Could Not Write JSON Output: %v\n", encodeErr)
+			}
+		}
+		if metrics != nil && metricsLinger > 0 {
+			// give Prometheus a chance at a final scrape before we go away
+			fmt.Printf("Serving metrics on %s for %s\n", metricsAddr, metricsLinger)
+			time.Sleep(metricsLinger)
+		}
+		stopMetrics()
+		if result.Failed > 0 {
+			reportErrors(result.Failures, maxErrorsShown)
+		}
+		if len(result.Warnings) > 0 {
+			fmt.Printf("%d warnings\n", len(result.Warnings))
+			if warningsAsErrors {
+				fmt.Println("Failing the build because of --warnings-as-errors")
+			}
+		}
+		if postBuildHook != "" && ((!failed && !stopped) || alwaysRunHooks) {
+			if err := runHook("post-build", postBuildHook, hookEnv(result.Built)); err != nil {
+				fmt.Println(err)
+				os.Exit(exitHookFailed)
+			}
+		}
+		if stopped {
+			if errors.Is(err, build.ErrTooManyFailures) && failFast {
+				fmt.Printf("Aborted on the first failure because of --fail-fast, %d files were built before stopping\n", result.Built-result.Failed)
+				os.Exit(exitBuildFailed)
+			}
+			if errors.Is(err, build.ErrTooManyFailures) {
+				fmt.Printf("Aborted after %d failures, %d files were built before stopping\n", maxFailures, result.Built-result.Failed)
+				os.Exit(exitBuildFailed)
+			}
+			if errors.Is(err, build.ErrNoSpace) {
+				fmt.Printf("Destination (%s) ran out of disk space after %d files, stopped the build\n", destination, result.Built-result.Failed)
+				os.Exit(exitNoSpace)
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				fmt.Printf("Timed out after %s, %d files were built before stopping\n", deadline, result.Built)
+				os.Exit(exitTimedOut)
+			}
+			fmt.Printf("Interrupted, %d files were built before stopping\n", result.Built)
+			os.Exit(exitInterrupted)
+		}
+		if failed {
+			os.Exit(exitBuildFailed)
+		}
 	},
 }
 
 func init() {
 	RootCmd.AddCommand(buildCmd)
 
-	buildCmd.Flags().StringVarP(&destination,"destination", "d", "", "Where should the built files be put")
+	buildCmd.Flags().StringVar(&profile, "profile", "", "Use the flags of this profile from the profiles section of ~/.rome.yaml, flags on the command line still win")
+	buildCmd.Flags().StringVarP(&destination,"destination", "d", "", "Where should the built files be put, a folder or s3://bucket/prefix")
+	buildCmd.Flags().IntVar(&maxUploads, "max-uploads", 8, "Number of files sent to a remote destination at once")
 	buildCmd.Flags().StringVarP(&version, "version", "v", "","What Version is being built")
-	buildCmd.Flags().StringVarP(&flavor, "flavor", "f", "ent","What Flavor of SugarCRM to build")
+	buildCmd.Flags().VarP(&flavorsValue{value: &flavor}, "flavor", "f", "What Flavor of SugarCRM to build, several can be given as ent,pro,ult or with more than one -f")
 	buildCmd.Flags().BoolVar(&clean, "clean", false, "Remove Existing Build Before Building")
+	buildCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Do not ask before --clean deletes an existing build")
+	buildCmd.Flags().StringSliceVar(&flavorMap, "flavor-map", nil, "Alias flavor names to the SugarCRM flavor their build tags use, e.g. whitelabelA=ent,whitelabelB=pro")
+	addBuildInfoFlags(buildCmd)
+	buildCmd.Flags().StringSliceVar(&defines, "define", nil, "Define a tag for BEGIN and FILE conditions to check besides flav, e.g. feature=newdash,license=aws")
+	buildCmd.Flags().BoolVar(&strict, "strict", false, "Fail instead of warning when the destination holds a build of another flavor or version, a source file can not be read or two files only differ in case")
+	buildCmd.Flags().BoolVar(&warnEmpty, "warn-empty", false, "Warn about every zero byte source file, they usually mean a broken checkout")
+	buildCmd.Flags().BoolVar(&warningsAsErrors, "warnings-as-errors", false, "Fail the build on any warning: unreadable files or folders, broken symlinks, tag conditions on something other than flav or a --define and a destination holding another flavor or version")
 
-	buildCmd.Flags().IntVar(&fileWorkers, "file-workers", 40, "Number of Workers to start for processing files")
+	buildCmd.Flags().IntVar(&fileWorkers, "file-workers", 40, "Number of Workers to start for processing files (0 for one per CPU)")
 	buildCmd.Flags().IntVar(&fileBufferSize, "file-buffer-size", 4096, "Size of the file buffer before it gets reset")
 
-	buildCmd.Flags().IntVar(&linkWorkers, "symlink-workers", 5, "Number of workers to start for processing symlinks")
+	buildCmd.Flags().IntVar(&linkWorkers, "symlink-workers", 5, "Number of workers to start for processing symlinks (0 for one per CPU)")
 	buildCmd.Flags().IntVar(&linkBufferSize, "symlink-buffer-size", 2048, "Size of the symlink buffer before it gets reset")
+	buildCmd.Flags().StringVar(&workersMode, "workers", "", "Set to auto to size the file and symlink workers from the CPUs and ramp them up or down during the build, --file-workers and --symlink-workers that are given are kept")
+	buildCmd.Flags().IntVar(&walkWorkers, "walk-workers", 0, "Number of folders at the top of each source to walk at once, 1 walks one at a time (0 for one per CPU)")
+	buildCmd.Flags().BoolVar(&onlySymlinks, "only-symlinks", false, "Only build symlinks, regular files are left out and no file workers are started")
+	buildCmd.Flags().BoolVar(&noSymlinks, "no-symlinks", false, "Leave out every symlink, no symlink workers are started")
+	buildCmd.Flags().StringVar(&symlinkModeName, "symlink-mode", "preserve", "What to do with symlinks: preserve to recreate them pointing into the destination when they point into a source, dereference to build what they point to as real files, copy to copy it without build tags, or skip")
+
+	buildCmd.Flags().Int64Var(&maxInflightBytes, "max-inflight-bytes", 0, "Cap on bytes of file data held in memory across all file workers, larger files are streamed one at a time (0 for no cap)")
+
+	buildCmd.Flags().IntVar(&maxOpenFiles, "max-open-files", 0, "Cap on files the workers have open at once (0 for half of the open file limit when it is known)")
+
+	buildCmd.Flags().Int64Var(&streamThreshold, "stream-threshold", 8<<20, "Files larger than this many bytes are streamed instead of read into memory (0 to never stream)")
+	buildCmd.Flags().BoolVar(&verifyAfter, "verify-after", false, "Read every built file back and check it matches the hash of what was written")
+	buildCmd.Flags().StringVar(&lineEndings, "line-endings", "keep", "Line endings for built text files: lf, crlf or keep, binary files are never changed")
+	buildCmd.Flags().BoolVar(&resolveIncludes, "resolve-includes", false, "Replace // INCLUDE SUGARCRM path directives in kept blocks with the built file they point to, relative to the file they are in")
+	buildCmd.Flags().StringVar(&onMissingVersion, "on-missing-version", "error", "What to do with @_SUGAR_VERSION when no --version is given: error to fail the files that use it, leave to keep the placeholder or empty to replace it with nothing")
+	buildCmd.Flags().BoolVar(&stripBOM, "strip-bom", false, "Remove the UTF-8 byte order mark from the start of built text files instead of keeping it")
+
+	buildCmd.Flags().StringSliceVar(&gzipExtensions, "gzip-ext", nil, "Also write a gzip compressed .gz next to built files with these extensions, e.g. .js,.css,.html")
+	buildCmd.Flags().Int64Var(&gzipMinSize, "gzip-min-size", 1024, "Built files smaller than this many bytes do not get a .gz")
+
+	buildCmd.Flags().DurationVar(&deadline, "deadline", 0, "Stop the build if it takes longer than this, e.g. 10m")
+	buildCmd.Flags().DurationVar(&fileTimeout, "file-timeout", 0, "Fail any single file that takes longer than this to build and move on to the next, e.g. 30s")
+	buildCmd.Flags().BoolVar(&toStdout, "stdout", false, "Build a single SOURCE file and print the result instead of writing it to the destination")
+	buildCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Build everything in memory and report which files would change in the destination without writing anything")
+	buildCmd.Flags().BoolVar(&showDiff, "diff", false, "With --dry-run, print a unified diff of every file that would change")
+	buildCmd.Flags().BoolVar(&nameOnly, "name-only", false, "With --dry-run, only list the files that would change")
+	buildCmd.Flags().BoolVar(&noProgress, "no-progress", false, "Do not show a progress bar with the files done, throughput and time left on stderr, it is only shown on a terminal")
+	buildCmd.Flags().BoolVar(&useTUI, "tui", false, "Show a live view of the files being built, throughput and the latest errors when stdout is a terminal")
+	buildCmd.Flags().BoolVar(&explainSkips, "explain-skips", false, "Log every file and folder that is skipped along with the rule that skipped it")
+	buildCmd.Flags().StringArrayVar(&includes, "include", nil, "Only build files matching this glob, can be repeated")
+	buildCmd.Flags().StringArrayVar(&excludes, "exclude", nil, "Do not build files or folders matching this glob, can be repeated, start it with ! to build matches of an earlier glob again")
+	buildCmd.Flags().IntVar(&maxDepth, "max-depth", -1, "Only walk this many folders below each source, 0 only builds the files in the source itself (-1 for no limit)")
+	buildCmd.Flags().StringVar(&excludeLargerThan, "exclude-larger-than", "", "Do not build files larger than this size, e.g. 10MB")
+	buildCmd.Flags().StringVar(&excludeSmallerThan, "exclude-smaller-than", "", "Do not build files smaller than this size, e.g. 1KB")
+	buildCmd.Flags().StringArrayVar(&includeFrom, "include-from", nil, "Read --include globs from this file, one per line, # starts a comment")
+	buildCmd.Flags().StringArrayVar(&excludeFrom, "exclude-from", nil, "Read --exclude globs from this file, one per line, # starts a comment")
+	buildCmd.Flags().BoolVar(&noIgnoreFile, "no-romeignore", false, "Do not leave out what the "+build.IgnoreFile+" in the root of a source matches")
+	buildCmd.Flags().StringVar(&onlyChangedSince, "only-changed-since", "", "Only build files git says changed since this ref, e.g. origin/master, everything is built when the source is not a git repository")
+	buildCmd.Flags().BoolVar(&flatten, "flatten", false, "Write every file straight into the destination by its name alone, files with the same name are a warning and --on-conflict picks which is kept")
+	buildCmd.Flags().StringArrayVar(&renames, "rename", nil, "Rewrite destination paths with pattern=>replacement, a glob like custom/**=>modules/** or re:regex=>$1, can be repeated and the first match wins")
+
+	buildCmd.Flags().StringVar(&checkpointPath, "checkpoint", "", "Append the path of every finished file to this file so a failed build can be resumed")
+	buildCmd.Flags().StringVar(&resumePath, "resume", "", "Leave out the files a stopped build already finished, as listed in this checkpoint file. It keeps being added to unless --checkpoint is given")
+	buildCmd.Flags().BoolVar(&resumeJournal, "resume-journal", false, "Leave out the files a stopped build already finished, as listed in the journal it left in the destination")
+	buildCmd.Flags().StringVar(&failuresOut, "failures-out", "", "Write the relative paths of the files that failed to this file, one per line, for --retry-from")
+	buildCmd.Flags().StringVar(&retryFrom, "retry-from", "", "Only build the relative paths listed in this file, e.g. from --failures-out, without walking the sources")
+
+	buildCmd.Flags().StringVar(&outputUmask, "output-umask", "", "Create files and folders in the destination with 0666 and 0777 less this octal mask, e.g. 0027, whatever the umask of the shell is")
+	buildCmd.Flags().StringVar(&fileModeFlag, "file-mode", "", "Create every file in the destination with these octal permissions, e.g. 0644, instead of keeping the permissions of the file it replaces")
+	buildCmd.Flags().StringVar(&dirModeFlag, "dir-mode", "", "Create every folder in the destination with these octal permissions, e.g. 0755, instead of 0775")
+	buildCmd.Flags().BoolVar(&preserveMode, "preserve-mode", false, "Give every built file the permissions of its source, like the executable bit of a script")
+	buildCmd.Flags().BoolVar(&preserveTimes, "preserve-times", false, "Give every built file the modification time of its source")
+	buildCmd.Flags().StringVar(&reflink, "reflink", "auto", "Clone files without build tags or variables from their sources on filesystems that can, like APFS, btrfs and XFS: auto, always to fail files that can not be cloned, or never")
+	buildCmd.Flags().BoolVar(&linkIdentical, "link-identical", false, "Hardlink files without build tags or variables to their sources instead of copying them, editing one in the destination edits the source")
+	buildCmd.Flags().BoolVar(&minify, "minify", false, "Minify .js and .css files once their build tags are processed, .min.js and .min.css files are left alone")
+	buildCmd.Flags().StringVar(&lintPHP, "lint-php", "", "Run php -l over every PHP file the build tags or variables changed and fail the files that are not valid PHP, or with --lint-php=PATH use that php binary")
+	buildCmd.Flags().Lookup("lint-php").NoOptDefVal = "php"
+	buildCmd.Flags().StringVar(&onConflict, "on-conflict", "overwrite", "What to do when a destination file already exists: overwrite, skip, newer or error")
+
+	buildCmd.Flags().StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this address while building, e.g. :9090")
+	buildCmd.Flags().DurationVar(&metricsLinger, "metrics-linger", 15*time.Second, "How long to keep serving metrics after the build finishes")
+
+	buildCmd.Flags().StringVar(&preBuildHook, "pre-build", "", "Shell command to run before the build starts, --pre-hook works too")
+	buildCmd.Flags().StringVar(&postBuildHook, "post-build", "", "Shell command to run after a successful build, ROME_SOURCE, ROME_DESTINATION, ROME_FLAVOR, ROME_VERSION and ROME_BUILT_COUNT are set for it, --post-hook works too")
+	buildCmd.Flags().SetNormalizeFunc(hookFlagAliases)
+	buildCmd.Flags().BoolVar(&alwaysRunHooks, "always-run-hooks", false, "Run the post-build hook even when the build failed")
+
+	buildCmd.Flags().StringVar(&junitPath, "junit", "", "Write a JUnit XML report of failed files to this path")
+	buildCmd.Flags().BoolVar(&junitVerbose, "junit-verbose", false, "Include successfully built files in the JUnit report")
+	buildCmd.Flags().IntVar(&maxErrorsShown, "max-errors-shown", 0, "Print at most this many failed files at the end of the build, reports still get all of them (0 to print all)")
+	buildCmd.Flags().BoolVar(&reportNoOp, "report-no-op", false, "List the processed files that have no build tags or variables, so copying them would give the same result")
+	buildCmd.Flags().IntVar(&maxFailures, "max-failures", 0, "Stop the build once this many files failed (0 to keep going no matter how many fail)")
+	buildCmd.Flags().IntVar(&maxFailures, "max-errors", 0, "Same as --max-failures")
+	buildCmd.Flags().BoolVar(&failFast, "fail-fast", false, "Stop the build on the first file that fails, files already being built are finished")
+	buildCmd.Flags().BoolVar(&useCache, "cache", false, "Remember the hash of every source in "+build.CacheFile+" in the destination and leave out the ones that did not change since the last build with the same flavor, version and settings")
+	buildCmd.Flags().BoolVar(&writeManifest, "manifest", false, "Write a manifest of every built file and its hash to .rome-manifest.json in the destination and compare it to the last one, --since-version and --provenance turn it on")
+	buildCmd.Flags().BoolVar(&sinceVersion, "since-version", false, "Only rebuild files that use the version when the last build in the destination was the same flavor, for builds where nothing but the version changed")
+	buildCmd.Flags().StringVar(&indexPath, "index", "", "Write a JSON index mapping every built file to its source, flavor and version to this path")
+	buildCmd.Flags().StringVar(&tracePath, "trace", "", "Record when every file started and how long it took to this file, as CSV for a .csv path or a Chrome trace otherwise")
+	buildCmd.Flags().StringVar(&provenancePath, "provenance", "", "Write a JSON record of the sources and their git commits, the invocation, the Rome version and the manifest hash to this path")
+	buildCmd.Flags().IntVar(&summaryDepth, "summary-depth", 0, "Break the summary down by this many folders deep in the destination")
+	buildCmd.Flags().StringVar(&outputFormat, "output", "text", "Print the result of the build as text or json, json prints a single document on stdout and everything else on stderr")
+	buildCmd.Flags().StringVar(&summaryPath, "summary-json", "", "Write a JSON summary of the build, including bytes written and skipped, to this path")
 
 	buildCmd.MarkFlagRequired("version")
 	buildCmd.MarkFlagRequired("flavor")
@@ -169,37 +1017,332 @@ func exists(path string) (bool, error) {
 	return true, err
 }
 
-func fileWorker(files <-chan File, quit <-chan bool, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for {
-		select {
-		case file, ok := <-files:
-			if !ok {
-				return
-			}
-			shortPath := strings.Replace(string(file), source, "", -1)
-			finalDestination := destination + string(filepath.Separator) + shortPath
-			build.BuildFile(string(file), finalDestination, flavor, version)
-		case <-quit:
-			return
+// buildFilter merges the inline --include and --exclude globs with the ones in the pattern files
+func buildFilter() (*build.Filter, error) {
+	include := append([]string(nil), includes...)
+	for _, path := range includeFrom {
+		patterns, err := build.ReadPatternFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("--include-from: %v", err)
 		}
+		include = append(include, patterns...)
 	}
+	exclude := append([]string(nil), excludes...)
+	for _, path := range excludeFrom {
+		patterns, err := build.ReadPatternFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("--exclude-from: %v", err)
+		}
+		exclude = append(exclude, patterns...)
+	}
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	return build.NewFilter(include, exclude)
 }
 
-func linkWorker(links <- chan Link, quit <- chan bool, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for {
-		select {
-		case link, ok := <-links:
-			if !ok {
-				return
+// changedFiles asks git what changed since ref in every source, nil means everything has to be
+// built because git could not tell for at least one of them
+func changedFiles(sources []string, ref string) map[string]bool {
+	changed := make(map[string]bool)
+	for _, source := range sources {
+		paths, err := build.ChangedSince(source, ref)
+		if err != nil {
+			utils.Warnf("%v, building everything", err)
+			return nil
+		}
+		for rel := range paths {
+			changed[rel] = true
+		}
+	}
+	fmt.Printf("%d files changed since %s\n", len(changed), ref)
+	return changed
+}
+
+// buildOptions are the options of a build set up by the flags, everything that is only known
+// once the build starts, like where it is written and what happens with every file, is left out
+func buildOptions() build.Options {
+	var explainf func(string, ...interface{})
+	if explainSkips {
+		explainf = utils.Infof
+	}
+	return build.Options{
+		Sources:          sources,
+		Sink:             sink,
+		Flavor:           tagFlavor,
+		Version:          version,
+		FileWorkers:      fileWorkers,
+		FileBufferSize:   fileBufferSize,
+		LinkWorkers:      linkWorkers,
+		LinkBufferSize:   linkBufferSize,
+		WalkWorkers:      walkWorkers,
+		AutoWorkers:      workersMode == "auto",
+		Symlinks:         symlinkMode,
+		OnlySymlinks:     onlySymlinks,
+		NoSymlinks:       noSymlinks,
+		MaxInflightBytes: maxInflightBytes,
+		MaxOpenFiles:     maxOpenFiles,
+		StreamThreshold:  streamThreshold,
+		VerifyAfter:      verifyAfter,
+		LintPHP:          lintPHP,
+		Hash:             writeManifest,
+		VersionStable:    versionStable(),
+		LineEndings:      lineEndingMode,
+		StripBOM:         stripBOM,
+		FileMode:         fileMode,
+		DirMode:          dirMode,
+		PreserveMode:     preserveMode,
+		PreserveTimes:    preserveTimes,
+		LinkIdentical:    linkIdentical,
+		Reflink:          reflinkMode,
+		Processors:       processors,
+		WarnEmpty:        warnEmpty,
+		FailUnreadable:   strict,
+		FailCaseClashes:  strict,
+		ResolveIncludes:  resolveIncludes,
+		OnMissingVersion: missingVersionPolicy,
+		GzipExtensions:   gzipExtensions,
+		GzipMinSize:      gzipMinSize,
+		Filter:           fileFilter,
+		IgnoreFiles:      !noIgnoreFile,
+		Paths:            retryPaths,
+		MaxDepth:         maxDepth + 1,
+		MaxFileSize:      maxFileSize,
+		MinFileSize:      minFileSize,
+		Flatten:          flatten,
+		Rename:           renameRules,
+		Cache:            buildCache,
+		MaxFailures:      maxFailures,
+		FileTimeout:      fileTimeout,
+		OnConflict:       conflictPolicy,
+		Explainf:         explainf,
+		Warnf:            utils.Warnf,
+		Debugf:           utils.Debugf,
+	}
+}
+
+// validateWorkers rejects negative worker and buffer sizes, zero workers are left for
+// build.Run to turn into one per CPU
+func validateWorkers() error {
+	sizes := []struct {
+		flag  string
+		value int
+	}{
+		{"--file-workers", fileWorkers},
+		{"--file-buffer-size", fileBufferSize},
+		{"--symlink-workers", linkWorkers},
+		{"--symlink-buffer-size", linkBufferSize},
+		{"--walk-workers", walkWorkers},
+	}
+	for _, size := range sizes {
+		if size.value < 0 {
+			return fmt.Errorf("%s can not be negative, got %d", size.flag, size.value)
+		}
+	}
+	return nil
+}
+
+// mapFlavor returns the SugarCRM flavor that name is an alias of in the name=flavor
+// mappings, or name itself when it is not mapped. A name that ends up as no known flavor is an error.
+func mapFlavor(name string, mappings []string) (string, error) {
+	mapped := name
+	for _, mapping := range mappings {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return "", fmt.Errorf("flavor mapping %q must look like name=flavor", mapping)
+		}
+		if _, ok := build.Flavors[parts[1]]; !ok {
+			return "", fmt.Errorf("flavor mapping %q maps to %s which is not a SugarCRM flavor", mapping, parts[1])
+		}
+		if parts[0] == name {
+			mapped = parts[1]
+		}
+	}
+	if _, ok := build.Flavors[mapped]; !ok {
+		return "", fmt.Errorf("unknown flavor %q, run rome flavors to list the valid ones", name)
+	}
+	return mapped, nil
+}
+
+// defaultMaxOpenFiles leaves half of the open file limit for everything else, a limit that
+// can not be found means no cap
+func defaultMaxOpenFiles() int {
+	limit, ok := utils.OpenFileLimit()
+	if !ok || limit > 1<<20 {
+		return 0
+	}
+	max := int(limit / 2)
+	if max < 2*fileWorkers {
+		utils.Warnf("the open file limit is %d, which is low for %d file workers, builds will wait on open files (raise it with ulimit -n)", limit, fileWorkers)
+	}
+	if max < 2 {
+		max = 2
+	}
+	return max
+}
+
+// versionStable returns the files --since-version leaves alone, nil when everything is built
+func versionStable() map[string]bool {
+	if !sinceVersion {
+		return nil
+	}
+	if previousManifest == nil || previousManifest.Flavor != flavor {
+		utils.Warnf("--since-version needs a manifest from a %s build in %s, building everything", flavor, destination)
+		return nil
+	}
+	stable := previousManifest.VersionStable()
+	fmt.Printf("Leaving %d files that do not use the version as they are\n", len(stable))
+	return stable
+}
+
+// checkStamp warns, or exits under --strict, when dest already holds a build of a different
+// flavor or version, building over it would leave a mix of the two
+func checkStamp(dest string, flavor string) {
+	stamp, err := build.ReadStamp(dest)
+	if err != nil {
+		fmt.Printf("Could Not Read Build Stamp in %s: %v\n", dest, err)
+		return
+	}
+	if stamp == nil || (stamp.Flavor == flavor && (stamp.Version == version || sinceVersion)) {
+		return
+	}
+	fmt.Printf("%s already holds a %s %s build from %s, building %s %s over it will mix the two (use --clean to start over)\n",
+		dest, stamp.Flavor, stamp.Version, stamp.Built.Format(time.RFC3339), flavor, version)
+	if strict || warningsAsErrors {
+		os.Exit(1)
+	}
+}
+
+// expandSources turns the SOURCE arguments into a list of source roots. An argument that exists
+// is used as is, even if it has glob characters in it, otherwise it is expanded as a glob.
+// Cleaning each source drops any trailing separators so relative paths come out right.
+func expandSources(args []string) ([]string, error) {
+	var roots []string
+	for _, arg := range args {
+		if ok, _ := exists(arg); ok {
+			roots = append(roots, filepath.Clean(arg))
+			continue
+		}
+		if !strings.ContainsAny(arg, "*?[") {
+			return nil, fmt.Errorf("Source Path (%s) does not exists", arg)
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("Source Pattern (%s) is not valid: %v", arg, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("Source Pattern (%s) did not match anything", arg)
+		}
+		for _, match := range matches {
+			roots = append(roots, filepath.Clean(match))
+		}
+	}
+	return roots, nil
+}
+
+// outputModes works out the permissions of created files and folders from --output-umask,
+// --file-mode and --dir-mode, the explicit modes win over the mask and zero keeps the default.
+// With --preserve-mode files get the permissions of their source, so the mask is only for folders.
+func outputModes() (os.FileMode, os.FileMode, error) {
+	var files, dirs os.FileMode
+	if outputUmask != "" {
+		mask, err := utils.ParseMode(outputUmask)
+		if err != nil {
+			return 0, 0, fmt.Errorf("--output-umask: %v", err)
+		}
+		files, dirs = 0666&^mask, 0777&^mask
+		if preserveMode {
+			files = 0
+		}
+	}
+	if fileModeFlag != "" {
+		if preserveMode {
+			return 0, 0, fmt.Errorf("--file-mode can not be used with --preserve-mode")
+		}
+		mode, err := utils.ParseMode(fileModeFlag)
+		if err != nil {
+			return 0, 0, fmt.Errorf("--file-mode: %v", err)
+		}
+		files = mode
+	}
+	if dirModeFlag != "" {
+		mode, err := utils.ParseMode(dirModeFlag)
+		if err != nil {
+			return 0, 0, fmt.Errorf("--dir-mode: %v", err)
+		}
+		dirs = mode
+	}
+	return files, dirs, nil
+}
+
+// parseSizeFlag parses the human size given to the flag name, empty means no limit
+func parseSizeFlag(name string, value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	size, err := utils.ParseSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("--%s: %v", name, err)
+	}
+	return size, nil
+}
+
+// reportErrors prints the files that failed during a build as a table of the file, what was
+// being done and the error. Files that failed because their folder could not be created are
+// printed once per folder instead. At most max files are printed when max is above zero.
+func reportErrors(failures []build.Failure, max int) {
+	fmt.Printf("\n%d files failed to build:\n", len(failures))
+	var dirs []string
+	dirErrs := make(map[string]error)
+	dirFiles := make(map[string]int)
+	var fileFailures []build.Failure
+	for _, failure := range failures {
+		var dirErr *build.DirError
+		if !errors.As(failure.Err, &dirErr) {
+			fileFailures = append(fileFailures, failure)
+			continue
+		}
+		if dirFiles[dirErr.Dir] == 0 {
+			dirs = append(dirs, dirErr.Dir)
+			dirErrs[dirErr.Dir] = dirErr.Err
+		}
+		dirFiles[dirErr.Dir]++
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		fmt.Printf("  could not create directory %s, %d files left out: %v\n", dir, dirFiles[dir], dirErrs[dir])
+	}
+
+	shown := fileFailures
+	if max > 0 && len(fileFailures) > max {
+		shown = fileFailures[:max]
+	}
+	if len(shown) > 0 {
+		table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "  FILE\tOPERATION\tERROR")
+		for _, failure := range shown {
+			msg := failure.Err.Error()
+			var parseErr *build.ParseError
+			if errors.As(failure.Err, &parseErr) {
+				msg = fmt.Sprintf("line %d: %s", parseErr.Line, parseErr.Msg)
 			}
-			shortPath := strings.Replace(string(link.Link), source, "", -1)
-			finalDestination := destination + string(filepath.Separator) + shortPath
-			os.MkdirAll(path.Dir(finalDestination), 0775)
-			os.Symlink(link.Target, destination)
-		case <-quit:
-			return
+			fmt.Fprintf(table, "  %s\t%s\t%s\n", failure.Path, failure.Operation, msg)
 		}
+		table.Flush()
+	}
+	if hidden := len(fileFailures) - len(shown); hidden > 0 {
+		fmt.Printf("  ...and %d more (see --junit or --summary-json for the full list)\n", hidden)
+	}
+}
+
+// printMissingVersion lists the files that use @_SUGAR_VERSION when no --version was given
+func printMissingVersion(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	sort.Strings(paths)
+	fmt.Printf("%d files use @_SUGAR_VERSION but no --version was given:\n", len(paths))
+	for _, path := range paths {
+		fmt.Printf("  %s\n", path)
 	}
 }
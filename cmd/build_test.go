@@ -0,0 +1,98 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jwhitcraft/rome/build"
+)
+
+func TestValidateWorkers(t *testing.T) {
+	defer func(fw, fb, lw, lb, ww int) {
+		fileWorkers, fileBufferSize, linkWorkers, linkBufferSize, walkWorkers = fw, fb, lw, lb, ww
+	}(fileWorkers, fileBufferSize, linkWorkers, linkBufferSize, walkWorkers)
+
+	tests := []struct {
+		sizes [5]int
+		// bad is the flag that is rejected, empty when the sizes are fine
+		bad string
+	}{
+		{[5]int{40, 4096, 5, 2048, 0}, ""},
+		{[5]int{0, 0, 0, 0, 0}, ""},
+		{[5]int{1, 0, 1, 0, 1}, ""},
+		{[5]int{-1, 4096, 5, 2048, 0}, "--file-workers"},
+		{[5]int{40, -1, 5, 2048, 0}, "--file-buffer-size"},
+		{[5]int{40, 4096, -1, 2048, 0}, "--symlink-workers"},
+		{[5]int{40, 4096, 5, -1, 0}, "--symlink-buffer-size"},
+		{[5]int{40, 4096, 5, 2048, -1}, "--walk-workers"},
+	}
+	for _, tt := range tests {
+		fileWorkers, fileBufferSize, linkWorkers, linkBufferSize, walkWorkers = tt.sizes[0], tt.sizes[1], tt.sizes[2], tt.sizes[3], tt.sizes[4]
+		err := validateWorkers()
+		switch {
+		case tt.bad == "" && err != nil:
+			t.Errorf("%v: %v", tt.sizes, err)
+		case tt.bad != "" && (err == nil || !strings.Contains(err.Error(), tt.bad+" ")):
+			t.Errorf("%v: got %v, want %s rejected", tt.sizes, err, tt.bad)
+		}
+	}
+}
+
+func TestVersionStable(t *testing.T) {
+	defer func(since bool, f, v, d string, m *build.Manifest) {
+		sinceVersion, flavor, version, destination, previousManifest = since, f, v, d, m
+	}(sinceVersion, flavor, version, destination, previousManifest)
+
+	last := build.NewManifest("ent", "7.0")
+	last.Files["stable.php"] = build.ManifestEntry{VersionStable: true}
+	last.Files["version.php"] = build.ManifestEntry{}
+	tests := []struct {
+		name     string
+		since    bool
+		previous *build.Manifest
+		flavor   string
+		version  string
+		// want is nil when everything has to be built
+		want map[string]bool
+	}{
+		{"without --since-version", false, last, "ent", "7.1", nil},
+		{"no manifest from the last build", true, nil, "ent", "7.1", nil},
+		{"the last build was another flavor", true, last, "pro", "7.1", nil},
+		{"a new version of the same flavor", true, last, "ent", "7.1", map[string]bool{"stable.php": true}},
+		{"the same version again", true, last, "ent", "7.0", map[string]bool{"stable.php": true}},
+		{"an older version", true, last, "ent", "6.9", map[string]bool{"stable.php": true}},
+	}
+	for _, tt := range tests {
+		sinceVersion, previousManifest, flavor, version, destination = tt.since, tt.previous, tt.flavor, tt.version, t.TempDir()
+		got := versionStable()
+		if (got == nil) != (tt.want == nil) || len(got) != len(tt.want) {
+			t.Errorf("%s: versionStable() = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for path := range tt.want {
+			if !got[path] {
+				t.Errorf("%s: %s is not left alone", tt.name, path)
+			}
+		}
+	}
+}
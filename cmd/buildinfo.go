@@ -0,0 +1,90 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/jwhitcraft/rome/build"
+	"github.com/jwhitcraft/rome/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	buildSHA  string
+	buildDate string
+	builder   string
+)
+
+// addBuildInfoFlags adds the flags that set what the build variables are replaced with to cmd
+func addBuildInfoFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&buildSHA, "build-sha", "", "What @_SUGAR_BUILD_SHA is replaced with (default is the git commit of the first SOURCE, with -dirty when it has changes)")
+	cmd.Flags().StringVar(&buildDate, "build-date", "", "What @_SUGAR_BUILD_DATE is replaced with (default is the time the build starts in RFC 3339)")
+	cmd.Flags().StringVar(&builder, "builder", "", "What @_SUGAR_BUILDER is replaced with (default is user@host)")
+}
+
+// setBuildInfo sets what the build variables are replaced with from the flags, the ones that
+// were not given come from git in source, the clock and who is running the build
+func setBuildInfo(source string) {
+	info := build.BuildInfo{SHA: buildSHA, Date: buildDate, Builder: builder}
+	if info.SHA == "" {
+		dir := source
+		if fi, err := os.Stat(source); err != nil || !fi.IsDir() {
+			dir = filepath.Dir(source)
+		}
+		commit, dirty, err := build.SourceCommit(dir)
+		switch {
+		case err != nil:
+			utils.Debugf("%v, @_SUGAR_BUILD_SHA is left as it is", err)
+		case dirty:
+			info.SHA = commit + "-dirty"
+		default:
+			info.SHA = commit
+		}
+	}
+	if info.Date == "" {
+		info.Date = time.Now().UTC().Format(time.RFC3339)
+	}
+	if info.Builder == "" {
+		info.Builder = defaultBuilder()
+	}
+	build.SetBuildInfo(info)
+}
+
+// defaultBuilder is the user running the build at the host it runs on, the parts that can not
+// be found are left out
+func defaultBuilder() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, _ := os.Hostname()
+	switch {
+	case name == "":
+		return host
+	case host == "":
+		return name
+	}
+	return name + "@" + host
+}
@@ -0,0 +1,107 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/jwhitcraft/rome/build"
+	"github.com/spf13/cobra"
+)
+
+var cleanDryRun bool
+
+// cleanCmd represents the clean command
+var cleanCmd = &cobra.Command{
+	Use:   "clean [FLAGS] DESTINATION",
+	Short: "Remove a build without starting a new one",
+	Long: `Deletes everything in DESTINATION, like build --clean does before it builds, but leaves the
+folder itself in place. It asks before deleting anything unless --yes is given.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			fmt.Println("Exactly one DESTINATION is required")
+			os.Exit(1)
+		}
+		dest := args[0]
+		if build.IsRemote(dest) {
+			fmt.Println("clean needs a local destination")
+			os.Exit(1)
+		}
+		info, err := os.Stat(dest)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if !info.IsDir() {
+			fmt.Printf("%s is not a folder\n", dest)
+			os.Exit(1)
+		}
+		if stamp, err := build.ReadStamp(dest); err == nil && stamp != nil {
+			fmt.Printf("%s holds a %s %s build from %s\n", dest, stamp.Flavor, stamp.Version, stamp.Built.Format("2006-01-02 15:04"))
+		}
+
+		if cleanDryRun {
+			count, err := countFiles(dest)
+			if err != nil {
+				fmt.Printf("Could Not Read %s: %v\n", dest, err)
+				os.Exit(1)
+			}
+			entries, err := ioutil.ReadDir(dest)
+			if err != nil {
+				fmt.Printf("Could Not Read %s: %v\n", dest, err)
+				os.Exit(1)
+			}
+			fmt.Printf("Would delete %d files in %s:\n", count, dest)
+			for _, entry := range entries {
+				name := entry.Name()
+				if entry.IsDir() {
+					name += "/"
+				}
+				fmt.Printf("  %s\n", name)
+			}
+			return
+		}
+
+		ok, err := confirmDelete(dest, assumeYes)
+		if err != nil {
+			fmt.Printf("Could Not Read %s: %v\n", dest, err)
+			os.Exit(1)
+		}
+		if !ok {
+			fmt.Println("Not cleaning " + dest + ", aborting")
+			os.Exit(1)
+		}
+		fmt.Println("Cleaning " + dest)
+		if err := build.CleanBuild(dest); err != nil {
+			fmt.Printf("Could Not Clean %s: %v\n", dest, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(cleanCmd)
+
+	cleanCmd.Flags().BoolVar(&cleanDryRun, "dry-run", false, "List what would be deleted without deleting anything")
+	cleanCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Do not ask before deleting the build")
+}
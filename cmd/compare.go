@@ -0,0 +1,106 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jwhitcraft/rome/build"
+	"github.com/spf13/cobra"
+)
+
+var (
+	compareA    string
+	compareB    string
+	compareDiff bool
+)
+
+// compareFlavorsCmd represents the compare-flavors command
+var compareFlavorsCmd = &cobra.Command{
+	Use:   "compare-flavors --a FLAVOR --b FLAVOR [FLAGS] SOURCE-FOLDER",
+	Short: "Report which files build differently for two flavors",
+	Long: `Builds every file in the source in memory for both flavors, nothing is written, and lists
+the files that come out differently along with their size and line count in each flavor.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			fmt.Println("A single SOURCE-FOLDER is required")
+			os.Exit(1)
+		}
+		for _, f := range []string{compareA, compareB} {
+			if _, ok := build.Flavors[f]; !ok {
+				fmt.Printf("Unknown flavor: %s\n", f)
+				os.Exit(1)
+			}
+		}
+
+		comparison, err := build.CompareFlavors(args[0], compareA, compareB, version, compareDiff)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Compared %d files, %d build differently for %s and %s\n", comparison.Files, len(comparison.Differ), compareA, compareB)
+		for _, diff := range comparison.Differ {
+			fmt.Printf("  %s\n    %-5s %s\n    %-5s %s\n", diff.Path, compareA, describeOutput(diff.A), compareB, describeOutput(diff.B))
+			if compareDiff {
+				printDiff(diff.Lines)
+			}
+		}
+	},
+}
+
+// describeOutput says how big a file is in a flavor
+func describeOutput(out build.FlavorOutput) string {
+	if out.Excluded {
+		return "excluded by its FILE tag"
+	}
+	return fmt.Sprintf("%d bytes, %d lines", out.Bytes, out.Lines)
+}
+
+// printDiff prints the changed lines, every run of them under the line numbers it starts at
+func printDiff(lines []build.DiffLine) {
+	inHunk := false
+	for i, line := range lines {
+		if line.Op == build.DiffKeep {
+			inHunk = false
+			continue
+		}
+		if !inHunk {
+			lineA, lineB := 0, 0
+			if i > 0 {
+				lineA, lineB = lines[i-1].LineA, lines[i-1].LineB
+			}
+			fmt.Printf("    @@ %s line %d, %s line %d @@\n", compareA, lineA+1, compareB, lineB+1)
+			inHunk = true
+		}
+		fmt.Printf("    %c%s\n", line.Op, line.Text)
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(compareFlavorsCmd)
+
+	compareFlavorsCmd.Flags().StringVar(&compareA, "a", "ent", "The first flavor to build")
+	compareFlavorsCmd.Flags().StringVar(&compareB, "b", "pro", "The flavor to compare the first one to")
+	compareFlavorsCmd.Flags().BoolVar(&compareDiff, "diff", false, "Print the lines that differ in each file")
+	compareFlavorsCmd.Flags().StringVarP(&version, "version", "v", "", "The version to substitute, it is the same for both flavors")
+}
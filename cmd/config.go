@@ -0,0 +1,82 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jwhitcraft/rome/build"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// configName is the config file read from the home directory and from the source folder
+const configName = ".rome.yaml"
+
+// readSourceConfig merges the config file in the source folder, if there is one, over the one
+// read from the home directory. A config file given with --config is the only one read.
+func readSourceConfig(source string) error {
+	if cfgFile != "" {
+		return nil
+	}
+	if info, err := os.Stat(source); err != nil || !info.IsDir() {
+		return nil
+	}
+	path := filepath.Join(source, configName)
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	viper.SetConfigFile(path)
+	if err := viper.MergeInConfig(); err != nil {
+		return fmt.Errorf("Could Not Read Config File %s: %v", path, err)
+	}
+	fmt.Println("Using config file:", path)
+	return loadFlavors()
+}
+
+// loadFlavors defines the custom flavors in the flavors section of the config file, each one
+// lists the flavors it is built on and keeps their build tags along with its own
+//
+//	flavors:
+//	  mycloud: [ent]
+func loadFlavors() error {
+	if err := build.DefineFlavors(viper.GetStringMapStringSlice("flavors")); err != nil {
+		return fmt.Errorf("the flavors section of the config file: %v", err)
+	}
+	return nil
+}
+
+// applyConfigDefaults sets the flags of cmd from the section of the config file named after
+// it, flags given on the command line or by a profile keep their value
+//
+//	build:
+//	  flavor: ent
+//	  version: 7.9.0.0
+//	  destination: /var/www/sugar
+func applyConfigDefaults(cmd *cobra.Command) error {
+	section := viper.Sub(cmd.Name())
+	if section == nil {
+		return nil
+	}
+	return setFlags(cmd, section.AllSettings(), fmt.Sprintf("the %s section of the config file", cmd.Name()))
+}
@@ -0,0 +1,144 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/jwhitcraft/rome/build"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffDestination   string
+	diffFlavor        string
+	diffVersion       string
+	diffUnified       bool
+	diffHideUntouched bool
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff [FLAGS] -d DESTINATION SOURCE-FOLDER...",
+	Short: "Preview what a build would change in an existing destination",
+	Long: `Builds the sources in memory, like build --dry-run, and lists every file in DESTINATION the
+build would add, update or leave untouched. --unified prints a unified diff of every text file
+that would be updated. Nothing is written. The other build flags are read from the config file.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			fmt.Println("At least one SOURCE-FOLDER is required")
+			os.Exit(1)
+		}
+		if diffDestination == "" {
+			fmt.Println("--destination is required")
+			os.Exit(1)
+		}
+		// set like flags on the command line so the config file does not override them
+		buildCmd.Flags().Set("destination", diffDestination)
+		if diffFlavor != "" {
+			buildCmd.Flags().Set("flavor", diffFlavor)
+		}
+		if cmd.Flags().Changed("version") {
+			buildCmd.Flags().Set("version", diffVersion)
+		}
+		buildCmd.Flags().Set("dry-run", "true")
+		buildCmd.PreRun(buildCmd, args)
+		runDiff()
+	},
+}
+
+// diffedFile is a file in the destination and what the build would do to it
+type diffedFile struct {
+	path    string
+	change  string
+	dest    string
+	content []byte
+}
+
+// runDiff builds everything in memory and lists what would happen to every file in the destination
+func runDiff() {
+	fmt.Printf("Comparing %s (%s %s) with %s\n", strings.Join(sources, ", "), flavor, version, destination)
+	var files []diffedFile
+	counts := make(map[string]int)
+	onResult := func(r build.FileResult) {
+		if r.Err != nil || r.Skipped {
+			return
+		}
+		f := diffedFile{path: r.Path, change: "untouched", dest: r.Destination}
+		if r.Changed {
+			f.change = "updated"
+			if _, err := os.Lstat(r.Destination); os.IsNotExist(err) {
+				f.change = "added"
+			} else if diffUnified && !r.Link {
+				f.content = r.Content
+			}
+		}
+		counts[f.change]++
+		files = append(files, f)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	result, err := build.Run(ctx, dryRunOptions(onResult))
+	if err != nil && result == nil {
+		fmt.Println(err)
+		os.Exit(exitBuildFailed)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	for _, f := range files {
+		if diffHideUntouched && f.change == "untouched" {
+			continue
+		}
+		fmt.Printf("  %-9s  %s\n", f.change, f.path)
+	}
+	if diffUnified {
+		for _, f := range files {
+			if f.content != nil {
+				printUnifiedDiff(f.dest, f.content)
+			}
+		}
+	}
+	fmt.Printf("%d added, %d updated, %d untouched\n", counts["added"], counts["updated"], counts["untouched"])
+	if len(result.Failures) > 0 {
+		reportErrors(result.Failures, maxErrorsShown)
+		os.Exit(exitBuildFailed)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(exitInterrupted)
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringVarP(&diffDestination, "destination", "d", "", "The destination to compare the build with")
+	diffCmd.Flags().StringVarP(&diffFlavor, "flavor", "f", "", "What Flavor of SugarCRM to build, the same as build when it is not set")
+	diffCmd.Flags().StringVarP(&diffVersion, "version", "v", "", "What Version is being built")
+	diffCmd.Flags().BoolVarP(&diffUnified, "unified", "u", false, "Print a unified diff of every text file that would be updated")
+	diffCmd.Flags().BoolVar(&diffHideUntouched, "hide-untouched", false, "Only list the files that would be added or updated")
+}
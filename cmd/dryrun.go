@@ -0,0 +1,244 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/jwhitcraft/rome/build"
+	"github.com/jwhitcraft/rome/utils"
+)
+
+// diffContext is how many unchanged lines are shown around every change
+const diffContext = 3
+
+// runDryRun builds everything in memory and reports how it differs from the destination
+// without writing anything
+func runDryRun() {
+	fmt.Println("Dry run of Rome on " + strings.Join(sources, ", ") + ", nothing is written")
+	var changed []string
+	var processed, copied, links, changedLinks int
+	var excluded []string
+	onResult := func(r build.FileResult) {
+		if r.Err != nil {
+			return
+		}
+		switch {
+		case r.Link && !r.Skipped:
+			links++
+			if r.Changed {
+				changedLinks++
+			}
+		case r.Skipped && !r.Link:
+			excluded = append(excluded, r.Path)
+		case r.Processed:
+			processed++
+		case !r.Link:
+			copied++
+		}
+		if !r.Changed {
+			return
+		}
+		changed = append(changed, r.Path)
+		if showDiff && !nameOnly && !r.Link {
+			printUnifiedDiff(r.Destination, r.Content)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	result, err := build.Run(ctx, dryRunOptions(onResult))
+	if err != nil && result == nil {
+		fmt.Println(err)
+		os.Exit(exitBuildFailed)
+	}
+
+	if nameOnly {
+		sort.Strings(changed)
+		for _, path := range changed {
+			fmt.Println(path)
+		}
+	}
+	fmt.Printf("%d of %d files would change\n", len(changed), result.Built)
+	fmt.Printf("%d files would be processed for build tags and variables and %d copied as they are\n", processed, copied)
+	if links > 0 {
+		verb := "created"
+		switch symlinkMode {
+		case build.SymlinksDereference:
+			verb = "built as files"
+		case build.SymlinksCopy:
+			verb = "copied as files"
+		}
+		fmt.Printf("%d symlinks would be %s, %d of them are not in the destination yet\n", links, verb, changedLinks)
+	}
+	if result.LinksSkipped > 0 {
+		fmt.Printf("%d symlinks would be skipped\n", result.LinksSkipped)
+	}
+	if result.SkippedBySize > 0 {
+		fmt.Printf("%d files would be skipped because of their size\n", result.SkippedBySize)
+	}
+	if len(excluded) > 0 {
+		sort.Strings(excluded)
+		fmt.Printf("%d files would be left out by their FILE tag:\n", len(excluded))
+		for _, path := range excluded {
+			fmt.Printf("  %s\n", path)
+		}
+	}
+	printMissingVersion(result.MissingVersion)
+	if len(result.Failures) > 0 {
+		reportErrors(result.Failures, maxErrorsShown)
+		os.Exit(exitBuildFailed)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(exitInterrupted)
+	}
+}
+
+// dryRunOptions are the options of the build set up by the flags, as a dry run that hands every
+// file to onResult
+func dryRunOptions(onResult func(build.FileResult)) build.Options {
+	var explainf func(string, ...interface{})
+	if explainSkips {
+		explainf = utils.Infof
+	}
+	return build.Options{
+		Sources:          sources,
+		Destination:      destination,
+		Flavor:           tagFlavor,
+		Version:          version,
+		FileWorkers:      fileWorkers,
+		FileBufferSize:   fileBufferSize,
+		LinkWorkers:      linkWorkers,
+		LinkBufferSize:   linkBufferSize,
+		WalkWorkers:      walkWorkers,
+		AutoWorkers:      workersMode == "auto",
+		LineEndings:      lineEndingMode,
+		StripBOM:         stripBOM,
+		ResolveIncludes:  resolveIncludes,
+		OnMissingVersion: missingVersionPolicy,
+		FileTimeout:      fileTimeout,
+		Symlinks:         symlinkMode,
+		OnlySymlinks:     onlySymlinks,
+		NoSymlinks:       noSymlinks,
+		Filter:           fileFilter,
+		IgnoreFiles:      !noIgnoreFile,
+		Paths:            retryPaths,
+		MaxDepth:         maxDepth + 1,
+		MaxFileSize:      maxFileSize,
+		MinFileSize:      minFileSize,
+		Flatten:          flatten,
+		Rename:           renameRules,
+		DryRun:           true,
+		Explainf:         explainf,
+		Warnf:            utils.Warnf,
+		Debugf:           utils.Debugf,
+		OnResult:         onResult,
+	}
+}
+
+// printUnifiedDiff prints how content differs from what is in dest as a unified diff
+func printUnifiedDiff(dest string, content []byte) {
+	existing, err := ioutil.ReadFile(dest)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Could Not Read %s: %v\n", dest, err)
+		return
+	}
+	if looksBinary(existing) || looksBinary(content) {
+		fmt.Printf("Binary files %s and %s (built) differ\n", dest, dest)
+		return
+	}
+	from := dest
+	if os.IsNotExist(err) {
+		from = "/dev/null"
+	}
+	lines := build.DiffLines(splitDiffLines(existing), splitDiffLines(content))
+	fmt.Printf("--- %s\n+++ %s (built)\n", from, dest)
+
+	// positions of every line on either side, so hunks without a line on a side still know
+	// where they are
+	posA, posB := make([]int, len(lines)+1), make([]int, len(lines)+1)
+	for i, line := range lines {
+		posA[i+1], posB[i+1] = posA[i], posB[i]
+		if line.Op != build.DiffAdd {
+			posA[i+1]++
+		}
+		if line.Op != build.DiffRemove {
+			posB[i+1]++
+		}
+	}
+	for i := 0; i < len(lines); {
+		if lines[i].Op == build.DiffKeep {
+			i++
+			continue
+		}
+		// grow the hunk until there is a run of unchanged lines too long to show
+		start, end := i-diffContext, i
+		if start < 0 {
+			start = 0
+		}
+		for j := i; j < len(lines) && j-end <= 2*diffContext; j++ {
+			if lines[j].Op != build.DiffKeep {
+				end = j
+			}
+		}
+		stop := end + diffContext + 1
+		if stop > len(lines) {
+			stop = len(lines)
+		}
+		countA, countB := posA[stop]-posA[start], posB[stop]-posB[start]
+		startA, startB := posA[start], posB[start]
+		if countA > 0 {
+			startA++
+		}
+		if countB > 0 {
+			startB++
+		}
+		fmt.Printf("@@ -%d,%d +%d,%d @@\n", startA, countA, startB, countB)
+		for _, line := range lines[start:stop] {
+			fmt.Printf("%c%s\n", line.Op, line.Text)
+		}
+		i = stop
+	}
+}
+
+// splitDiffLines splits content into lines without their line endings
+func splitDiffLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+// looksBinary checks for a NUL byte near the start of content, like git does
+func looksBinary(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return bytes.IndexByte(content, 0) >= 0
+}
@@ -0,0 +1,107 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jwhitcraft/rome/build"
+	"github.com/spf13/cobra"
+)
+
+var (
+	explainFile    string
+	explainFlavor  string
+	explainVersion string
+)
+
+// explainCmd represents the explain command
+var explainCmd = &cobra.Command{
+	Use:   "explain [FLAGS] --explain-file FILE",
+	Short: "Show what a build does to a single file, line by line",
+	Long: `Builds FILE in memory and prints every line with what happened to it: the build tags, which
+lines each BEGIN keeps or drops for the flavor, where @_SUGAR_VERSION and @_SUGAR_FLAV were
+replaced, followed by the output a build would write. Nothing is written to the disk.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if explainFile == "" {
+			fmt.Println("--explain-file is required")
+			os.Exit(1)
+		}
+		if _, ok := build.Flavors[explainFlavor]; !ok {
+			fmt.Printf("Unknown flavor: %s\n", explainFlavor)
+			os.Exit(1)
+		}
+		if err := build.Define(defines); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		setBuildInfo(explainFile)
+
+		e, err := build.Explain(explainFile, explainFlavor, explainVersion)
+		if e != nil {
+			printExplanation(e, err == nil)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+// printExplanation writes the trace of e, the output is only printed when the whole file
+// could be built
+func printExplanation(e *build.Explanation, complete bool) {
+	fmt.Printf("%s for %s %s\n", e.Path, e.Flavor, e.Version)
+	if e.Note != "" {
+		fmt.Println(e.Note)
+	}
+	if !e.Processed || !e.Built {
+		return
+	}
+
+	fmt.Println()
+	for _, line := range e.Lines {
+		mark := "-"
+		if line.Kept {
+			mark = "+"
+		}
+		fmt.Printf("%5d %s %s\n", line.Line, mark, line.Source)
+		if line.Note != "" {
+			fmt.Printf("        %s\n", line.Note)
+		}
+	}
+
+	if complete {
+		fmt.Println("\nOutput:")
+		os.Stdout.Write(e.Output)
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(explainCmd)
+
+	explainCmd.Flags().StringVar(&explainFile, "explain-file", "", "The source file to explain")
+	explainCmd.Flags().StringVarP(&explainFlavor, "flavor", "f", "ent", "What Flavor of SugarCRM to build")
+	explainCmd.Flags().StringVarP(&explainVersion, "version", "v", "", "What Version is being built")
+	addBuildInfoFlags(explainCmd)
+	explainCmd.Flags().StringSliceVar(&defines, "define", nil, "Define a tag for BEGIN and FILE conditions to check besides flav, e.g. feature=newdash,license=aws")
+}
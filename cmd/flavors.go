@@ -0,0 +1,324 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jwhitcraft/rome/build"
+	"github.com/jwhitcraft/rome/utils"
+	"github.com/spf13/cobra"
+)
+
+// flavorsCmd represents the flavors command
+var flavorsCmd = &cobra.Command{
+	Use:   "flavors",
+	Short: "List the flavors that can be built",
+	Long: `Lists every flavor --flavor takes from the smallest to the largest, along with the flavors
+whose build tags it keeps. Each flavor keeps everything the flavors before it keep.
+
+Custom flavors are defined in the flavors section of .rome.yaml by listing the flavors each one is
+built on, they are listed after the ones SugarCRM ships with:
+
+	flavors:
+	  mycloud: [ent]`,
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, name := range build.FlavorOrder {
+			fmt.Printf("%-5s %s\n", name, strings.Join(build.Flavors[name], " < "))
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(flavorsCmd)
+}
+
+// flavorPlaceholder is replaced with the flavor in the destination of a build of several flavors
+const flavorPlaceholder = "{flavor}"
+
+// flavorsValue is the --flavor flag, the first value replaces the default and every other
+// -f is added to it so -f ent -f pro is the same as -f ent,pro
+type flavorsValue struct {
+	value *string
+	set   bool
+}
+
+func (f *flavorsValue) Set(value string) error {
+	if f.set {
+		*f.value += "," + value
+	} else {
+		*f.value = value
+	}
+	f.set = true
+	return nil
+}
+
+func (f *flavorsValue) String() string {
+	return *f.value
+}
+
+func (f *flavorsValue) Type() string {
+	return "string"
+}
+
+// buildFlavors is every flavor --flavor asked for when it asked for more than one
+var buildFlavors []string
+
+// splitFlavors splits a comma separated list of flavors, leaving out empty names
+func splitFlavors(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// checkFlavors makes sure a build of several flavors has a destination for each of them and
+// nothing that only makes sense for a single build
+func checkFlavors(names []string) error {
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			return fmt.Errorf("--flavor has %s more than once", name)
+		}
+		seen[name] = true
+		if _, err := mapFlavor(name, flavorMap); err != nil {
+			return err
+		}
+	}
+	if build.IsRemote(destination) {
+		return fmt.Errorf("building several flavors needs a local destination")
+	}
+	if !strings.Contains(destination, flavorPlaceholder) {
+		return fmt.Errorf("building several flavors needs %s in --destination so each flavor gets its own, like /builds/%s", flavorPlaceholder, flavorPlaceholder)
+	}
+	single := map[string]bool{
+		"--stdout":        toStdout,
+		"--dry-run":       dryRun,
+		"--output json":   outputFormat == "json",
+		"--resume":        resumePath != "" || resumeJournal,
+		"--checkpoint":    checkpointPath != "",
+		"--retry-from":    retryFrom != "",
+		"--cache":         useCache,
+		"--since-version": sinceVersion,
+		"--provenance":    provenancePath != "",
+		"--tui":           useTUI,
+		"--junit":         junitPath != "",
+		"--index":         indexPath != "",
+		"--trace":         tracePath != "",
+		"--summary-json":  summaryPath != "",
+		"--failures-out":  failuresOut != "",
+		"--metrics-addr":  metricsAddr != "",
+		"--report-no-op":  reportNoOp,
+		"--summary-depth": summaryDepth > 0,
+	}
+	var used []string
+	for name, ok := range single {
+		if ok {
+			used = append(used, name)
+		}
+	}
+	if len(used) > 0 {
+		sort.Strings(used)
+		return fmt.Errorf("%s only work with a single flavor", strings.Join(used, ", "))
+	}
+	return nil
+}
+
+// flavorBuild is one of the flavors of a build of several flavors
+type flavorBuild struct {
+	name        string
+	destination string
+	previous    *build.Manifest
+	manifest    *manifestRecorder
+}
+
+// prepareFlavor gets the destination of name ready the same way PreRun and Run do for a
+// single flavor and returns the options to build it with
+func prepareFlavor(name string) (*flavorBuild, build.Options, error) {
+	fb := &flavorBuild{name: name, destination: strings.Replace(destination, flavorPlaceholder, name, -1)}
+	opts := buildOptions()
+	mapped, err := mapFlavor(name, flavorMap)
+	if err != nil {
+		return nil, opts, err
+	}
+	opts.Flavor = mapped
+	opts.Destination = fb.destination
+
+	if destExists, err := exists(fb.destination); err != nil || !destExists {
+		fmt.Printf("Destination Path (%s) does not exists, Creating Now\n", fb.destination)
+		build.MakeDirs(fb.destination, dirMode)
+	} else if clean {
+		ok, readErr := confirmDelete(fb.destination, assumeYes)
+		if readErr != nil {
+			return nil, opts, fmt.Errorf("Could Not Read %s: %v", fb.destination, readErr)
+		}
+		if !ok {
+			return nil, opts, fmt.Errorf("Not cleaning %s, aborting", fb.destination)
+		}
+		if writeManifest {
+			// read before it is deleted so the build can still say what changed
+			fb.previous, _ = build.ReadManifest(fb.destination)
+		}
+		fmt.Println("Cleaning " + fb.destination)
+		if err := build.CleanBuild(fb.destination); err != nil {
+			return nil, opts, fmt.Errorf("Could Not Clean: %s", fb.destination)
+		}
+	} else {
+		checkStamp(fb.destination, name)
+		if writeManifest {
+			if fb.previous, err = build.ReadManifest(fb.destination); err != nil {
+				fmt.Printf("Could Not Read Previous Manifest of %s: %v\n", name, err)
+			}
+		}
+	}
+	if writeManifest {
+		fb.manifest = newManifestRecorder(fb.destination, fb.previous)
+		fb.manifest.current.Flavor = name
+		opts.OnResult = fb.manifest.Add
+	}
+	return fb, opts, nil
+}
+
+// runFlavors builds every flavor in buildFlavors into its own destination. The sources are
+// walked once and every file is handed to the workers of each flavor.
+func runFlavors() {
+	if preBuildHook != "" {
+		if err := runHook("pre-build", preBuildHook, hookEnv(0)); err != nil {
+			fmt.Println(err)
+			os.Exit(exitHookFailed)
+		}
+	}
+	builds := make([]*flavorBuild, len(buildFlavors))
+	opts := make([]build.Options, len(buildFlavors))
+	for i, name := range buildFlavors {
+		var err error
+		builds[i], opts[i], err = prepareFlavor(name)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("Starting Rome on %s for %s...\n", strings.Join(sources, ", "), strings.Join(buildFlavors, ", "))
+	start := time.Now()
+	// Ctrl-C and the deadline both stop new files from starting, anything being written is finished
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, deadline)
+		defer cancel()
+	}
+	results, errs := build.RunEach(ctx, opts)
+	if closeErr := build.CloseProcessors(processors); closeErr != nil {
+		fmt.Printf("Could Not Close Processors: %v\n", closeErr)
+	}
+	for i, result := range results {
+		if result == nil && errs[i] != nil {
+			if errors.Is(errs[i], build.ErrSourceMissing) {
+				fmt.Printf("\n\n%v!!\n\n", errs[i])
+				os.Exit(401)
+			}
+			fmt.Printf("%s: %v\n", builds[i].name, errs[i])
+			os.Exit(1)
+		}
+	}
+
+	var built int32
+	var failed, warned bool
+	var stopped error
+	for i, fb := range builds {
+		result := results[i]
+		built += result.Built
+		fmt.Printf("Built %d files of %s into %s\n", result.Built, fb.name, fb.destination)
+		if result.Failed > 0 {
+			reportErrors(result.Failures, maxErrorsShown)
+		}
+		if len(result.Warnings) > 0 {
+			fmt.Printf("%d warnings in %s\n", len(result.Warnings), fb.name)
+		}
+		// with --warnings-as-errors any warning fails the build, but only once everything is reported
+		flavorFailed := result.Failed > 0 || (warningsAsErrors && len(result.Warnings) > 0)
+		failed = failed || flavorFailed
+		warned = warned || len(result.Warnings) > 0
+		if errs[i] != nil {
+			stopped = errs[i]
+			continue
+		}
+		if !flavorFailed {
+			stampSources := make([]string, len(sources))
+			for i, source := range sources {
+				stampSources[i] = absPath(source)
+			}
+			stamp := build.Stamp{Flavor: fb.name, Version: version, Sources: stampSources, Built: time.Now()}
+			if err := build.WriteStamp(fb.destination, stamp); err != nil {
+				fmt.Printf("Could Not Write Build Stamp of %s: %v\n", fb.name, err)
+			}
+		}
+		if fb.manifest != nil {
+			if err := build.WriteManifest(fb.destination, fb.manifest.current); err != nil {
+				fmt.Printf("Could Not Write Manifest of %s: %v\n", fb.name, err)
+			}
+			if fb.previous != nil {
+				diff := build.DiffManifests(fb.previous, fb.manifest.current)
+				fmt.Printf("Since the last %s build: %d added, %d removed, %d changed\n", fb.name, len(diff.Added), len(diff.Removed), len(diff.Changed))
+			}
+		}
+	}
+	utils.TimeTrack(start)
+	if warningsAsErrors && warned {
+		fmt.Println("Failing the build because of --warnings-as-errors")
+	}
+	if postBuildHook != "" && ((!failed && stopped == nil) || alwaysRunHooks) {
+		if err := runHook("post-build", postBuildHook, hookEnv(built)); err != nil {
+			fmt.Println(err)
+			os.Exit(exitHookFailed)
+		}
+	}
+	switch {
+	case stopped == nil:
+	case errors.Is(stopped, build.ErrTooManyFailures):
+		fmt.Println("Aborted after too many failures")
+		os.Exit(exitBuildFailed)
+	case errors.Is(stopped, build.ErrNoSpace):
+		fmt.Println("A destination ran out of disk space, stopped the build")
+		os.Exit(exitNoSpace)
+	case errors.Is(stopped, context.DeadlineExceeded):
+		fmt.Printf("Timed out after %s\n", deadline)
+		os.Exit(exitTimedOut)
+	default:
+		fmt.Println("Interrupted before every flavor was built")
+		os.Exit(exitInterrupted)
+	}
+	if failed {
+		os.Exit(exitBuildFailed)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// hookFlags are the flags the keys of the hooks section of the config file set
+var hookFlags = map[string]string{
+	"pre_build":  "pre-build",
+	"post_build": "post-build",
+}
+
+// applyHookConfig sets --pre-build and --post-build from the hooks section of the config file
+// when they were not given any other way
+//
+//	hooks:
+//	  pre_build: make deps
+//	  post_build: chmod -R g+w cache
+func applyHookConfig(cmd *cobra.Command) error {
+	hooks := viper.GetStringMap("hooks")
+	settings := make(map[string]interface{}, len(hooks))
+	for key, value := range hooks {
+		flag, ok := hookFlags[key]
+		if !ok {
+			return fmt.Errorf("the hooks section of the config file has %s, it only takes pre_build and post_build", key)
+		}
+		settings[flag] = value
+	}
+	return setFlags(cmd, settings, "the hooks section of the config file")
+}
+
+// hookFlagAliases lets --pre-hook and --post-hook stand in for --pre-build and --post-build
+func hookFlagAliases(f *pflag.FlagSet, name string) pflag.NormalizedName {
+	switch name {
+	case "pre-hook":
+		name = "pre-build"
+	case "post-hook":
+		name = "post-build"
+	}
+	return pflag.NormalizedName(name)
+}
+
+// hookEnv is the environment handed to the pre and post build hooks
+func hookEnv(builtCount int32) []string {
+	return append(os.Environ(),
+		"ROME_SOURCE="+strings.Join(sources, string(os.PathListSeparator)),
+		"ROME_DESTINATION="+destination,
+		"ROME_FLAVOR="+flavor,
+		"ROME_VERSION="+version,
+		"ROME_BUILT_COUNT="+strconv.Itoa(int(builtCount)),
+	)
+}
+
+// shellArgs runs command through the shell
+func shellArgs(command string) []string {
+	if runtime.GOOS == "windows" {
+		return []string{"cmd", "/C", command}
+	}
+	return []string{"sh", "-c", command}
+}
+
+// runHook runs command through the shell with its output streamed to ours
+func runHook(name string, command string, env []string) error {
+	fmt.Printf("Running %s hook: %s\n", name, command)
+	args := shellArgs(command)
+	hook := exec.Command(args[0], args[1:]...)
+	hook.Env = env
+	hook.Stdin = os.Stdin
+	hook.Stdout = os.Stdout
+	hook.Stderr = os.Stderr
+	if err := hook.Run(); err != nil {
+		return fmt.Errorf("%s hook failed: %v", name, err)
+	}
+	return nil
+}
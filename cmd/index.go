@@ -0,0 +1,81 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/jwhitcraft/rome/build"
+)
+
+// indexEntry maps a built file back to the source it came from
+type indexEntry struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Flavor      string `json:"flavor"`
+	Version     string `json:"version"`
+	Bytes       int64  `json:"bytes"`
+	Symlink     bool   `json:"symlink"`
+}
+
+// buildIndex collects every file written during a build so editors can jump from a built
+// file to its source
+type buildIndex struct {
+	entries []indexEntry
+}
+
+// Add records a single file result, files that were skipped or failed are left out
+func (idx *buildIndex) Add(r build.FileResult) {
+	if r.Err != nil || r.Skipped {
+		return
+	}
+	idx.entries = append(idx.entries, indexEntry{
+		Source:      absPath(r.Source),
+		Destination: absPath(r.Destination),
+		Flavor:      flavor,
+		Version:     version,
+		Bytes:       r.Bytes,
+		Symlink:     r.Link,
+	})
+}
+
+// Write saves the index as a JSON array to path
+func (idx *buildIndex) Write(path string) error {
+	entries := idx.entries
+	if entries == nil {
+		entries = []indexEntry{}
+	}
+	out, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(out, '\n'), 0644)
+}
+
+// absPath makes path absolute when it can
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,117 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jwhitcraft/rome/build"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// junitReport collects build results and writes them out as a JUnit XML file
+type junitReport struct {
+	verbose bool
+	started time.Time
+	cases   []junitTestCase
+	tests   int
+	failed  int
+}
+
+func newJunitReport(verbose bool) *junitReport {
+	return &junitReport{verbose: verbose, started: time.Now()}
+}
+
+// Add records a single file result, passing files are only kept when verbose
+func (j *junitReport) Add(r build.FileResult) {
+	j.tests++
+	if r.Err == nil && !j.verbose {
+		return
+	}
+	tc := junitTestCase{
+		ClassName: "rome." + flavor,
+		Name:      r.Path,
+		Time:      fmt.Sprintf("%.3f", r.Duration.Seconds()),
+	}
+	if r.Err != nil {
+		j.failed++
+		tc.Failure = &junitFailure{Message: r.Err.Error(), Type: fmt.Sprintf("%T", r.Err), Body: r.Source}
+	}
+	j.cases = append(j.cases, tc)
+}
+
+// Write saves the report to path
+func (j *junitReport) Write(path string, elapsed time.Duration) error {
+	report := junitTestSuites{
+		Suites: []junitTestSuite{{
+			Name:      "rome build " + flavor + " " + version,
+			Tests:     j.tests,
+			Failures:  j.failed,
+			Time:      fmt.Sprintf("%.3f", elapsed.Seconds()),
+			Timestamp: j.started.Format("2006-01-02T15:04:05"),
+			TestCases: j.cases,
+		}},
+	}
+
+	out, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(xml.Header); err != nil {
+		return err
+	}
+	_, err = f.Write(append(out, '\n'))
+	return err
+}
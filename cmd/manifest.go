@@ -0,0 +1,60 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"path/filepath"
+
+	"github.com/jwhitcraft/rome/build"
+)
+
+// manifestRecorder builds the manifest of a build from its results
+type manifestRecorder struct {
+	dest     string
+	previous *build.Manifest
+	current  *build.Manifest
+}
+
+func newManifestRecorder(dest string, previous *build.Manifest) *manifestRecorder {
+	return &manifestRecorder{dest: dest, previous: previous, current: build.NewManifest(flavor, version)}
+}
+
+// Add records a single file result, a file that was skipped because it is already in the
+// destination keeps what the previous manifest knew about it
+func (m *manifestRecorder) Add(r build.FileResult) {
+	if r.Err != nil || r.Link || r.Destination == "" {
+		return
+	}
+	rel, err := filepath.Rel(m.dest, r.Destination)
+	if err != nil {
+		return
+	}
+	rel = filepath.ToSlash(rel)
+	if r.Skipped {
+		if m.previous != nil {
+			if entry, ok := m.previous.Files[rel]; ok {
+				m.current.Files[rel] = entry
+			}
+		}
+		return
+	}
+	m.current.Files[rel] = build.ManifestEntry{SHA256: r.SHA256, Size: r.Bytes, VersionStable: !r.VersionSensitive}
+}
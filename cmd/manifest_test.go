@@ -0,0 +1,62 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jwhitcraft/rome/build"
+)
+
+func TestManifestRecorder(t *testing.T) {
+	defer func(f, v string) { flavor, version = f, v }(flavor, version)
+	flavor, version = "ent", "7.1"
+
+	dest := t.TempDir()
+	previous := build.NewManifest("ent", "7.0")
+	previous.Files["stable.php"] = build.ManifestEntry{SHA256: "old", Size: 3, VersionStable: true}
+	m := newManifestRecorder(dest, previous)
+
+	m.Add(build.FileResult{Destination: filepath.Join(dest, "stable.php"), Skipped: true})
+	m.Add(build.FileResult{Destination: filepath.Join(dest, "modules", "version.php"), SHA256: "new", Bytes: 5, VersionSensitive: true})
+	m.Add(build.FileResult{Destination: filepath.Join(dest, "plain.php"), SHA256: "plain", Bytes: 1})
+	m.Add(build.FileResult{Destination: filepath.Join(dest, "gone.php"), Skipped: true})
+	m.Add(build.FileResult{Destination: filepath.Join(dest, "link.php"), Link: true})
+
+	want := map[string]build.ManifestEntry{
+		// left alone by --since-version, so it keeps what the last build wrote
+		"stable.php":          {SHA256: "old", Size: 3, VersionStable: true},
+		"modules/version.php": {SHA256: "new", Size: 5},
+		"plain.php":           {SHA256: "plain", Size: 1, VersionStable: true},
+	}
+	if len(m.current.Files) != len(want) {
+		t.Errorf("recorded %v, want %v", m.current.Files, want)
+	}
+	for rel, entry := range want {
+		if got := m.current.Files[rel]; got != entry {
+			t.Errorf("%s is %+v, want %+v", rel, got, entry)
+		}
+	}
+	if m.current.Flavor != "ent" || m.current.Version != "7.1" {
+		t.Errorf("the manifest is for %s %s, want ent 7.1", m.current.Flavor, m.current.Version)
+	}
+}
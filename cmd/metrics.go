@@ -0,0 +1,134 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jwhitcraft/rome/build"
+	"github.com/jwhitcraft/rome/utils"
+)
+
+// buildDurationBuckets are the upper bounds, in seconds, of the build duration histogram
+var buildDurationBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+// buildMetrics keeps the counters that are exposed to Prometheus in its text format
+type buildMetrics struct {
+	built   utils.Counter
+	failed  utils.Counter
+	skipped utils.Counter
+
+	mu            sync.Mutex
+	bucketCounts  []uint64
+	durationSum   float64
+	durationCount uint64
+	lastBuild     time.Time
+}
+
+func newBuildMetrics() *buildMetrics {
+	return &buildMetrics{bucketCounts: make([]uint64, len(buildDurationBuckets))}
+}
+
+// Add counts a single file result
+func (m *buildMetrics) Add(r build.FileResult) {
+	switch {
+	case r.Err != nil:
+		m.failed.Increment()
+	case r.Skipped:
+		m.skipped.Increment()
+	default:
+		m.built.Increment()
+	}
+}
+
+// ObserveBuild records how long a whole build took
+func (m *buildMetrics) ObserveBuild(elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seconds := elapsed.Seconds()
+	for i, bound := range buildDurationBuckets {
+		if seconds <= bound {
+			m.bucketCounts[i]++
+		}
+	}
+	m.durationSum += seconds
+	m.durationCount++
+	m.lastBuild = time.Now()
+}
+
+func (m *buildMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP rome_files_built_total Files built successfully.")
+	fmt.Fprintln(w, "# TYPE rome_files_built_total counter")
+	fmt.Fprintf(w, "rome_files_built_total %d\n", m.built.Get())
+	fmt.Fprintln(w, "# HELP rome_files_failed_total Files that failed to build.")
+	fmt.Fprintln(w, "# TYPE rome_files_failed_total counter")
+	fmt.Fprintf(w, "rome_files_failed_total %d\n", m.failed.Get())
+	fmt.Fprintln(w, "# HELP rome_files_skipped_total Files that were skipped.")
+	fmt.Fprintln(w, "# TYPE rome_files_skipped_total counter")
+	fmt.Fprintf(w, "rome_files_skipped_total %d\n", m.skipped.Get())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintln(w, "# HELP rome_build_duration_seconds How long whole builds took.")
+	fmt.Fprintln(w, "# TYPE rome_build_duration_seconds histogram")
+	for i, bound := range buildDurationBuckets {
+		fmt.Fprintf(w, "rome_build_duration_seconds_bucket{le=\"%g\"} %d\n", bound, m.bucketCounts[i])
+	}
+	fmt.Fprintf(w, "rome_build_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.durationCount)
+	fmt.Fprintf(w, "rome_build_duration_seconds_sum %g\n", m.durationSum)
+	fmt.Fprintf(w, "rome_build_duration_seconds_count %d\n", m.durationCount)
+	fmt.Fprintln(w, "# HELP rome_last_build_timestamp_seconds When the last build finished.")
+	fmt.Fprintln(w, "# TYPE rome_last_build_timestamp_seconds gauge")
+	var last int64
+	if !m.lastBuild.IsZero() {
+		last = m.lastBuild.Unix()
+	}
+	fmt.Fprintf(w, "rome_last_build_timestamp_seconds %d\n", last)
+}
+
+// serveMetrics starts serving m on addr until ctx is done, the returned channel is
+// closed once the server has shut down
+func serveMetrics(ctx context.Context, addr string, m *buildMetrics) <-chan struct{} {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	done := make(chan struct{})
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("Metrics server on %s stopped: %v\n", addr, err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+		close(done)
+	}()
+	return done
+}
@@ -0,0 +1,104 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jwhitcraft/rome/build"
+)
+
+// moduleStats adds up the files built under one folder of the destination
+type moduleStats struct {
+	Module  string `json:"module"`
+	Files   int    `json:"files"`
+	Bytes   int64  `json:"bytes"`
+	Failed  int    `json:"failed"`
+	Skipped int    `json:"skipped"`
+}
+
+// moduleSummaries groups the results of a build by the first depth folders of their path in
+// the destination, files above that depth are grouped by the folder they are in
+type moduleSummaries struct {
+	depth   int
+	modules map[string]*moduleStats
+}
+
+func newModuleSummaries(depth int) *moduleSummaries {
+	return &moduleSummaries{depth: depth, modules: make(map[string]*moduleStats)}
+}
+
+// Add records a single file result
+func (m *moduleSummaries) Add(r build.FileResult) {
+	rel := r.Path
+	if r.Destination != "" {
+		if destRel, err := filepath.Rel(destination, r.Destination); err == nil {
+			rel = destRel
+		}
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	parts = parts[:len(parts)-1]
+	if len(parts) > m.depth {
+		parts = parts[:m.depth]
+	}
+	module := strings.Join(parts, "/")
+	if module == "" {
+		module = "."
+	}
+
+	stats, ok := m.modules[module]
+	if !ok {
+		stats = &moduleStats{Module: module}
+		m.modules[module] = stats
+	}
+	stats.Files++
+	stats.Bytes += r.Bytes
+	switch {
+	case r.Err != nil:
+		stats.Failed++
+	case r.Skipped:
+		stats.Skipped++
+	}
+}
+
+// Sorted returns the stats of every module sorted by name, nil when there are no summaries
+func (m *moduleSummaries) Sorted() []moduleStats {
+	if m == nil {
+		return nil
+	}
+	stats := make([]moduleStats, 0, len(m.modules))
+	for _, s := range m.modules {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Module < stats[j].Module })
+	return stats
+}
+
+// Print writes the stats as a table
+func (m *moduleSummaries) Print() {
+	fmt.Printf("  %-40s %8s %14s %8s %8s\n", "module", "files", "bytes", "failed", "skipped")
+	for _, s := range m.Sorted() {
+		fmt.Printf("  %-40s %8d %14d %8d %8d\n", s.Module, s.Files, s.Bytes, s.Failed, s.Skipped)
+	}
+}
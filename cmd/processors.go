@@ -0,0 +1,82 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/jwhitcraft/rome/build"
+	"github.com/spf13/viper"
+)
+
+// processors transform the files they match after they are built, they come from the config file
+var processors []build.Processor
+
+// processorConfig is an entry of the processors section of the config file
+type processorConfig struct {
+	Name   string
+	Match  []string
+	Exec   string
+	Plugin string
+}
+
+// loadProcessors makes the processors in the processors section of the config file, they run
+// in the order they are listed. exec is a shell command that speaks JSON over stdin and stdout,
+// see build.ExecProcessor, and plugin is a Go plugin, see build.LoadPlugin.
+//
+//	processors:
+//	  - name: license-header
+//	    match: ["*.php", "include/**/*.js"]
+//	    exec: stamp-header --year 2026
+//	  - name: scrub
+//	    match: ["*.php"]
+//	    plugin: /opt/rome/scrub.so
+func loadProcessors() ([]build.Processor, error) {
+	var configs []processorConfig
+	if err := viper.UnmarshalKey("processors", &configs); err != nil {
+		return nil, fmt.Errorf("the processors section of the config file: %v", err)
+	}
+	var loaded []build.Processor
+	for i, c := range configs {
+		if c.Name == "" {
+			return nil, fmt.Errorf("processor %d of the config file needs a name", i+1)
+		}
+		var p build.Processor
+		var err error
+		switch {
+		case c.Exec != "" && c.Plugin != "":
+			return nil, fmt.Errorf("processor %s can have an exec or a plugin, not both", c.Name)
+		case c.Exec != "":
+			p, err = build.NewExecProcessor(c.Name, c.Match, shellArgs(c.Exec))
+		case c.Plugin != "":
+			if p, err = build.LoadPlugin(c.Plugin); err == nil && len(c.Match) > 0 {
+				p, err = build.Globbed(p, c.Match)
+			}
+		default:
+			return nil, fmt.Errorf("processor %s needs an exec or a plugin", c.Name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("processor %s: %v", c.Name, err)
+		}
+		loaded = append(loaded, p)
+	}
+	return loaded, nil
+}
@@ -0,0 +1,84 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// applyProfile sets the flags of cmd from the named profile in the profiles section of the
+// config file, flags given on the command line keep their value
+//
+//	profiles:
+//	  release:
+//	    clean: true
+//	    exclude: ["tests/**", "*.md"]
+func applyProfile(cmd *cobra.Command, name string) error {
+	profiles := viper.GetStringMap("profiles")
+	if _, ok := profiles[strings.ToLower(name)]; !ok {
+		var names []string
+		for profile := range profiles {
+			names = append(names, profile)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return fmt.Errorf("profile %q does not exist, the config file does not have any profiles", name)
+		}
+		return fmt.Errorf("profile %q does not exist, use one of: %s", name, strings.Join(names, ", "))
+	}
+
+	settings := viper.Sub("profiles." + strings.ToLower(name)).AllSettings()
+	return setFlags(cmd, settings, fmt.Sprintf("profile %q", name))
+}
+
+// setFlags sets the flags of cmd from settings read from the config file, flags that were
+// already changed keep their value. what says where the settings come from for errors.
+func setFlags(cmd *cobra.Command, settings map[string]interface{}, what string) error {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		flag := cmd.Flags().Lookup(key)
+		if flag == nil {
+			return fmt.Errorf("%s sets %s, which is not a flag of %s", what, key, cmd.Name())
+		}
+		if flag.Changed {
+			continue
+		}
+		values, ok := settings[key].([]interface{})
+		if !ok {
+			values = []interface{}{settings[key]}
+		}
+		for _, value := range values {
+			if err := cmd.Flags().Set(key, fmt.Sprint(value)); err != nil {
+				return fmt.Errorf("%s sets %s: %v", what, key, err)
+			}
+		}
+	}
+	return nil
+}
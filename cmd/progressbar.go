@@ -0,0 +1,125 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jwhitcraft/rome/build"
+)
+
+// progressBarWidth is how many characters the bar itself takes up
+const progressBarWidth = 30
+
+// progressBar redraws a single line on a terminal with how far a build has got: the files
+// done out of the total from a scan of the sources, the throughput and how long is left
+type progressBar struct {
+	out      io.Writer
+	progress *build.Progress
+	stop     chan struct{}
+	finished chan struct{}
+}
+
+func newProgressBar(out io.Writer, progress *build.Progress) *progressBar {
+	return &progressBar{
+		out:      out,
+		progress: progress,
+		stop:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+}
+
+// Start redraws the bar every 200ms until Stop is called
+func (b *progressBar) Start() {
+	go func() {
+		defer close(b.finished)
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				b.draw()
+			case <-b.stop:
+				// clear the bar so the report after the build starts on an empty line
+				io.WriteString(b.out, "\r\x1b[2K")
+				return
+			}
+		}
+	}()
+}
+
+// Stop takes the bar off the terminal
+func (b *progressBar) Stop() {
+	close(b.stop)
+	<-b.finished
+}
+
+func (b *progressBar) draw() {
+	s := b.progress.Snapshot()
+	seconds := s.Elapsed.Seconds()
+	if seconds == 0 {
+		seconds = 1
+	}
+	rate := fmt.Sprintf("%.1f MB/s", float64(s.Bytes)/seconds/(1<<20))
+
+	var line string
+	if s.Total == 0 {
+		// the scan of the sources is not done yet
+		line = fmt.Sprintf("%d files, %s, counting files...", s.Done, rate)
+	} else {
+		done := s.Done
+		if done > s.Total {
+			done = s.Total
+		}
+		filled := progressBarWidth * done / s.Total
+		bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+		line = fmt.Sprintf("[%s] %d/%d files %3d%%, %s", bar, done, s.Total, 100*done/s.Total, rate)
+		if eta := s.ETA(); eta > 0 {
+			line += ", " + eta.Round(time.Second).String() + " left"
+		}
+	}
+	if s.Failed > 0 {
+		line += fmt.Sprintf(", %d failed", s.Failed)
+	}
+	io.WriteString(b.out, "\r\x1b[2K"+line)
+}
+
+// scanTotal counts what a build of the sources goes through in the background and hands
+// it to progress, a build that is done before the scan simply never shows a total
+func scanTotal(progress *build.Progress, opts build.Options) {
+	go func() {
+		stats, err := build.Stat(opts, 0)
+		if err != nil {
+			return
+		}
+		total := stats.Files + stats.Symlinks
+		switch {
+		case opts.OnlySymlinks:
+			total = stats.Symlinks
+		case opts.NoSymlinks:
+			total = stats.Files
+		}
+		progress.SetTotal(total)
+	}()
+}
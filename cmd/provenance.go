@@ -0,0 +1,86 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/jwhitcraft/rome/build"
+)
+
+// provenance records how a build was produced so the destination can be tied back to its
+// inputs
+type provenance struct {
+	Builder    provenanceBuilder  `json:"builder"`
+	Invocation []string           `json:"invocation"`
+	Sources    []provenanceSource `json:"sources"`
+	Flavor     string             `json:"flavor"`
+	Version    string             `json:"version"`
+	Started    time.Time          `json:"started"`
+	Finished   time.Time          `json:"finished"`
+	Manifest   provenanceManifest `json:"manifest"`
+}
+
+type provenanceBuilder struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time"`
+}
+
+// provenanceSource is a source folder and, when it is in git, the commit it was at
+type provenanceSource struct {
+	Path   string `json:"path"`
+	Commit string `json:"commit,omitempty"`
+	Dirty  bool   `json:"dirty,omitempty"`
+}
+
+type provenanceManifest struct {
+	Files  int    `json:"files"`
+	SHA256 string `json:"sha256"`
+}
+
+// writeProvenance saves the provenance of a build that started at started and produced
+// manifest as JSON to path
+func writeProvenance(path string, started time.Time, manifest *build.Manifest) error {
+	p := provenance{
+		Builder:    provenanceBuilder{Version: Version, Commit: Commit, BuildTime: BuildTime},
+		Invocation: os.Args,
+		Flavor:     flavor,
+		Version:    version,
+		Started:    started,
+		Finished:   time.Now(),
+		Manifest:   provenanceManifest{Files: len(manifest.Files), SHA256: manifest.Digest()},
+	}
+	for _, source := range sources {
+		s := provenanceSource{Path: absPath(source)}
+		// sources that are not in git are recorded by path only
+		s.Commit, s.Dirty, _ = build.SourceCommit(source)
+		p.Sources = append(p.Sources, s)
+	}
+	out, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(out, '\n'), 0644)
+}
@@ -0,0 +1,107 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jwhitcraft/rome/build"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rebuildFlavor  string
+	rebuildVersion string
+)
+
+// rebuildCmd represents the rebuild command
+var rebuildCmd = &cobra.Command{
+	Use:   "rebuild [FLAGS] DESTINATION...",
+	Short: "Build destinations again with the sources, flavor and version they were last built with",
+	Long: `Reads the build stamp (` + build.StampFile + `) a successful build leaves in each DESTINATION and runs
+the same build again. --flavor and --version build something else from the same sources instead.
+Every stamp is checked before anything is built.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			fmt.Println("At least one DESTINATION is required")
+			os.Exit(1)
+		}
+
+		stamps := make([]*build.Stamp, len(args))
+		for i, dest := range args {
+			stamp, err := readRebuildStamp(dest)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			stamps[i] = stamp
+		}
+
+		for i, dest := range args {
+			stamp := stamps[i]
+			stampFlavor := stamp.Flavor
+			if rebuildFlavor != "" {
+				stampFlavor = rebuildFlavor
+			}
+			stampVersion := stamp.Version
+			if cmd.Flags().Changed("version") {
+				stampVersion = rebuildVersion
+			}
+			// set like flags on the command line so the config file does not override them
+			buildCmd.Flags().Set("destination", dest)
+			buildCmd.Flags().Set("flavor", stampFlavor)
+			buildCmd.Flags().Set("version", stampVersion)
+			fmt.Printf("Rebuilding %s %s into %s\n", flavor, version, destination)
+			buildCmd.PreRun(buildCmd, stamp.Sources)
+			buildCmd.Run(buildCmd, stamp.Sources)
+		}
+	},
+}
+
+// readRebuildStamp reads the stamp in dest and makes sure every source it recorded is still there
+func readRebuildStamp(dest string) (*build.Stamp, error) {
+	stamp, err := build.ReadStamp(dest)
+	if err != nil {
+		return nil, fmt.Errorf("Could Not Read Build Stamp in %s: %v", dest, err)
+	}
+	if stamp == nil {
+		return nil, fmt.Errorf("%s has no build stamp (%s), only a destination a build finished in can be rebuilt", dest, build.StampFile)
+	}
+	if len(stamp.Sources) == 0 {
+		return nil, fmt.Errorf("The build stamp in %s does not record any sources", dest)
+	}
+	for _, source := range stamp.Sources {
+		if ok, _ := exists(source); !ok {
+			return nil, fmt.Errorf("Source Path (%s) recorded in the build stamp of %s does not exist anymore", source, dest)
+		}
+	}
+	return stamp, nil
+}
+
+func init() {
+	RootCmd.AddCommand(rebuildCmd)
+
+	rebuildCmd.Flags().StringVarP(&rebuildFlavor, "flavor", "f", "", "Build this flavor instead of the one in the stamp")
+	rebuildCmd.Flags().StringVarP(&rebuildVersion, "version", "v", "", "Build this version instead of the one in the stamp")
+	rebuildCmd.Flags().BoolVar(&clean, "clean", false, "Remove Existing Build Before Building")
+	rebuildCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Do not ask before --clean deletes an existing build")
+}
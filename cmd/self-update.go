@@ -21,33 +21,288 @@
 package cmd
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"github.com/sanbornm/go-selfupdate/selfupdate"
 )
 
+// updateChannels maps every release channel to where its releases are published, always over
+// https since the hash the download is checked against comes from the same place
+var updateChannels = map[string]string{
+	"stable": "https://h2ik.co/",
+	"beta":   "https://h2ik.co/beta/",
+}
+
+var (
+	updateChannel      string
+	updateInBackground bool
+	updateRetries      int
+)
+
 // self-updateCmd represents the self-update command
 var selfUpdateCmd = &cobra.Command{
 	Use:   "self-update",
 	Short: "Update Rome if a new version exists",
-	Long: `This will allow Rome to update it's self like copmoser or other new fangled tools do`,
+	Long: `This will allow Rome to update it's self like copmoser or other new fangled tools do.
+The channel defaults to self-update.channel in ~/.rome.yaml and then stable. Each step of
+the update is reported and downloads that fail because of the network are retried, --background
+runs the update quietly instead.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		// TODO: Work your own magic here
-		fmt.Println("self-update called")
-		var updater = &selfupdate.Updater{
-			CurrentVersion: Version,
-			ApiURL:         "http://h2ik.co/",
-			BinURL:         "http://h2ik.co/",
-			DiffURL:        "http://h2ik.co/",
-			Dir:            "update/",
-			CmdName:        "rome", // app name
-		}
-
-		updater.BackgroundRun()
+		channel := updateChannel
+		if !cmd.Flags().Changed("channel") && viper.IsSet("self-update.channel") {
+			channel = viper.GetString("self-update.channel")
+		}
+		baseURL, ok := updateChannels[channel]
+		if !ok {
+			names := make([]string, 0, len(updateChannels))
+			for name := range updateChannels {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			fmt.Printf("Unknown update channel %q, must be one of %v\n", channel, names)
+			os.Exit(1)
+		}
+
+		if updateInBackground {
+			backgroundUpdate(baseURL)
+			return
+		}
+		if Version == "dev" {
+			fmt.Println("Development builds are never updated")
+			return
+		}
+		if err := runUpdate(baseURL, channel); err != nil {
+			fmt.Printf("Could Not Update: %v\n", err)
+			os.Exit(1)
+		}
 	},
 }
 
+// backgroundUpdate hands the whole update to the updater like rome always did, it only
+// checks for a new version when the last check is old enough and nothing is reported
+func backgroundUpdate(baseURL string) {
+	var updater = &selfupdate.Updater{
+		CurrentVersion: Version,
+		ApiURL:         baseURL,
+		BinURL:         baseURL,
+		DiffURL:        baseURL,
+		Dir:            "update/",
+		CmdName:        "rome", // app name
+	}
+
+	updater.BackgroundRun()
+}
+
+// updatePlatform names the binaries built for this OS and architecture on a channel
+const updatePlatform = runtime.GOOS + "-" + runtime.GOARCH
+
+// updateInfo is what a channel publishes about its latest release for a platform
+type updateInfo struct {
+	Version string
+	Sha256  []byte
+}
+
+// runUpdate updates the running binary to the latest release on the channel at baseURL, the
+// same layout the selfupdate package reads, and reports every step along the way
+func runUpdate(baseURL string, channel string) error {
+	var info updateInfo
+	err := updatePhase("Checking the "+channel+" channel", func() (string, error) {
+		body, err := fetchWithRetry(baseURL + "rome/" + url.QueryEscape(updatePlatform) + ".json")
+		if err != nil {
+			return "", err
+		}
+		if err := json.Unmarshal(body, &info); err != nil {
+			return "", fmt.Errorf("release info is not valid: %v", err)
+		}
+		if info.Version == "" || len(info.Sha256) != sha256.Size {
+			return "", fmt.Errorf("release info has no version or hash")
+		}
+		if info.Version == Version {
+			return "already on the latest version, " + Version, nil
+		}
+		return info.Version + " is available", nil
+	})
+	if err != nil || info.Version == Version {
+		return err
+	}
+
+	var bin []byte
+	err = updatePhase("Downloading "+info.Version, func() (string, error) {
+		body, err := fetchWithRetry(baseURL + "rome/" + url.QueryEscape(info.Version) + "/" + url.QueryEscape(updatePlatform) + ".gz")
+		if err != nil {
+			return "", err
+		}
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return "", fmt.Errorf("download is not gzipped: %v", err)
+		}
+		if bin, err = ioutil.ReadAll(gz); err != nil {
+			return "", fmt.Errorf("download is not gzipped: %v", err)
+		}
+		return fmt.Sprintf("%.1f MB", float64(len(bin))/(1<<20)), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = updatePhase("Verifying the download", func() (string, error) {
+		if sum := sha256.Sum256(bin); !bytes.Equal(sum[:], info.Sha256) {
+			return "", fmt.Errorf("the sha256 does not match the release")
+		}
+		return "sha256 matches", nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = updatePhase("Applying the update", func() (string, error) {
+		return "done", applyUpdate(bin)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Updated from %s to %s, the latest %s version\n", Version, info.Version, channel)
+	return nil
+}
+
+// updatePhase prints what run did for the step called name, or how it failed
+func updatePhase(name string, run func() (string, error)) error {
+	fmt.Printf("%s... ", name)
+	result, err := run()
+	if err != nil {
+		fmt.Println("failed")
+		return fmt.Errorf("%s: %v", strings.ToLower(name[:1])+name[1:], err)
+	}
+	fmt.Println(result)
+	return nil
+}
+
+// transientError is a failure that may go away when the request is made again
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+// fetchWithRetry gets url, trying again with a growing wait up to updateRetries times when
+// the network or the server fails
+func fetchWithRetry(url string) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		body, err := fetchUpdate(url)
+		var transient *transientError
+		if err == nil || !errors.As(err, &transient) || attempt > updateRetries {
+			return body, err
+		}
+		wait := time.Duration(attempt) * time.Second
+		fmt.Printf("%v, trying again in %s... ", err, wait)
+		time.Sleep(wait)
+	}
+}
+
+// fetchUpdate gets url, which has to be https like anything it redirects to, the release and
+// its hash are only worth something when nobody could have changed them on the way
+func fetchUpdate(url string) ([]byte, error) {
+	if !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("%s: updates are only downloaded over https", url)
+	}
+	client := http.Client{
+		Timeout: 5 * time.Minute,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Scheme != "https" {
+				return fmt.Errorf("redirected to %s: updates are only downloaded over https", req.URL)
+			}
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, &transientError{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("%s: %s", url, resp.Status)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return nil, &transientError{err}
+		}
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &transientError{fmt.Errorf("%s: %v", url, err)}
+	}
+	return body, nil
+}
+
+// applyUpdate swaps the running executable for bin, the old one is put back when the new one
+// can not be moved in
+func applyUpdate(bin []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(exe), ".rome-update-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bin); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		os.Rename(old, exe)
+		return err
+	}
+	// windows does not let a running executable be removed, it is cleaned up next time
+	os.Remove(old)
+	return nil
+}
+
 func init() {
 	RootCmd.AddCommand(selfUpdateCmd)
+
+	selfUpdateCmd.Flags().StringVar(&updateChannel, "channel", "stable", "Which releases to update to: stable or beta")
+	selfUpdateCmd.Flags().BoolVar(&updateInBackground, "background", false, "Update without reporting each step or retrying, like older versions did")
+	selfUpdateCmd.Flags().IntVar(&updateRetries, "retries", 3, "How many times to retry a download that failed because of the network or the server")
 }
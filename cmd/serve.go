@@ -0,0 +1,314 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jwhitcraft/rome/build"
+	"github.com/jwhitcraft/rome/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveAddr          string
+	serveMaxConcurrent int
+	serveRoot          string
+)
+
+// serveBuildRequest is the JSON body of POST /build
+type serveBuildRequest struct {
+	Source      string            `json:"source"`
+	Sources     []string          `json:"sources"`
+	Destination string            `json:"destination"`
+	Flavor      string            `json:"flavor"`
+	Version     string            `json:"version"`
+	Options     serveBuildOptions `json:"options"`
+}
+
+// serveBuildOptions are the optional parts of a build request, anything left out gets the
+// same default as the matching flag of the build command
+type serveBuildOptions struct {
+	FileWorkers    int      `json:"file_workers"`
+	FileBufferSize int      `json:"file_buffer_size"`
+	LinkWorkers    int      `json:"symlink_workers"`
+	LinkBufferSize int      `json:"symlink_buffer_size"`
+	WalkWorkers    int      `json:"walk_workers"`
+	OnConflict     string   `json:"on_conflict"`
+	LineEndings    string   `json:"line_endings"`
+	Symlinks       string   `json:"symlinks"`
+	SymlinkMode    string   `json:"symlink_mode"`
+	Include        []string `json:"include"`
+	Exclude        []string `json:"exclude"`
+	VerifyAfter    bool     `json:"verify_after"`
+	Minify         bool     `json:"minify"`
+	LintPHP        bool     `json:"lint_php"`
+}
+
+// buildServer runs builds for HTTP requests, at most limit of them at once. Nothing is
+// built outside of root.
+type buildServer struct {
+	limit chan struct{}
+	root  string
+}
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve [FLAGS]",
+	Short: "Run builds on demand over HTTP",
+	Long: `Keeps Rome running and builds whatever is sent to POST /build, the result of the build is returned as JSON.
+	GET /healthz answers once the server is up.
+
+There is no authentication, so it only listens on localhost unless --addr says otherwise. Every destination has
+to be under --root, a relative one is taken relative to it, and a request for anything else is refused.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if serveMaxConcurrent < 1 {
+			fmt.Println("--max-concurrent must be at least 1")
+			os.Exit(1)
+		}
+		root, err := serveRootDir(serveRoot)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		s := &buildServer{limit: make(chan struct{}, serveMaxConcurrent), root: root}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/build", s.handleBuild)
+		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, "ok")
+		})
+		server := &http.Server{Addr: serveAddr, Handler: mux}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			// builds that are running are cancelled through their requests
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			server.Shutdown(shutdownCtx)
+		}()
+
+		fmt.Printf("Serving builds into %s on %s, %d at a time\n", root, serveAddr, serveMaxConcurrent)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringVar(&serveAddr, "addr", "127.0.0.1:8080", "Address to listen on, anyone who can reach it can run builds")
+	serveCmd.Flags().StringVar(&serveRoot, "root", ".", "Folder every destination has to be in")
+	serveCmd.Flags().IntVar(&serveMaxConcurrent, "max-concurrent", 1, "How many builds can run at once, more requests wait their turn")
+}
+
+func (s *buildServer) handleBuild(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var req serveBuildRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "could not read the build request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := req.buildOptions()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Destination, err = s.destination(opts.Destination); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// wait for a turn, giving up if the client does
+	select {
+	case s.limit <- struct{}{}:
+		defer func() { <-s.limit }()
+	case <-r.Context().Done():
+		return
+	}
+
+	utils.Infof("building %v into %s (%s %s)", opts.Sources, opts.Destination, opts.Flavor, opts.Version)
+	if err := os.MkdirAll(opts.Destination, 0775); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result, err := build.Run(r.Context(), opts)
+	if err != nil && result == nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, build.ErrSourceMissing) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Failed > 0 || err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	json.NewEncoder(w).Encode(newBuildSummary(opts.Flavor, opts.Version, result))
+}
+
+// buildOptions validates the request and turns it into options for build.Run
+func (req serveBuildRequest) buildOptions() (build.Options, error) {
+	sources := req.Sources
+	if req.Source != "" {
+		sources = append([]string{req.Source}, sources...)
+	}
+	if len(sources) == 0 || req.Destination == "" || req.Flavor == "" || req.Version == "" {
+		return build.Options{}, fmt.Errorf("source, destination, flavor and version are required")
+	}
+	if _, ok := build.Flavors[req.Flavor]; !ok {
+		return build.Options{}, fmt.Errorf("unknown flavor %q", req.Flavor)
+	}
+
+	o := req.Options
+	opts := build.Options{
+		Sources:        sources,
+		Destination:    req.Destination,
+		Flavor:         req.Flavor,
+		Version:        req.Version,
+		FileWorkers:    defaultInt(o.FileWorkers, 40),
+		FileBufferSize: defaultInt(o.FileBufferSize, 4096),
+		LinkWorkers:    defaultInt(o.LinkWorkers, 5),
+		LinkBufferSize: defaultInt(o.LinkBufferSize, 2048),
+		WalkWorkers:    o.WalkWorkers,
+		VerifyAfter:    o.VerifyAfter,
+		Warnf:          utils.Warnf,
+		Debugf:         utils.Debugf,
+	}
+	var err error
+	if opts.OnConflict, err = build.ParseConflictPolicy(o.OnConflict); err != nil {
+		return opts, err
+	}
+	if opts.LineEndings, err = build.ParseLineEndings(o.LineEndings); err != nil {
+		return opts, err
+	}
+	if opts.Symlinks, err = o.symlinkMode(); err != nil {
+		return opts, err
+	}
+	if len(o.Include) > 0 || len(o.Exclude) > 0 {
+		if opts.Filter, err = build.NewFilter(o.Include, o.Exclude); err != nil {
+			return opts, err
+		}
+	}
+	if o.Minify {
+		opts.Processors = []build.Processor{build.Minifier()}
+	}
+	if o.LintPHP {
+		// requests only pick if php is run, never what runs
+		opts.LintPHP = "php"
+	}
+	return opts, nil
+}
+
+// symlinkMode reads symlink_mode, which takes the names of --symlink-mode, or the older symlinks
+// field. symlinks keeps what it always meant, so its copy still builds what the links point to.
+func (o serveBuildOptions) symlinkMode() (build.SymlinkMode, error) {
+	if o.Symlinks == "" {
+		return build.ParseSymlinkMode(o.SymlinkMode)
+	}
+	if o.SymlinkMode != "" {
+		return "", fmt.Errorf("symlinks and symlink_mode can not be used together")
+	}
+	switch o.Symlinks {
+	case "link":
+		return build.SymlinksPreserve, nil
+	case "copy":
+		return build.SymlinksDereference, nil
+	case "skip":
+		return build.SymlinksSkip, nil
+	}
+	return "", fmt.Errorf("unknown symlinks %q, must be one of link, copy or skip, or use symlink_mode", o.Symlinks)
+}
+
+// serveRootDir resolves the folder given with --root to an absolute path without symlinks
+func serveRootDir(root string) (string, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", fmt.Errorf("--root %s: %v", root, err)
+	}
+	if info, err := os.Stat(resolved); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("--root %s is not a folder", root)
+	}
+	return resolved, nil
+}
+
+// destination resolves the destination of a request, relative to the root when it is not
+// absolute. It is refused unless it ends up in the root, following the symlinks of the part
+// of it that already exists so none of them can lead out.
+func (s *buildServer) destination(dest string) (string, error) {
+	path := dest
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.root, path)
+	}
+	path = filepath.Clean(path)
+	// the part that does not exist yet can not be a symlink
+	existing, missing := path, ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			path = filepath.Join(resolved, missing)
+			break
+		}
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("destination %s: %v", dest, err)
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		missing = filepath.Join(filepath.Base(existing), missing)
+		existing = parent
+	}
+	if rel, err := filepath.Rel(s.root, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("destination %s is not in %s", dest, s.root)
+	}
+	return path, nil
+}
+
+// defaultInt returns def when n is not set
+func defaultInt(n int, def int) int {
+	if n <= 0 {
+		return def
+	}
+	return n
+}
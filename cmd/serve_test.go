@@ -0,0 +1,138 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jwhitcraft/rome/build"
+)
+
+func TestServeDestination(t *testing.T) {
+	root, err := serveRootDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "builds"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "builds", "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "builds"), filepath.Join(root, "inside")); err != nil {
+		t.Fatal(err)
+	}
+	s := &buildServer{root: root}
+
+	tests := []struct {
+		dest string
+		// want is where it is built relative to the root, empty when it is refused
+		want string
+	}{
+		{"ent", "ent"},
+		{"builds/ent/7.0", filepath.Join("builds", "ent", "7.0")},
+		{".", "."},
+		{filepath.Join(root, "builds", "pro"), filepath.Join("builds", "pro")},
+		{"inside/ult", filepath.Join("builds", "ult")},
+		{"builds/../ent", "ent"},
+		{"..", ""},
+		{"../sibling", ""},
+		{"builds/../../sibling", ""},
+		{outside, ""},
+		{"/etc", ""},
+		{"builds/escape", ""},
+		{"builds/escape/new/folder", ""},
+	}
+	for _, tt := range tests {
+		got, err := s.destination(tt.dest)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("destination(%q) = %q, want it refused", tt.dest, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("destination(%q): %v", tt.dest, err)
+			continue
+		}
+		if want := filepath.Join(root, tt.want); got != want {
+			t.Errorf("destination(%q) = %q, want %q", tt.dest, got, want)
+		}
+	}
+}
+
+func TestServeRefusesDestinationOutsideRoot(t *testing.T) {
+	root, err := serveRootDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "built")
+	s := &buildServer{limit: make(chan struct{}, 1), root: root}
+	body := `{"source": "` + t.TempDir() + `", "destination": "` + outside + `", "flavor": "ent", "version": "7.0"}`
+	w := httptest.NewRecorder()
+	s.handleBuild(w, httptest.NewRequest(http.MethodPost, "/build", strings.NewReader(body)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got %d %s, want 400", w.Code, w.Body)
+	}
+	if _, err := os.Stat(outside); !os.IsNotExist(err) {
+		t.Errorf("the destination outside the root was created: %v", err)
+	}
+}
+
+func TestServeSymlinkMode(t *testing.T) {
+	tests := []struct {
+		opts serveBuildOptions
+		want build.SymlinkMode
+	}{
+		{serveBuildOptions{}, build.SymlinksPreserve},
+		{serveBuildOptions{Symlinks: "link"}, build.SymlinksPreserve},
+		{serveBuildOptions{Symlinks: "copy"}, build.SymlinksDereference},
+		{serveBuildOptions{Symlinks: "skip"}, build.SymlinksSkip},
+		{serveBuildOptions{SymlinkMode: "copy"}, build.SymlinksCopy},
+		{serveBuildOptions{SymlinkMode: "dereference"}, build.SymlinksDereference},
+	}
+	for _, tt := range tests {
+		got, err := tt.opts.symlinkMode()
+		if err != nil {
+			t.Errorf("%+v: %v", tt.opts, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+
+	for _, opts := range []serveBuildOptions{
+		{Symlinks: "preserve"},
+		{Symlinks: "copy", SymlinkMode: "copy"},
+		{SymlinkMode: "link-ish"},
+	} {
+		if _, err := opts.symlinkMode(); err == nil {
+			t.Errorf("%+v: expected an error", opts)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/jwhitcraft/rome/build"
+	"github.com/jwhitcraft/rome/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statJSON    bool
+	statLargest int
+)
+
+// statCmd represents the stat command
+var statCmd = &cobra.Command{
+	Use:   "stat [FLAGS] SOURCE...",
+	Short: "Report what is in the sources without building anything",
+	Long: `Walks the sources the way a build would, leaving out the same folders and files, and reports
+how many files and bytes there are, a breakdown by extension, the symlinks, the deepest path and
+the largest files. Nothing is read, so it is quick enough to run before a build.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			fmt.Println("At least one SOURCE is required")
+			os.Exit(1)
+		}
+		filter, err := buildFilter()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		stats, err := build.Stat(build.Options{
+			Sources:     args,
+			Filter:      filter,
+			IgnoreFiles: !noIgnoreFile,
+			MaxDepth:    maxDepth + 1,
+			WalkWorkers: walkWorkers,
+			Warnf:       utils.Warnf,
+		}, statLargest)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if statJSON {
+			out, err := json.MarshalIndent(stats, "", "  ")
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Println(string(out))
+			return
+		}
+		printTreeStats(stats)
+	},
+}
+
+// printTreeStats writes the report of stat as text, the extensions with the most bytes first
+func printTreeStats(stats *build.TreeStats) {
+	fmt.Printf("%d files, %s, %d symlinks\n", stats.Files, megabytes(stats.Bytes), stats.Symlinks)
+	if stats.DeepestPath != "" {
+		fmt.Printf("Deepest path is %d levels: %s\n", stats.MaxDepth, stats.DeepestPath)
+	}
+
+	exts := make([]string, 0, len(stats.Extensions))
+	for ext := range stats.Extensions {
+		exts = append(exts, ext)
+	}
+	sort.Slice(exts, func(i, j int) bool {
+		a, b := stats.Extensions[exts[i]], stats.Extensions[exts[j]]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return exts[i] < exts[j]
+	})
+	if len(exts) > 0 {
+		fmt.Println("\nBy extension:")
+	}
+	for _, ext := range exts {
+		name := ext
+		if name == "" {
+			name = "(none)"
+		}
+		fmt.Printf("  %-12s %8d files %12s\n", name, stats.Extensions[ext].Files, megabytes(stats.Extensions[ext].Bytes))
+	}
+
+	if len(stats.Largest) > 0 {
+		fmt.Println("\nLargest files:")
+	}
+	for _, f := range stats.Largest {
+		fmt.Printf("  %12s  %s\n", megabytes(f.Bytes), f.Path)
+	}
+}
+
+func megabytes(bytes int64) string {
+	return fmt.Sprintf("%.1f MB", float64(bytes)/(1<<20))
+}
+
+func init() {
+	RootCmd.AddCommand(statCmd)
+
+	statCmd.Flags().BoolVar(&statJSON, "json", false, "Print the report as JSON")
+	statCmd.Flags().IntVar(&statLargest, "largest", 10, "How many of the largest files to list")
+	statCmd.Flags().StringArrayVar(&includes, "include", nil, "Only count files matching this glob, can be repeated")
+	statCmd.Flags().StringArrayVar(&excludes, "exclude", nil, "Do not count files or folders matching this glob, can be repeated, start it with ! to count matches of an earlier glob again")
+	statCmd.Flags().StringArrayVar(&includeFrom, "include-from", nil, "Read --include globs from this file, one per line, # starts a comment")
+	statCmd.Flags().StringArrayVar(&excludeFrom, "exclude-from", nil, "Read --exclude globs from this file, one per line, # starts a comment")
+	statCmd.Flags().BoolVar(&noIgnoreFile, "no-romeignore", false, "Do not leave out what the "+build.IgnoreFile+" in the root of a source matches")
+	statCmd.Flags().IntVar(&maxDepth, "max-depth", -1, "Only walk this many folders below each source (-1 for no limit)")
+	statCmd.Flags().IntVar(&walkWorkers, "walk-workers", 0, "Number of folders at the top of each source to walk at once (0 for one per CPU)")
+}
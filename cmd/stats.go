@@ -0,0 +1,44 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jwhitcraft/rome/build"
+)
+
+// printStats writes a snapshot of a running build to w
+func printStats(w io.Writer, s build.ProgressSnapshot) {
+	seconds := s.Elapsed.Seconds()
+	if seconds == 0 {
+		seconds = 1
+	}
+	fmt.Fprintf(w, "Rome: %d files done (%d failed), %d in flight, %.0f files/s, %.1f MB/s, running for %s\n",
+		s.Done, s.Failed, s.InFlight, float64(s.Done)/seconds, float64(s.Bytes)/seconds/(1<<20), s.Elapsed.Round(time.Second))
+	for id, path := range s.Workers {
+		if path != "" {
+			fmt.Fprintf(w, "  worker %d: %s\n", id, path)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/jwhitcraft/rome/build"
+)
+
+// buildSummary is the machine readable summary of a build
+type buildSummary struct {
+	Flavor         string   `json:"flavor"`
+	Version        string   `json:"version"`
+	Destination    string   `json:"destination,omitempty"`
+	Built          int32    `json:"built"`
+	Failed         int32    `json:"failed"`
+	Skipped        int32    `json:"skipped"`
+	Resumed        int32    `json:"resumed"`
+	Empty          int32    `json:"empty"`
+	SkippedBySize  int32    `json:"skipped_by_size"`
+	PrunedByDepth  int32    `json:"pruned_by_depth"`
+	Cached         int32    `json:"cached"`
+	BytesWritten   int64    `json:"bytes_written"`
+	BytesSkipped   int64    `json:"bytes_skipped"`
+	ElapsedSeconds float64  `json:"elapsed_seconds"`
+	Errors         []string `json:"errors,omitempty"`
+	Warnings       []string `json:"warnings,omitempty"`
+
+	Modules []moduleStats `json:"modules,omitempty"`
+
+	// MissingVersion are the files that use the version placeholder when no version was given
+	MissingVersion []string `json:"missing_version,omitempty"`
+
+	// FailedDirs are the destination folders that could not be created and how many files
+	// were left out of each
+	FailedDirs map[string]int `json:"failed_dirs,omitempty"`
+
+	// SkippedFiles are the files that were left out, only with --output json
+	SkippedFiles []string `json:"skipped_files,omitempty"`
+
+	// Stopped says why a build stopped before it was done
+	Stopped string `json:"stopped,omitempty"`
+}
+
+// newBuildSummary summarizes result for a build of flavor and version
+func newBuildSummary(flavor string, version string, result *build.Result) buildSummary {
+	summary := buildSummary{
+		Flavor:         flavor,
+		Version:        version,
+		Built:          result.Built,
+		Failed:         result.Failed,
+		Skipped:        result.Skipped,
+		Resumed:        result.Resumed,
+		Empty:          result.Empty,
+		SkippedBySize:  result.SkippedBySize,
+		PrunedByDepth:  result.PrunedByDepth,
+		Cached:         result.Cached,
+		BytesWritten:   result.BytesWritten,
+		BytesSkipped:   result.BytesSkipped,
+		ElapsedSeconds: result.Elapsed.Seconds(),
+		MissingVersion: result.MissingVersion,
+		FailedDirs:     result.FailedDirs,
+	}
+	for _, buildErr := range result.Errors {
+		summary.Errors = append(summary.Errors, buildErr.Error())
+	}
+	for _, warning := range result.Warnings {
+		summary.Warnings = append(summary.Warnings, warning.String())
+	}
+	return summary
+}
+
+// writeSummary saves the summary of result, broken down by modules, as JSON to path
+func writeSummary(path string, result *build.Result, modules []moduleStats) error {
+	summary := newBuildSummary(flavor, version, result)
+	summary.Destination = destination
+	summary.Modules = modules
+	out, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(out, '\n'), 0644)
+}
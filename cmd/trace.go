@@ -0,0 +1,131 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jwhitcraft/rome/build"
+)
+
+// traceEvent is a complete event in the Chrome trace format, times are in microseconds
+type traceEvent struct {
+	Name string           `json:"name"`
+	Cat  string           `json:"cat"`
+	Ph   string           `json:"ph"`
+	Ts   int64            `json:"ts"`
+	Dur  int64            `json:"dur"`
+	Pid  int              `json:"pid"`
+	Tid  int              `json:"tid"`
+	Args map[string]int64 `json:"args"`
+}
+
+// traceWriter records how long every file took, either as CSV or as a Chrome trace that can be
+// loaded in chrome://tracing or Perfetto. It is fed from the build's results, which are handed
+// over one at a time, so the workers never wait on it.
+type traceWriter struct {
+	f       *os.File
+	w       *bufio.Writer
+	csv     *csv.Writer
+	started time.Time
+	events  int
+	// lanes holds when the last event in each row of the trace ends, so overlapping files
+	// are drawn on different rows
+	lanes []time.Time
+}
+
+// newTraceWriter creates path, a .csv extension writes CSV and anything else a Chrome trace
+func newTraceWriter(path string, started time.Time) (*traceWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &traceWriter{f: f, w: bufio.NewWriter(f), started: started}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		t.csv = csv.NewWriter(t.w)
+		t.csv.Write([]string{"path", "size", "start_us", "duration_us", "failed"})
+	} else {
+		t.w.WriteString("[\n")
+	}
+	return t, nil
+}
+
+// Add records a single file result
+func (t *traceWriter) Add(r build.FileResult) {
+	start := r.Started.Sub(t.started).Microseconds()
+	if t.csv != nil {
+		t.csv.Write([]string{r.Path, strconv.FormatInt(r.Size, 10), strconv.FormatInt(start, 10),
+			strconv.FormatInt(r.Duration.Microseconds(), 10), strconv.FormatBool(r.Err != nil)})
+		return
+	}
+
+	event, err := json.Marshal(traceEvent{
+		Name: r.Path,
+		Cat:  "file",
+		Ph:   "X",
+		Ts:   start,
+		Dur:  r.Duration.Microseconds(),
+		Pid:  1,
+		Tid:  t.lane(r.Started, r.Started.Add(r.Duration)),
+		Args: map[string]int64{"size": r.Size, "bytes": r.Bytes},
+	})
+	if err != nil {
+		return
+	}
+	if t.events > 0 {
+		t.w.WriteString(",\n")
+	}
+	t.w.Write(event)
+	t.events++
+}
+
+// lane picks the first row of the trace that is free at start
+func (t *traceWriter) lane(start time.Time, end time.Time) int {
+	for i, busyUntil := range t.lanes {
+		if !busyUntil.After(start) {
+			t.lanes[i] = end
+			return i + 1
+		}
+	}
+	t.lanes = append(t.lanes, end)
+	return len(t.lanes)
+}
+
+// Close finishes the trace and closes the file
+func (t *traceWriter) Close() error {
+	if t.csv != nil {
+		t.csv.Flush()
+	} else {
+		t.w.WriteString("\n]\n")
+	}
+	if err := t.w.Flush(); err != nil {
+		t.f.Close()
+		return err
+	}
+	return t.f.Close()
+}
@@ -0,0 +1,171 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jwhitcraft/rome/build"
+)
+
+const (
+	// tuiErrorLines is how many of the latest errors the error pane shows
+	tuiErrorLines = 5
+	// tuiWidth is where long paths are cut off
+	tuiWidth = 78
+)
+
+// buildTUI redraws a small status view of a running build in place on a terminal: the
+// overall counts, throughput, the files being worked on and the latest errors
+type buildTUI struct {
+	out     io.Writer
+	started time.Time
+
+	mu       sync.Mutex
+	active   map[string]time.Time
+	done     int
+	failed   int
+	skipped  int
+	bytes    int64
+	errors   []string
+	drawn    int
+	stop     chan struct{}
+	finished chan struct{}
+}
+
+func newBuildTUI(out io.Writer) *buildTUI {
+	return &buildTUI{
+		out:      out,
+		started:  time.Now(),
+		active:   make(map[string]time.Time),
+		stop:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+}
+
+// Start redraws the view every 100ms until Stop is called
+func (t *buildTUI) Start() {
+	go func() {
+		defer close(t.finished)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.draw()
+			case <-t.stop:
+				t.draw()
+				return
+			}
+		}
+	}()
+}
+
+// Stop draws the view one last time and leaves it on the terminal
+func (t *buildTUI) Stop() {
+	close(t.stop)
+	<-t.finished
+}
+
+// Started is told about every file a worker starts on
+func (t *buildTUI) Started(source string) {
+	t.mu.Lock()
+	t.active[source] = time.Now()
+	t.mu.Unlock()
+}
+
+// Add records a single file result
+func (t *buildTUI) Add(r build.FileResult) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.active, r.Source)
+	t.done++
+	t.bytes += r.Bytes
+	switch {
+	case r.Err != nil:
+		t.failed++
+		t.errors = append(t.errors, r.Err.Error())
+		if len(t.errors) > tuiErrorLines {
+			t.errors = t.errors[1:]
+		}
+	case r.Skipped:
+		t.skipped++
+	}
+}
+
+func (t *buildTUI) draw() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	elapsed := time.Since(t.started)
+	rate := float64(t.done) / elapsed.Seconds()
+	lines := []string{
+		fmt.Sprintf("Rome %s: %d files done, %d failed, %d skipped in %s", flavor, t.done, t.failed, t.skipped, elapsed.Round(time.Second)),
+		fmt.Sprintf("%.0f files/s, %.1f MB/s", rate, float64(t.bytes)/elapsed.Seconds()/(1<<20)),
+		fmt.Sprintf("Working on %d files:", len(t.active)),
+	}
+
+	// the files that have been going the longest are the interesting ones
+	active := make([]string, 0, len(t.active))
+	for source := range t.active {
+		active = append(active, source)
+	}
+	sort.Slice(active, func(i, j int) bool { return t.active[active[i]].Before(t.active[active[j]]) })
+	for i, source := range active {
+		if i == 10 {
+			lines = append(lines, fmt.Sprintf("  ...and %d more", len(active)-i))
+			break
+		}
+		lines = append(lines, "  "+source+" ("+time.Since(t.active[source]).Round(time.Millisecond).String()+")")
+	}
+	if len(t.errors) > 0 {
+		lines = append(lines, "Latest errors:")
+		for _, err := range t.errors {
+			lines = append(lines, "  "+err)
+		}
+	}
+
+	var screen strings.Builder
+	if t.drawn > 0 {
+		// go back to the top of what was drawn last time
+		fmt.Fprintf(&screen, "\x1b[%dA", t.drawn)
+	}
+	for _, line := range lines {
+		if len(line) > tuiWidth {
+			line = line[:tuiWidth-3] + "..."
+		}
+		screen.WriteString("\x1b[2K" + line + "\n")
+	}
+	// clear whatever is left over from a longer view
+	for i := len(lines); i < t.drawn; i++ {
+		screen.WriteString("\x1b[2K\n")
+	}
+	if extra := t.drawn - len(lines); extra > 0 {
+		fmt.Fprintf(&screen, "\x1b[%dA", extra)
+	}
+	t.drawn = len(lines)
+	io.WriteString(t.out, screen.String())
+}
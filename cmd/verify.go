@@ -0,0 +1,116 @@
+// Copyright © 2017 Jon Whitcraft
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/jwhitcraft/rome/build"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyFlavor  string
+	verifyVersion string
+)
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify [FLAGS] DESTINATION",
+	Short: "Check that a destination still matches what its sources build",
+	Long: `Builds the sources recorded in the build stamp (` + build.StampFile + `) of DESTINATION in memory, with
+the flavor and version they were built with, and compares every file to the one in DESTINATION.
+Files that are missing from DESTINATION, stale because their source changed since the build or
+modified in DESTINATION after the build are listed, and rome exits with 1 if there are any.
+Telling stale and modified files apart needs the manifest of the build (` + build.ManifestFile + `), written by
+rome build --manifest.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			fmt.Println("Exactly one DESTINATION is required")
+			os.Exit(1)
+		}
+		if build.IsRemote(args[0]) {
+			fmt.Println("verify needs a local destination")
+			os.Exit(1)
+		}
+		stamp, err := readRebuildStamp(args[0])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		stampFlavor := stamp.Flavor
+		if verifyFlavor != "" {
+			stampFlavor = verifyFlavor
+		}
+		stampVersion := stamp.Version
+		if cmd.Flags().Changed("version") {
+			stampVersion = verifyVersion
+		}
+		// set like flags on the command line so the config file does not override them, the
+		// dry run keeps the build from touching the destination
+		buildCmd.Flags().Set("destination", args[0])
+		buildCmd.Flags().Set("flavor", stampFlavor)
+		buildCmd.Flags().Set("version", stampVersion)
+		buildCmd.Flags().Set("dry-run", "true")
+		buildCmd.PreRun(buildCmd, stamp.Sources)
+		runVerify()
+	},
+}
+
+// runVerify compares the destination with what the sources build and lists every file that
+// does not match
+func runVerify() {
+	fmt.Printf("Verifying %s against %s (%s %s)\n", destination, strings.Join(sources, ", "), flavor, version)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	verification, err := build.Verify(ctx, dryRunOptions(nil))
+	if err != nil && (verification == nil || verification.Result == nil) {
+		fmt.Println(err)
+		os.Exit(exitBuildFailed)
+	}
+
+	for _, file := range verification.Mismatched {
+		fmt.Printf("  %-8s  %s\n", file.Status, file.Path)
+	}
+	fmt.Printf("%d of %d files in %s match their sources\n", verification.Files-len(verification.Mismatched), verification.Files, destination)
+	if len(verification.Result.Failures) > 0 {
+		reportErrors(verification.Result.Failures, maxErrorsShown)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(exitInterrupted)
+	}
+	if len(verification.Mismatched) > 0 || len(verification.Result.Failures) > 0 {
+		os.Exit(exitBuildFailed)
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().StringVarP(&verifyFlavor, "flavor", "f", "", "Verify against this flavor instead of the one in the stamp")
+	verifyCmd.Flags().StringVarP(&verifyVersion, "version", "v", "", "Verify against this version instead of the one in the stamp")
+}
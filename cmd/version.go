@@ -29,6 +29,7 @@ import (
 var (
 	Version   = "1.0.0"
 	BuildTime = "2015-10-03T11:08:49+0200"
+	Commit    = ""
 )
 
 // versionCmd represents the version command
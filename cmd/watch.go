@@ -21,29 +21,253 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
+	"github.com/jwhitcraft/rome/build"
+	"github.com/jwhitcraft/rome/utils"
 	"github.com/spf13/cobra"
 )
 
+var watchSettle time.Duration
+
 // watchCmd represents the watch command
 var watchCmd = &cobra.Command{
 	Use:   "watch [OPTIONS] SOURCE-PATH",
 	Short: "Watch for FS Changes and Built Out the files",
-	Long: `Currently Not Implmented, The plan is to create a utility like build monitor but inside of rome`,
+	Long: `Builds SOURCE-PATH into the destination once and then watches it, every file that is created or
+changed is built again and every file that is removed is removed from the destination. Changes
+are collected until nothing changed for --settle, so saving many files at once is a single build.
+node_modules and .git folders are not watched. --metrics-addr serves Prometheus metrics for as
+long as it watches, they add up every build since it started.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		// TODO: Work your own magic here
-		fmt.Println("Nothing to see hear yet")
+		if len(args) != 1 {
+			fmt.Println("A single SOURCE-PATH is required")
+			os.Exit(1)
+		}
+		source := filepath.Clean(args[0])
+		if info, err := os.Stat(source); err != nil || !info.IsDir() {
+			fmt.Printf("\n\nSource Path (%s) does not exists!!\n\n", source)
+			os.Exit(401)
+		}
+		if destination == "" {
+			fmt.Println("--destination is required")
+			os.Exit(1)
+		}
+		if _, ok := build.Flavors[flavor]; !ok {
+			fmt.Printf("Unknown flavor: %s\n", flavor)
+			os.Exit(1)
+		}
+		if err := build.Define(defines); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		setBuildInfo(source)
+
+		if clean {
+			ok, err := confirmDelete(destination, assumeYes)
+			if err != nil {
+				fmt.Printf("Could Not Read %s: %v\n", destination, err)
+				os.Exit(1)
+			}
+			if !ok {
+				fmt.Println("Not cleaning " + destination + ", aborting")
+				os.Exit(1)
+			}
+			fmt.Println("Cleaning " + destination)
+			if err := build.CleanBuild(destination); err != nil {
+				fmt.Println("Could Not Clean: " + destination)
+				os.Exit(1)
+			}
+		}
+		if err := build.MakeDirs(destination, 0); err != nil {
+			fmt.Printf("Could Not Create %s: %v\n", destination, err)
+			os.Exit(1)
+		}
+
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			fmt.Printf("Could Not Watch %s: %v\n", source, err)
+			os.Exit(1)
+		}
+		defer watcher.Close()
+		if _, err := watchTree(watcher, source); err != nil {
+			fmt.Printf("Could Not Watch %s: %v\n", source, err)
+			os.Exit(1)
+		}
+
+		opts := build.Options{
+			Sources:     []string{source},
+			Destination: destination,
+			Flavor:      flavor,
+			Version:     version,
+			IgnoreFiles: true,
+			Warnf:       utils.Warnf,
+			Debugf:      utils.Debugf,
+		}
+		var metrics *buildMetrics
+		if metricsAddr != "" {
+			metrics = newBuildMetrics()
+			opts.OnResult = metrics.Add
+			metricsCtx, cancel := context.WithCancel(context.Background())
+			done := serveMetrics(metricsCtx, metricsAddr, metrics)
+			defer func() {
+				cancel()
+				<-done
+			}()
+			fmt.Printf("Serving metrics on %s\n", metricsAddr)
+		}
+		fmt.Println("Building " + source + " into " + destination)
+		watchBuild(opts, metrics)
+
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		fmt.Println("Watching " + source + " for changes, press Ctrl+C to stop")
+		changed := make(map[string]bool)
+		var settled <-chan time.Time
+		for {
+			select {
+			case event := <-watcher.Events:
+				if watchEvent(watcher, source, event, changed) {
+					settled = time.After(watchSettle)
+				}
+			case err := <-watcher.Errors:
+				utils.Warnf("watching %s: %v", source, err)
+			case <-settled:
+				settled = nil
+				if len(changed) == 0 {
+					continue
+				}
+				opts.Paths = make([]string, 0, len(changed))
+				for rel := range changed {
+					opts.Paths = append(opts.Paths, rel)
+				}
+				sort.Strings(opts.Paths)
+				changed = make(map[string]bool)
+				watchBuild(opts, metrics)
+			case <-interrupt:
+				fmt.Println("Stopped watching " + source)
+				return
+			}
+		}
 	},
 }
 
+// watchEvent records what event changed in changed, relative to source, and removes what was
+// removed from the source from the destination. It returns false when the event is ignored.
+func watchEvent(watcher *fsnotify.Watcher, source string, event fsnotify.Event, changed map[string]bool) bool {
+	rel, err := filepath.Rel(source, event.Name)
+	if err != nil || rel == "." || unwatched(rel) {
+		return false
+	}
+	info, err := os.Lstat(event.Name)
+	if os.IsNotExist(err) {
+		// removed or renamed away, a rename shows up as a create of the new name
+		delete(changed, rel)
+		dest := filepath.Join(destination, rel)
+		if _, err := os.Lstat(dest); err != nil {
+			return false
+		}
+		if err := os.RemoveAll(dest); err != nil {
+			utils.Warnf("could not remove %s: %v", dest, err)
+			return false
+		}
+		fmt.Println("Removed " + rel)
+		return true
+	}
+	if err != nil {
+		utils.Warnf("could not read %s: %v", event.Name, err)
+		return false
+	}
+	if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+		return false
+	}
+	if !info.IsDir() {
+		changed[rel] = true
+		return true
+	}
+	if event.Op&fsnotify.Create == 0 {
+		return false
+	}
+	// files can land in a new folder before it is watched, so build everything in it
+	files, err := watchTree(watcher, event.Name)
+	if err != nil {
+		utils.Warnf("could not watch %s: %v", event.Name, err)
+	}
+	for _, path := range files {
+		if rel, err := filepath.Rel(source, path); err == nil {
+			changed[rel] = true
+		}
+	}
+	return true
+}
+
+// watchTree adds root and every folder below it to watcher, it returns the files it found
+func watchTree(watcher *fsnotify.Watcher, root string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(root, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !f.IsDir() {
+			files = append(files, path)
+			return nil
+		}
+		if path != root && unwatched(f.Name()) {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+	return files, err
+}
+
+// unwatched checks if rel is in a folder that is never watched
+func unwatched(rel string) bool {
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if part == "node_modules" || part == ".git" {
+			return true
+		}
+	}
+	return false
+}
+
+// watchBuild builds what opts asks for and reports how it went, failures are printed and
+// watching goes on. The build is added to metrics when they are served.
+func watchBuild(opts build.Options, metrics *buildMetrics) {
+	start := time.Now()
+	result, err := build.Run(context.Background(), opts)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if metrics != nil {
+		metrics.ObserveBuild(result.Elapsed)
+	}
+	fmt.Printf("Built %d files in %s\n", result.Built, time.Since(start).Round(time.Millisecond))
+	if len(result.Failures) > 0 {
+		reportErrors(result.Failures, 20)
+	}
+}
+
 func init() {
 	RootCmd.AddCommand(watchCmd)
 
 	watchCmd.Flags().StringVarP(&destination,"destination", "d", "", "Where should the built files be put")
 	watchCmd.Flags().StringVarP(&version, "version", "v", "","What Version is being built")
 	watchCmd.Flags().StringVarP(&flavor, "flavor", "f", "ent","What Flavor of SugarCRM to build")
+	addBuildInfoFlags(watchCmd)
+	watchCmd.Flags().StringSliceVar(&defines, "define", nil, "Define a tag for BEGIN and FILE conditions to check besides flav, e.g. feature=newdash,license=aws")
 	watchCmd.Flags().BoolVar(&clean, "clean", false, "Remove Existing Build Before Building")
+	watchCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Do not ask before --clean deletes an existing build")
+	watchCmd.Flags().StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this address for as long as it watches, e.g. :9090")
+	watchCmd.Flags().DurationVar(&watchSettle, "settle", 200*time.Millisecond, "How long nothing has to change before the changed files are built")
 
 	watchCmd.MarkFlagRequired("version")
 	watchCmd.MarkFlagRequired("flavor")
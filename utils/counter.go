@@ -14,3 +14,16 @@ func (c *Counter) Get() int32 {
 	return atomic.LoadInt32((*int32)(c))
 }
 // End Counter Setup
+
+// ByteCounter is an atomic running total of bytes
+type ByteCounter int64
+
+// Add adds n bytes and returns the new total
+func (c *ByteCounter) Add(n int64) int64 {
+	return atomic.AddInt64((*int64)(c), n)
+}
+
+// Get returns the total so far
+func (c *ByteCounter) Get() int64 {
+	return atomic.LoadInt64((*int64)(c))
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// Level controls which log messages get printed
+type Level int32
+
+const (
+	LevelError Level = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+)
+
+var logLevel = int32(LevelInfo)
+
+// SetLogLevel changes which messages are printed, everything at or below level is shown
+func SetLogLevel(level Level) {
+	atomic.StoreInt32(&logLevel, int32(level))
+}
+
+// LogEnabled reports if messages at level are printed
+func LogEnabled(level Level) bool {
+	return Level(atomic.LoadInt32(&logLevel)) >= level
+}
+
+func logf(level Level, prefix string, format string, args ...interface{}) {
+	if !LogEnabled(level) {
+		return
+	}
+	fmt.Fprintf(os.Stderr, prefix+format+"\n", args...)
+}
+
+func Debugf(format string, args ...interface{}) {
+	logf(LevelDebug, "[debug] ", format, args...)
+}
+
+func Infof(format string, args ...interface{}) {
+	logf(LevelInfo, "", format, args...)
+}
+
+func Warnf(format string, args ...interface{}) {
+	logf(LevelWarn, "[warn] ", format, args...)
+}
+
+func Errorf(format string, args ...interface{}) {
+	logf(LevelError, "[error] ", format, args...)
+}
@@ -0,0 +1,16 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ParseMode parses octal permissions such as 0644 or 755
+func ParseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("mode %q is not valid, use octal permissions like 0644", s)
+	}
+	return os.FileMode(mode), nil
+}
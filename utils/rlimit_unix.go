@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package utils
+
+import "syscall"
+
+// OpenFileLimit returns the soft limit on open files for the process, false when it is not known
+func OpenFileLimit() (uint64, bool) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, false
+	}
+	return uint64(limit.Cur), true
+}
@@ -0,0 +1,6 @@
+package utils
+
+// OpenFileLimit returns the soft limit on open files for the process, Windows has no such limit
+func OpenFileLimit() (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits are the unit prefixes ParseSize understands, each is 1024 times the one before
+var sizeUnits = []string{"k", "m", "g", "t"}
+
+// ParseSize parses a human size such as 512, 10MB, 1.5g or 64KiB into bytes. Units are
+// powers of 1024 and are not case sensitive.
+func ParseSize(s string) (int64, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "ib"), "b")
+	multiplier := int64(1)
+	for i, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit) {
+			value = strings.TrimSuffix(value, unit)
+			multiplier = int64(1) << (10 * uint(i+1))
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+		return 0, fmt.Errorf("size %q is not valid, use a number of bytes with an optional unit like 10MB", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
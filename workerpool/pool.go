@@ -0,0 +1,150 @@
+// Package workerpool runs a handler over a stream of items with a fixed number of goroutines.
+package workerpool
+
+import (
+	"context"
+	"sync"
+)
+
+// Pool hands every submitted item to one of its workers
+type Pool[T any] struct {
+	ctx     context.Context
+	items   chan T
+	handler func(int, T) error
+
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+	errs []error
+
+	// limit is how many of the workers pick up items, the ones numbered limit and above wait
+	// on turns until it is raised or the pool stops
+	workers int
+	limit   int
+	stopped bool
+	turns   *sync.Cond
+	done    chan struct{}
+}
+
+// New starts workers goroutines that call handler for every item submitted, buffer is how
+// many items can be queued before Submit blocks. Once ctx is done the workers stop picking
+// up new items, items already being handled are allowed to finish.
+func New[T any](ctx context.Context, workers int, buffer int, handler func(T) error) *Pool[T] {
+	return NewWithID(ctx, workers, buffer, func(_ int, item T) error { return handler(item) })
+}
+
+// NewWithID is New for handlers that need to know which worker, numbered from zero, is
+// handling the item
+func NewWithID[T any](ctx context.Context, workers int, buffer int, handler func(int, T) error) *Pool[T] {
+	p := &Pool[T]{
+		ctx:     ctx,
+		items:   make(chan T, buffer),
+		handler: handler,
+		workers: workers,
+		limit:   workers,
+		done:    make(chan struct{}),
+	}
+	p.turns = sync.NewCond(&p.mu)
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.work(i)
+	}
+	// wake up the workers waiting on the limit once there is nothing left for them to do
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-p.done:
+		}
+		p.mu.Lock()
+		p.stopped = true
+		p.mu.Unlock()
+		p.turns.Broadcast()
+	}()
+	return p
+}
+
+// SetLimit lets only n of the workers pick up items, the others wait until the limit is
+// raised again. Items a worker is already handling are finished. n is kept between one and
+// the number of workers the pool was started with.
+func (p *Pool[T]) SetLimit(n int) {
+	if n > p.workers {
+		n = p.workers
+	}
+	if n < 1 {
+		n = 1
+	}
+	p.mu.Lock()
+	p.limit = n
+	p.mu.Unlock()
+	p.turns.Broadcast()
+}
+
+// Limit returns how many of the workers pick up items
+func (p *Pool[T]) Limit() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.limit
+}
+
+// waitTurn blocks worker id while it is over the limit
+func (p *Pool[T]) waitTurn(id int) {
+	p.mu.Lock()
+	for id >= p.limit && !p.stopped {
+		p.turns.Wait()
+	}
+	p.mu.Unlock()
+}
+
+func (p *Pool[T]) work(id int) {
+	defer p.wg.Done()
+	for {
+		p.waitTurn(id)
+
+		// check the context first so a cancel wins over a full queue
+		select {
+		case <-p.ctx.Done():
+			return
+		default:
+		}
+
+		select {
+		case item, ok := <-p.items:
+			if !ok {
+				return
+			}
+			if err := p.handler(id, item); err != nil {
+				p.mu.Lock()
+				p.errs = append(p.errs, err)
+				p.mu.Unlock()
+			}
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// Submit queues item for the workers, it returns false without queueing when the context is done
+func (p *Pool[T]) Submit(item T) bool {
+	select {
+	case <-p.ctx.Done():
+		return false
+	default:
+	}
+
+	select {
+	case p.items <- item:
+		return true
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
+// Wait stops accepting items, waits for the workers to finish and returns every error the
+// handler returned. Submit must not be called after Wait.
+func (p *Pool[T]) Wait() []error {
+	close(p.items)
+	close(p.done)
+	p.wg.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.errs
+}
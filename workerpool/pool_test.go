@@ -0,0 +1,243 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolHandlesEveryItem(t *testing.T) {
+	var sum atomic.Int64
+	p := New(context.Background(), 8, 4, func(n int) error {
+		sum.Add(int64(n))
+		return nil
+	})
+	for i := 1; i <= 1000; i++ {
+		if !p.Submit(i) {
+			t.Fatalf("Submit(%d) refused an item", i)
+		}
+	}
+	if errs := p.Wait(); len(errs) != 0 {
+		t.Fatalf("Wait() = %v, want no errors", errs)
+	}
+	if got := sum.Load(); got != 500500 {
+		t.Errorf("handled items add up to %d, want 500500", got)
+	}
+}
+
+func TestPoolAggregatesErrors(t *testing.T) {
+	p := New(context.Background(), 4, 0, func(n int) error {
+		if n%3 == 0 {
+			return fmt.Errorf("item %d", n)
+		}
+		return nil
+	})
+	for i := 1; i <= 30; i++ {
+		p.Submit(i)
+	}
+	errs := p.Wait()
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	sort.Strings(got)
+	var want []string
+	for i := 3; i <= 30; i += 3 {
+		want = append(want, fmt.Sprintf("item %d", i))
+	}
+	sort.Strings(want)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Wait() = %v, want %v", got, want)
+	}
+}
+
+func TestPoolKeepsErrorChains(t *testing.T) {
+	errBad := errors.New("bad item")
+	p := New(context.Background(), 2, 2, func(n int) error {
+		return fmt.Errorf("item %d: %w", n, errBad)
+	})
+	p.Submit(1)
+	p.Submit(2)
+	errs := p.Wait()
+	if len(errs) != 2 {
+		t.Fatalf("Wait() = %v, want 2 errors", errs)
+	}
+	for _, err := range errs {
+		if !errors.Is(err, errBad) {
+			t.Errorf("%v does not wrap the handler error", err)
+		}
+	}
+}
+
+func TestPoolCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	var handled atomic.Int32
+	p := New(ctx, 2, 8, func(n int) error {
+		started <- struct{}{}
+		<-release
+		handled.Add(1)
+		return nil
+	})
+	// both workers are busy and the rest of the items wait in the queue
+	for i := 0; i < 10; i++ {
+		if !p.Submit(i) {
+			t.Fatalf("Submit(%d) refused an item before the cancel", i)
+		}
+	}
+	<-started
+	<-started
+	cancel()
+	if p.Submit(10) {
+		t.Error("Submit queued an item after the cancel")
+	}
+	close(release)
+
+	done := make(chan []error)
+	go func() { done <- p.Wait() }()
+	select {
+	case errs := <-done:
+		if len(errs) != 0 {
+			t.Errorf("Wait() = %v, want no errors", errs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not return after the cancel")
+	}
+	// the items being handled finish, the queued ones are dropped
+	if got := handled.Load(); got != 2 {
+		t.Errorf("%d items were handled after the cancel, want the 2 already started", got)
+	}
+}
+
+func TestPoolCancelUnblocksSubmit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	p := New(ctx, 1, 0, func(n int) error {
+		<-release
+		return nil
+	})
+	p.Submit(0)
+	refused := make(chan bool)
+	go func() { refused <- !p.Submit(1) }()
+	cancel()
+	select {
+	case ok := <-refused:
+		if !ok {
+			// the worker could only have taken it if it was free, and it is not
+			t.Error("Submit queued an item after the cancel")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Submit stayed blocked after the cancel")
+	}
+	close(release)
+	p.Wait()
+}
+
+func TestPoolSetLimitWhileSubmitting(t *testing.T) {
+	var (
+		raised    atomic.Bool
+		handled   atomic.Int32
+		mu        sync.Mutex
+		active    int
+		maxActive int
+		early     []int
+	)
+	block := make(chan struct{})
+	p := NewWithID(context.Background(), 4, 0, func(id int, n int) error {
+		mu.Lock()
+		if !raised.Load() && id != 0 {
+			early = append(early, id)
+		}
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		if n == 0 {
+			<-block
+		}
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		handled.Add(1)
+		return nil
+	})
+	p.SetLimit(1)
+	if got := p.Limit(); got != 1 {
+		t.Fatalf("Limit() = %d after SetLimit(1)", got)
+	}
+
+	submitted := make(chan struct{})
+	go func() {
+		defer close(submitted)
+		for i := 0; i < 200; i++ {
+			p.Submit(i)
+		}
+	}()
+	// worker 0 is stuck on item 0 and nobody else may take the next item until the raise
+	time.Sleep(20 * time.Millisecond)
+	if got := handled.Load(); got != 0 {
+		t.Fatalf("%d items were handled while the only allowed worker was busy", got)
+	}
+	raised.Store(true)
+	p.SetLimit(4)
+	close(block)
+	<-submitted
+	p.SetLimit(2)
+	if errs := p.Wait(); len(errs) != 0 {
+		t.Fatalf("Wait() = %v, want no errors", errs)
+	}
+
+	if got := handled.Load(); got != 200 {
+		t.Errorf("%d items were handled, want 200", got)
+	}
+	if len(early) > 0 {
+		t.Errorf("workers %v handled items before the limit was raised", early)
+	}
+	if maxActive < 2 {
+		t.Errorf("at most %d items were handled at once after raising the limit to 4", maxActive)
+	}
+}
+
+func TestPoolSetLimitBounds(t *testing.T) {
+	p := New(context.Background(), 3, 0, func(int) error { return nil })
+	defer p.Wait()
+	for _, tt := range []struct{ set, want int }{{0, 1}, {-5, 1}, {2, 2}, {3, 3}, {10, 3}} {
+		p.SetLimit(tt.set)
+		if got := p.Limit(); got != tt.want {
+			t.Errorf("SetLimit(%d) gave a limit of %d, want %d", tt.set, got, tt.want)
+		}
+	}
+}
+
+func TestPoolWaitReleasesWorkersOverTheLimit(t *testing.T) {
+	var handled atomic.Int32
+	p := New(context.Background(), 4, 16, func(int) error {
+		handled.Add(1)
+		return nil
+	})
+	p.SetLimit(1)
+	for i := 0; i < 16; i++ {
+		p.Submit(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		p.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not return with workers waiting on the limit")
+	}
+	if got := handled.Load(); got != 16 {
+		t.Errorf("%d items were handled, want 16", got)
+	}
+}